@@ -7,7 +7,10 @@
 import {
     commands,
     ExtensionContext,
+    Location,
     LogLevel,
+    Position,
+    Range,
     RelativePattern,
     TextDocument,
     Uri,
@@ -45,6 +48,23 @@ async function open_document(uri: Uri) {
     return uri;
 }
 
+// arguments of the code lenses are plain LSP objects, which are converted before showing them
+type LspPosition = { line: number; character: number };
+type LspLocation = { uri: string; range: { start: LspPosition; end: LspPosition } };
+
+function show_references(uri: string, position: LspPosition, locations: LspLocation[]) {
+    const to_position = (p: LspPosition) => new Position(p.line, p.character);
+    return commands.executeCommand(
+        "editor.action.showReferences",
+        Uri.parse(uri),
+        to_position(position),
+        locations.map((l) => new Location(
+            Uri.parse(l.uri),
+            new Range(to_position(l.range.start), to_position(l.range.end))
+        ))
+    );
+}
+
 async function start_client_for_folder(
     folder: WorkspaceFolder,
     ctx: ExtensionContext
@@ -90,7 +110,15 @@ async function start_client_for_folder(
         documentSelector: [
             { language: "ftl", pattern: `${root.fsPath}/**/*.ftl`, scheme: "file" },
         ],
+        initializationOptions: {
+            templateRoots: workspace.getConfiguration("freemarker", folder).get("templateRoots", []),
+            logLevel: workspace.getConfiguration("freemarker", folder).get("logLevel", "info"),
+            maxFileSizeBytes: workspace.getConfiguration("freemarker", folder).get("maxFileSizeBytes", 2097152),
+            parseTimeoutMs: workspace.getConfiguration("freemarker", folder).get("parseTimeoutMs", 1000),
+        },
         synchronize: {
+            // "workspace/didChangeConfiguration" carries the "freemarker" section
+            configurationSection: "freemarker",
             fileEvents: [
                 createChangeWatcher,
                 deleteWatcher
@@ -162,6 +190,10 @@ export async function activate(context: ExtensionContext): Promise<void> {
         await start_client_for_folder(folder, context);
     }
 
+    context.subscriptions.push(
+        commands.registerCommand("freemarker.showReferences", show_references)
+    );
+
     commands.registerCommand("freemarker.restart", async () => {
         const currentFolder = workspace.workspaceFolders?.[0].uri.toString();
         if (!currentFolder) {
@@ -7,7 +7,7 @@ use std::path::Path;
 fn main() {
     #[cfg(feature = "dev")]
     {
-        use codegen::Scope;
+        use codegen::{Block, Scope};
         use convert_case::{Case, Casing};
         use serde::{
             Deserialize, Serialize,
@@ -132,11 +132,12 @@ This file is auto-generated by build.rs, which is not intended for manual editin
         let rules = grammar_json.get_mut("rules").unwrap();
         assert!(rules.is_object());
         let rules = rules.as_object_mut().unwrap();
-        let mut builtins: Vec<(String, String)> = vec![];
+        let mut builtins: Vec<(String, String, String)> = vec![];
         rules.iter().for_each(|(rule_name, rule_entity)| {
             if !rule_name.starts_with("builtin_for") {
                 return;
             }
+            let category = rule_name.trim_start_matches("builtin_for_");
             let members = rule_entity["content"]["members"].as_array().unwrap();
             for member in members {
                 let inner_members = member["members"].as_array().unwrap();
@@ -148,16 +149,31 @@ This file is auto-generated by build.rs, which is not intended for manual editin
                         builtins.push((
                             builtin_name.to_case(Case::Pascal),
                             format!(r#"#[strum(serialize = "{}")]"#, builtin_name),
+                            category.to_string(),
                         ));
                     }
                 }
             }
         });
         builtins.sort_by(|x, y| x.0.cmp(&y.0));
-        builtins.iter().for_each(|(enum_name, serial_name)| {
+        builtins.iter().for_each(|(enum_name, serial_name, _)| {
             enum_builtin.new_variant(enum_name).annotation(serial_name);
         });
 
+        // category of built-ins, i.e. the "builtin_for_*" rule it belongs to
+        let category_fn = grammar_scope
+            .new_impl("Builtin")
+            .new_fn("category")
+            .vis("pub")
+            .doc(r#"The category of the built-in, e.g. "string" for `builtin_for_string`"#)
+            .arg_ref_self()
+            .ret("&'static str");
+        let mut category_match = Block::new("match self");
+        builtins.iter().for_each(|(enum_name, _, category)| {
+            category_match.line(format!(r#"Builtin::{} => "{}","#, enum_name, category));
+        });
+        category_fn.push_block(category_match);
+
         let grammar_rs_path = Path::new("bindings").join("rust").join("grammar.rs");
         let mut grammar_rs = File::create(grammar_rs_path).unwrap();
         grammar_rs
@@ -19,8 +19,14 @@ pub enum Rule {
     AssignInline,
     #[strum(serialize = "assign_stmt")]
     AssignStmt,
+    #[strum(serialize = "autoesc_clause")]
+    AutoescClause,
+    #[strum(serialize = "autoesc_stmt")]
+    AutoescStmt,
     #[strum(serialize = "binary_expression")]
     BinaryExpression,
+    #[strum(serialize = "break_stmt")]
+    BreakStmt,
     #[strum(serialize = "builtin_call")]
     BuiltinCall,
     #[strum(serialize = "builtin_for_boolean")]
@@ -59,6 +65,12 @@ pub enum Rule {
     FunctionName,
     #[strum(serialize = "function_stmt")]
     FunctionStmt,
+    #[strum(serialize = "global_clause")]
+    GlobalClause,
+    #[strum(serialize = "global_inline")]
+    GlobalInline,
+    #[strum(serialize = "global_stmt")]
+    GlobalStmt,
     #[strum(serialize = "hash_variable")]
     HashVariable,
     #[strum(serialize = "if_clause")]
@@ -69,6 +81,10 @@ pub enum Rule {
     ImportPath,
     #[strum(serialize = "import_stmt")]
     ImportStmt,
+    #[strum(serialize = "include_path")]
+    IncludePath,
+    #[strum(serialize = "include_stmt")]
+    IncludeStmt,
     #[strum(serialize = "interpolation")]
     Interpolation,
     #[strum(serialize = "list_clause")]
@@ -91,10 +107,22 @@ pub enum Rule {
     MacroStmt,
     #[strum(serialize = "member_expression")]
     MemberExpression,
+    #[strum(serialize = "nested_stmt")]
+    NestedStmt,
+    #[strum(serialize = "noautoesc_clause")]
+    NoautoescClause,
+    #[strum(serialize = "noautoesc_stmt")]
+    NoautoescStmt,
+    #[strum(serialize = "numeric_interpolation")]
+    NumericInterpolation,
     #[strum(serialize = "object")]
     Object,
     #[strum(serialize = "on_clause")]
     OnClause,
+    #[strum(serialize = "outputformat_clause")]
+    OutputformatClause,
+    #[strum(serialize = "outputformat_stmt")]
+    OutputformatStmt,
     #[strum(serialize = "pair")]
     Pair,
     #[strum(serialize = "parenthesized_expression")]
@@ -127,14 +155,16 @@ pub enum Rule {
     AssignClose,
     #[strum(serialize = "assign_operator")]
     AssignOperator,
+    #[strum(serialize = "autoesc_begin")]
+    AutoescBegin,
+    #[strum(serialize = "autoesc_close")]
+    AutoescClose,
     #[strum(serialize = "binary_operator")]
     BinaryOperator,
     #[strum(serialize = "boolean_false")]
     BooleanFalse,
     #[strum(serialize = "boolean_true")]
     BooleanTrue,
-    #[strum(serialize = "break_stmt")]
-    BreakStmt,
     #[strum(serialize = "builtin_name")]
     BuiltinName,
     #[strum(serialize = "case_begin")]
@@ -157,10 +187,16 @@ pub enum Rule {
     EqualOperator,
     #[strum(serialize = "ftl_begin")]
     FtlBegin,
+    #[strum(serialize = "ftl_setting")]
+    FtlSetting,
     #[strum(serialize = "function_begin")]
     FunctionBegin,
     #[strum(serialize = "function_close")]
     FunctionClose,
+    #[strum(serialize = "global_begin")]
+    GlobalBegin,
+    #[strum(serialize = "global_close")]
+    GlobalClose,
     #[strum(serialize = "greater_than_equal_operator")]
     GreaterThanEqualOperator,
     #[strum(serialize = "greater_than_operator")]
@@ -175,6 +211,8 @@ pub enum Rule {
     ImportAlias,
     #[strum(serialize = "import_begin")]
     ImportBegin,
+    #[strum(serialize = "include_begin")]
+    IncludeBegin,
     #[strum(serialize = "interpolation_prepend")]
     InterpolationPrepend,
     #[strum(serialize = "keyword_as")]
@@ -191,6 +229,8 @@ pub enum Rule {
     MacroBegin,
     #[strum(serialize = "macro_call_begin")]
     MacroCallBegin,
+    #[strum(serialize = "macro_call_close")]
+    MacroCallClose,
     #[strum(serialize = "macro_call_end")]
     MacroCallEnd,
     #[strum(serialize = "macro_close")]
@@ -203,10 +243,22 @@ pub enum Rule {
     MacroNamespace,
     #[strum(serialize = "negation_operator")]
     NegationOperator,
+    #[strum(serialize = "nested_begin")]
+    NestedBegin,
+    #[strum(serialize = "noautoesc_begin")]
+    NoautoescBegin,
+    #[strum(serialize = "noautoesc_close")]
+    NoautoescClose,
     #[strum(serialize = "number")]
     Number,
+    #[strum(serialize = "numeric_format")]
+    NumericFormat,
     #[strum(serialize = "on_begin")]
     OnBegin,
+    #[strum(serialize = "outputformat_begin")]
+    OutputformatBegin,
+    #[strum(serialize = "outputformat_close")]
+    OutputformatClose,
     #[strum(serialize = "parameter_name")]
     ParameterName,
     #[strum(serialize = "return_begin")]
@@ -467,4 +519,133 @@ pub enum Builtin {
     WithArgsLast,
     #[strum(serialize = "word_list")]
     WordList,
+}
+
+impl Builtin {
+    /// The category of the built-in, e.g. "string" for `builtin_for_string`
+    pub fn category(&self) -> &'static str {
+        match self {
+            Builtin::Abs => "number",
+            Builtin::AbsoluteTemplateName => "expert",
+            Builtin::Api => "expert",
+            Builtin::BlankToNull => "string",
+            Builtin::Boolean => "string",
+            Builtin::Byte => "expert",
+            Builtin::C => "string",
+            Builtin::CLowerCase => "string",
+            Builtin::CUpperCase => "string",
+            Builtin::CapFirst => "string",
+            Builtin::Capitalize => "string",
+            Builtin::Ceiling => "number",
+            Builtin::ChopLinebreak => "string",
+            Builtin::Chunk => "sequence",
+            Builtin::Cn => "string",
+            Builtin::Contains => "string",
+            Builtin::Date => "string",
+            Builtin::Datetime => "string",
+            Builtin::Double => "expert",
+            Builtin::DropWhile => "sequence",
+            Builtin::EmptyToNull => "string",
+            Builtin::EndsWith => "string",
+            Builtin::EnsureEndsWith => "string",
+            Builtin::EnsureStartsWith => "string",
+            Builtin::Esc => "string",
+            Builtin::Eval => "expert",
+            Builtin::EvalJson => "expert",
+            Builtin::Filter => "sequence",
+            Builtin::First => "sequence",
+            Builtin::Float => "expert",
+            Builtin::Floor => "number",
+            Builtin::Groups => "string",
+            Builtin::HasApi => "expert",
+            Builtin::HasContent => "expert",
+            Builtin::IndexOf => "string",
+            Builtin::Int => "expert",
+            Builtin::Interpret => "expert",
+            Builtin::IsBoolean => "expert",
+            Builtin::IsCollection => "expert",
+            Builtin::IsCollectionEx => "expert",
+            Builtin::IsDate => "expert",
+            Builtin::IsDateLike => "expert",
+            Builtin::IsDateOnly => "expert",
+            Builtin::IsDatetime => "expert",
+            Builtin::IsDirective => "expert",
+            Builtin::IsEnumerable => "expert",
+            Builtin::IsHash => "expert",
+            Builtin::IsHashEx => "expert",
+            Builtin::IsIndexable => "expert",
+            Builtin::IsInfinite => "number",
+            Builtin::IsMacro => "expert",
+            Builtin::IsMarkupOutput => "expert",
+            Builtin::IsMethod => "expert",
+            Builtin::IsNan => "number",
+            Builtin::IsNode => "expert",
+            Builtin::IsNumber => "expert",
+            Builtin::IsSequence => "expert",
+            Builtin::IsString => "expert",
+            Builtin::IsTime => "expert",
+            Builtin::IsTransform => "expert",
+            Builtin::IsUnknownDateLike => "expert",
+            Builtin::JString => "string",
+            Builtin::Join => "sequence",
+            Builtin::JsString => "string",
+            Builtin::JsonString => "string",
+            Builtin::KeepAfter => "string",
+            Builtin::KeepAfterLast => "string",
+            Builtin::KeepBefore => "string",
+            Builtin::KeepBeforeLast => "string",
+            Builtin::Keys => "hash",
+            Builtin::Last => "sequence",
+            Builtin::LastIndexOf => "string",
+            Builtin::LeftPad => "string",
+            Builtin::Length => "string",
+            Builtin::Long => "expert",
+            Builtin::LowerAbc => "number",
+            Builtin::LowerCase => "string",
+            Builtin::Map => "sequence",
+            Builtin::MarkupString => "expert",
+            Builtin::Matches => "string",
+            Builtin::Max => "sequence",
+            Builtin::Min => "sequence",
+            Builtin::Namespace => "expert",
+            Builtin::New => "expert",
+            Builtin::NoEsc => "string",
+            Builtin::Number => "string",
+            Builtin::NumberToDate => "expert",
+            Builtin::NumberToDatetime => "expert",
+            Builtin::NumberToTime => "expert",
+            Builtin::RemoveBeginning => "string",
+            Builtin::RemoveEnding => "string",
+            Builtin::Replace => "string",
+            Builtin::Reverse => "sequence",
+            Builtin::RightPad => "string",
+            Builtin::Round => "number",
+            Builtin::SeqContains => "sequence",
+            Builtin::SeqIndexOf => "sequence",
+            Builtin::SeqLastIndexOf => "sequence",
+            Builtin::Sequence => "expert",
+            Builtin::Short => "expert",
+            Builtin::Size => "sequence",
+            Builtin::Sort => "sequence",
+            Builtin::SortBy => "sequence",
+            Builtin::Split => "string",
+            Builtin::StartsWith => "string",
+            Builtin::String => "string",
+            Builtin::TakeWhile => "sequence",
+            Builtin::Then => "boolean",
+            Builtin::Time => "string",
+            Builtin::Trim => "string",
+            Builtin::TrimToNull => "string",
+            Builtin::Truncate => "string",
+            Builtin::UncapFirst => "string",
+            Builtin::UpperAbc => "number",
+            Builtin::UpperCase => "string",
+            Builtin::Url => "string",
+            Builtin::UrlPath => "string",
+            Builtin::Values => "hash",
+            Builtin::WithArgs => "expert",
+            Builtin::WithArgsLast => "expert",
+            Builtin::WordList => "string",
+        }
+    }
 }
\ No newline at end of file
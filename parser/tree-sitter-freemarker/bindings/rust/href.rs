@@ -2,13 +2,31 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
+pub const DIRECTIVES: &str = "https://freemarker.apache.org/docs/ref_directives.html";
 pub const DIRECTIVE_ASSIGN: &str = "https://freemarker.apache.org/docs/ref_directive_assign.html";
+pub const DIRECTIVE_FTL: &str = "https://freemarker.apache.org/docs/ref_directive_ftl.html";
+pub const DIRECTIVE_FUNCTION: &str =
+    "https://freemarker.apache.org/docs/ref_directive_function.html";
 pub const DIRECTIVE_IMPORT: &str = "https://freemarker.apache.org/docs/ref_directive_import.html";
+pub const DIRECTIVE_INCLUDE: &str = "https://freemarker.apache.org/docs/ref_directive_include.html";
+pub const DIRECTIVE_LIST: &str = "https://freemarker.apache.org/docs/ref_directive_list.html";
+pub const DIRECTIVE_MACRO: &str = "https://freemarker.apache.org/docs/ref_directive_macro.html";
+
 pub const DIRECTIVE_LIST_BREAK: &str =
     "https://freemarker.apache.org/docs/ref_directive_list.html#ref_list_break";
 
+pub const NUMERICAL_INTERPOLATION: &str =
+    "https://freemarker.apache.org/docs/ref_depr_numerical_interpolation.html";
+
+pub const AUTO_ESCAPING: &str = "https://freemarker.apache.org/docs/dgui_misc_autoescaping.html";
+
+pub const BUILTINS: &str = "https://freemarker.apache.org/docs/ref_builtins_alphaidx.html";
+
 pub const COMPARISION_EXPRESSION: &str =
     "https://freemarker.apache.org/docs/dgui_template_exp.html#dgui_template_exp_comparison";
 
+pub const TEMPLATE_STRUCTURE: &str =
+    "https://freemarker.apache.org/docs/dgui_template_overallstructure.html";
+
 pub const TOPLEVEL_VARIABLE: &str =
     "https://freemarker.apache.org/docs/dgui_template_exp.html#dgui_template_exp_var_toplevel";
@@ -73,4 +73,16 @@ mod tests {
         assert_eq!(assign_clause, Rule::AssignClause);
         assert_eq!(assign_clause.to_string(), "assign_clause");
     }
+
+    #[test]
+    fn test_parser_matches_node_types() {
+        // every named node of node-types.json must be known by the compiled
+        // parser, otherwise src/parser.c is stale and must be regenerated
+        let language: tree_sitter::Language = super::LANGUAGE.into();
+        let node_types: Vec<serde_json::Value> = serde_json::from_str(super::NODE_TYPES).unwrap();
+        for node_type in node_types.iter().filter(|t| t["named"] == true) {
+            let kind = node_type["type"].as_str().unwrap();
+            assert_ne!(language.id_for_node_kind(kind, true), 0, "{kind} missing in parser.c");
+        }
+    }
 }
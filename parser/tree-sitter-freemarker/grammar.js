@@ -15,6 +15,7 @@
 // @ts-nocheck
 
 const keyword_assign = 'assign';
+const keyword_autoesc = 'autoesc';
 const keyword_break = 'break';
 const keyword_case = 'case';
 const keyword_default = 'default';
@@ -22,12 +23,17 @@ const keyword_else = 'else';
 const keyword_elseif = 'elseif';
 const keyword_ftl = 'ftl';
 const keyword_function = 'function';
+const keyword_global = 'global';
 const keyword_if = 'if';
 const keyword_import = 'import';
+const keyword_include = 'include';
 const keyword_list = 'list';
 const keyword_local = 'local';
 const keyword_macro = 'macro';
+const keyword_nested = 'nested';
+const keyword_noautoesc = 'noautoesc';
 const keyword_on = 'on';
+const keyword_outputformat = 'outputformat';
 const keyword_return = 'return';
 const keyword_sep = 'sep';
 const keyword_switch = 'switch';
@@ -46,6 +52,14 @@ module.exports = grammar({
     $.deprecated_equal_operator,              // 5: '=', retard syntax alert
     $.equal_operator,                         // 6: '=='
     $.comment,                                // 7: comment
+    $._tag_syntax_text,                       // 8: '<' or '[' that does not open a tag of the detected syntax
+    $._tag_syntax_detected,                   // 9: zero width, in front of the first directive
+  ],
+
+  extras: $ => [
+    /\s/,
+    // carries the detected tag syntax to the following scans
+    $._tag_syntax_detected,
   ],
 
   precedences: $ => [
@@ -76,6 +90,7 @@ module.exports = grammar({
   conflicts: $ => [
     [$.subscript_expression, $._evaluate_expression],
     [$.subscript_expression, $.macro_call],
+    [$.subscript_expression, $.nested_stmt],
   ],
 
   rules: {
@@ -95,13 +110,18 @@ module.exports = grammar({
     text: $ => seq(
       choice(
         $.interpolation,
+        $.numeric_interpolation,
         $._rawstring
       )
     ),
 
     _rawstring: $ => choice(
-      // match string that does not contain '<' or '$'
-      /[^<$]+/,
+      // match string that does not contain '<', '[', '$' or '#{'
+      /([^<$#\[]|#+[^<$#\[{])+/,
+      // match '#' which does not open a numeric interpolation, e.g. the one before '<'
+      '#',
+      // match '<' or '[' which is not a tag of the detected tag syntax
+      $._tag_syntax_text,
       // match '<' when next char is not '#'
       seq('<', /[^#]/),
       // match '</' when next char is not '#'
@@ -110,30 +130,51 @@ module.exports = grammar({
       seq('$', token.immediate(/[^{]/))
     ),
 
-    interpolation: $ => seq(
+    interpolation: $ => choice(
       prec.right(seq(
         alias('$', $.interpolation_prepend),
         '{',
         $._evaluate_expression,
-        '}'))
+        '}')),
+      // "[=expr]", the external scanner only leaves it to the grammar in square bracket
+      // interpolation syntax, i.e. `<#ftl interpolation_syntax="square_bracket">`
+      prec.right(seq(
+        alias('[=', $.interpolation_prepend),
+        $._evaluate_expression,
+        ']'))
     ),
 
+    // "#{expr}" or "#{expr; m2M3}", the deprecated numeric interpolation
+    numeric_interpolation: $ => prec.right(seq(
+      alias('#{', $.interpolation_prepend),
+      field('value', $._evaluate_expression),
+      optional(seq(';', field('format', $.numeric_format))),
+      '}')),
+
+    // minimum and maximum number of fraction digits, e.g. "m2M3"
+    numeric_format: _ => /[mM][0-9]+([mM][0-9]+)?/,
+
     directive: $ => choice(
       $.assign_stmt,
+      $.autoesc_stmt,
       $.ftl_stmt,
       $.function_stmt,
+      $.global_stmt,
       $.if_stmt,
       $.import_stmt,
+      $.include_stmt,
       $.list_stmt,
       $.local_stmt,
       $.macro_stmt,
+      $.nested_stmt,
+      $.noautoesc_stmt,
+      $.outputformat_stmt,
       $.return_stmt,
       $.switch_stmt,
       $.break_stmt,
     ),
 
-    // TODO: support '[#]' syntax
-    break_stmt: _ => `<#${keyword_break}>`,
+    break_stmt: _ => choice(`<#${keyword_break}>`, `[#${keyword_break}]`),
 
     /********** STATEMENT_BEGIN: "assign" **************/
     assign_stmt: $ => seq(
@@ -148,7 +189,7 @@ module.exports = grammar({
       repeat($.assign_expression),
       choice(
         $.close_tag,
-        alias('/>', $.undocumented_close_tag) // retard syntax alert
+        alias(choice('/>', '/]'), $.undocumented_close_tag) // retard syntax alert
       )
     ),
 
@@ -167,7 +208,8 @@ module.exports = grammar({
     ),
 
     ftl_parameter: $ => seq(
-      field('name', $.variable),
+      // e.g. "output_format", validated by the language server
+      field('name', alias($.identifier, $.ftl_setting)),
       alias('=', $.assign_operator),
       field('value', choice(
         $.boolean_true,
@@ -218,6 +260,15 @@ module.exports = grammar({
     ),
     /********** STATEMENT_END: "import" **************/
 
+    /********** STATEMENT_BEGIN: "include" ***********/
+    include_stmt: $ => seq(
+      BeginAlias(keyword_include, $.include_begin),
+      FieldAlias(alias($.string_literal, $.include_path)),
+      repeat($.assign_expression), // options, e.g. parse=false
+      $.close_tag,
+    ),
+    /********** STATEMENT_END: "include" **************/
+
     /********** STATEMENT_BEGIN: "function" ***********/
     function_stmt: $ => seq(
       BeginAlias(keyword_function, $.function_begin),
@@ -232,14 +283,35 @@ module.exports = grammar({
       field('body', optional(repeat($._definition))),
     ),
 
-    // TODO: currently <#return> can appear outside <#function>, which is improper
+    // "<#return>" also exits a macro, the misplaced ones are reported by the language server
     return_stmt: $ => seq(
       BeginAlias(keyword_return, $.return_begin),
-      field('value', $._evaluate_expression),
+      optional(field('value', $._evaluate_expression)),
       $.close_tag,
     ),
     /********** STATEMENT_END: "function" **************/
 
+    /********** STATEMENT_BEGIN: "global" **************/
+    global_stmt: $ => seq(
+      BeginAlias(keyword_global, $.global_begin),
+      choice(
+        $.global_inline,
+        seq($.global_clause, CloseAlias(keyword_global, $.global_close))
+      )
+    ),
+
+    global_inline: $ => seq(
+      repeat($.assign_expression),
+      $.close_tag,
+    ),
+
+    global_clause: $ => seq(
+      field('into', $.variable),
+      $.close_tag,
+      field('from', repeat($._definition)),
+    ),
+    /********** STATEMENT_END: "global" **************/
+
     /********** STATEMENT_BEGIN: "list" ***********/
     /*
     TODO:
@@ -326,15 +398,62 @@ module.exports = grammar({
       field('body', repeat($._definition)),
     ),
 
+    // e.g. "<#nested>", or "<#nested item=x>" passing the loop variables to the body of the call
+    nested_stmt: $ => seq(
+      BeginAlias(keyword_nested, $.nested_begin),
+      field('parameter', repeat(seq(choice($._primary_expression, $.assign_expression), optional(',')))),
+      $.close_tag,
+    ),
+
     macro_call: $ => seq(
-      alias('<@', $.macro_call_begin),
+      alias(choice('<@', '[@'), $.macro_call_begin),
       alias($.identifier, $.macro_namespace),
       alias(repeat(seq('.', $.identifier)), $.macro_specs),
       field('parameter', repeat(choice($._primary_expression, $.assign_expression))),
-      alias('/>', $.macro_call_end)
+      choice(
+        alias(choice('/>', '/]'), $.macro_call_end),
+        // e.g. "<@row>...</@row>", or "<@row>...</@>" closing the innermost call
+        seq(
+          $.close_tag,
+          field('body', repeat($._definition)),
+          alias(choice('</@', '[/@'), $.macro_call_close),
+          optional(seq(
+            alias($.identifier, $.macro_namespace),
+            alias(repeat(seq('.', $.identifier)), $.macro_specs),
+          )),
+          $.close_tag,
+        ),
+      ),
     ),
     /********** STATEMENT_END: "macro" **************/
 
+    /********** STATEMENT_BEGIN: "outputformat" ***********/
+    outputformat_stmt: $ => seq(
+      BeginAlias(keyword_outputformat, $.outputformat_begin),
+      $.outputformat_clause,
+      CloseAlias(keyword_outputformat, $.outputformat_close)
+    ),
+
+    outputformat_clause: $ => seq(
+      // e.g. "HTML", or "plainText", validated by the language server
+      field('format', $.string_literal),
+      $.close_tag,
+      field('body', repeat($._definition)),
+    ),
+
+    autoesc_stmt: $ => seq(
+      BeginAlias(keyword_autoesc, $.autoesc_begin, false),
+      alias(repeat($._definition), $.autoesc_clause),
+      CloseAlias(keyword_autoesc, $.autoesc_close)
+    ),
+
+    noautoesc_stmt: $ => seq(
+      BeginAlias(keyword_noautoesc, $.noautoesc_begin, false),
+      alias(repeat($._definition), $.noautoesc_clause),
+      CloseAlias(keyword_noautoesc, $.noautoesc_close)
+    ),
+    /********** STATEMENT_END: "outputformat" **************/
+
     /********** STATEMENT_BEGIN: "switch" ***********/
     switch_stmt: $ => seq(
       BeginAlias(keyword_switch, $.switch_begin),
@@ -343,7 +462,7 @@ module.exports = grammar({
     ),
 
     switch_clause: $ => seq(
-      field('value', choice($.variable, $.hash_variable, $.string_literal, $.number)),
+      field('value', $._evaluate_expression),
       $.close_tag,
       repeat(choice(
         seq(BeginAlias(keyword_on, $.on_begin), $.on_clause),
@@ -357,14 +476,16 @@ module.exports = grammar({
       )),
     ),
 
+    // e.g. "<#on 1, 2>", several labels sharing the same body
     on_clause: $ => seq(
-      field('condition', commaSep(choice($.string_literal, $.number))),
+      field('condition', commaSep1($._evaluate_expression)),
       $.close_tag,
       optional(repeat($._definition)),
     ),
 
+    // a case without <#break> falls through to the next one, e.g. "<#case 1><#case 2>"
     case_clause: $ => seq(
-      field('condition', choice($.string_literal, $.number)),
+      field('condition', $._evaluate_expression),
       $.close_tag,
       optional(repeat($._definition)),
     ),
@@ -734,6 +855,10 @@ module.exports = grammar({
       $.builtin_for_sequence,
       $.builtin_for_expert,
       $.builtin_for_hash,
+      // any other name, the known ones are lexed as keywords first
+      prec.right('member', seq(
+        '?', alias($.identifier, $.builtin_name), optional($._call_arguments),
+      )),
     )),
 
     call_expression: $ =>
@@ -816,7 +941,7 @@ function commaSep(rule) {
 
 /**
  * Creates an alias rule for the begin part of the freemarker keyword
- * e.g. "<#foo" || "<#foo>"
+ * e.g. "<#foo" || "<#foo>", or "[#foo" || "[#foo]" in square bracket syntax
  *
  * @param {String} keyword
  * @param {Rule} rule_alias
@@ -827,15 +952,18 @@ function commaSep(rule) {
  * @note This function relies on the tree-sitter ABI version!
  */
 function BeginAlias(keyword, rule_alias, any_attributes = true) {
-  var text = `<#${keyword}`;
+  var angle = `<#${keyword}`;
+  var square = `[#${keyword}`;
   if (!any_attributes) {
-    text += '>';
+    angle += '>';
+    square += ']';
   }
-  return alias(text, rule_alias)
+  return alias(choice(angle, square), rule_alias)
 }
 
 /**
- * Creates an alias rule for the close part of the freemarker keyword, e.g. "</#foo>"
+ * Creates an alias rule for the close part of the freemarker keyword
+ * e.g. "</#foo>", or "[/#foo]" in square bracket syntax
  *
  * @param {String} keyword
  * @param {Rule} rule_alias
@@ -845,7 +973,7 @@ function BeginAlias(keyword, rule_alias, any_attributes = true) {
  * @note This function relies on the tree-sitter ABI version!
  */
 function CloseAlias(keyword, rule_alias) {
-  return alias(`</#${keyword}>`, rule_alias)
+  return alias(choice(`</#${keyword}>`, `[/#${keyword}]`), rule_alias)
 }
 
 /**
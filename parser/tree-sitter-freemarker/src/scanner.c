@@ -23,6 +23,25 @@ enum TokenType {
     DEPRECATED_EQUAL_OPERATOR,    // 5
     _EQUAL_OPERATOR,              // 6
     COMMENT,                      // 7
+    _TAG_SYNTAX_TEXT,             // 8
+    _TAG_SYNTAX_DETECTED,         // 9
+};
+
+// The tag syntax is decided by the first directive of the template, see also
+// https://freemarker.apache.org/docs/dgui_misc_alternativesyntax.html
+enum TagSyntax {
+    TAG_SYNTAX_AUTO_DETECT, // no directive is met yet
+    TAG_SYNTAX_ANGLE,       // <#if x>...</#if>
+    TAG_SYNTAX_SQUARE,      // [#if x]...[/#if]
+};
+
+// The interpolation syntax is set by the `interpolation_syntax` of the ftl
+// header, see also
+// https://freemarker.apache.org/docs/dgui_misc_alternativesyntax.html
+enum InterpolationSyntax {
+    INTERPOLATION_SYNTAX_LEGACY, // ${x} and #{x}
+    INTERPOLATION_SYNTAX_DOLLAR, // ${x}
+    INTERPOLATION_SYNTAX_SQUARE, // [=x]
 };
 
 typedef struct {
@@ -32,7 +51,9 @@ typedef struct {
     // UINT64_MAX characters, which is approximately 16 Exabytes(EB).
     uint64_t parenthesis_depth;
     bool in_comment;
-    uint8_t rsv[3];
+    uint8_t tag_syntax;
+    uint8_t interpolation_syntax;
+    uint8_t rsv[1];
 } Context;
 
 static const size_t context_size = sizeof(Context);
@@ -146,7 +167,7 @@ static bool try_emit(Context *ctx, int token) {
     return true;
 }
 
-static bool scan_comment(TSLexer *lexer) {
+static bool scan_comment(TSLexer *lexer, char end_char) {
     if (!lex_matchs(lexer, "--")) {
         return false;
     }
@@ -155,8 +176,9 @@ static bool scan_comment(TSLexer *lexer) {
             lex_advance(lexer);
             if (lex_nextchar(lexer) == '-') {
                 lex_advance(lexer);
-                if (lex_nextchar(lexer) == '>') {
+                if (lex_nextchar(lexer) == end_char) {
                     lex_advance(lexer);
+                    lexer->mark_end(lexer);
                     lexer->result_symbol = COMMENT;
                     return true;
                 }
@@ -168,21 +190,202 @@ static bool scan_comment(TSLexer *lexer) {
     return false;
 }
 
-static bool ftl_spec(Context *ctx) {
+// Reads a setting name or a quoted setting value into the buffer, the longer
+// ones are truncated
+static void scan_word(TSLexer *lex, char *buffer, size_t size) {
+    size_t length = 0;
+    const int32_t quote = lex_nextchar(lex);
+    const bool quoted = (quote == '"' || quote == '\'');
+    if (quoted) {
+        lex_advance(lex);
+    }
+    while (!lex_eof(lex)) {
+        const int32_t c = lex_nextchar(lex);
+        if (quoted ? (c == quote) : !(isalnum(c) || c == '_')) {
+            break;
+        }
+        if (length + 1 < size) {
+            buffer[length++] = (char)c;
+        }
+        lex_advance(lex);
+    }
+    if (quoted) {
+        lex_matchc(lex, quote);
+    }
+    buffer[length] = '\0';
+}
+
+static void scan_interpolation_syntax(Context *ctx, char close_char) {
+    // the '#' of "<#ftl" has been shifted, while the token end is kept in
+    // front of the opening bracket, so the header is only looked ahead
+    TSLexer *lex = ctx->lex;
+    if (!lex_matchs(lex, "ftl")) {
+        return;
+    }
+    char name[32];
+    char value[32];
+    while (!lex_eof(lex) && lex_nextchar(lex) != close_char) {
+        if (!isalpha(lex_nextchar(lex))) {
+            // whitespace, or a value other than a string
+            lex_advance(lex);
+            continue;
+        }
+        scan_word(lex, name, sizeof(name));
+        while (isspace(lex_nextchar(lex))) {
+            lex_advance(lex);
+        }
+        if (!lex_matchc(lex, '=')) {
+            continue;
+        }
+        while (isspace(lex_nextchar(lex))) {
+            lex_advance(lex);
+        }
+        if (lex_nextchar(lex) != '"' && lex_nextchar(lex) != '\'') {
+            continue;
+        }
+        scan_word(lex, value, sizeof(value));
+        if (strcmp(name, "interpolation_syntax") != 0 &&
+            strcmp(name, "interpolationSyntax") != 0) {
+            continue;
+        }
+        if (strcmp(value, "dollar") == 0) {
+            ctx->interpolation_syntax = INTERPOLATION_SYNTAX_DOLLAR;
+        } else if (strcmp(value, "square_bracket") == 0 ||
+                   strcmp(value, "squareBracket") == 0) {
+            ctx->interpolation_syntax = INTERPOLATION_SYNTAX_SQUARE;
+        } else {
+            ctx->interpolation_syntax = INTERPOLATION_SYNTAX_LEGACY;
+        }
+    }
+}
+
+static bool emit_tag_syntax_text(Context *ctx) {
+    // the opening bracket has been shifted and marked as the token end, the
+    // rest of the "tag" is left to the grammar as plain text
+    if (!ctx->opt[_TAG_SYNTAX_TEXT]) {
+        return false;
+    }
+    lex_emit(ctx->lex, _TAG_SYNTAX_TEXT);
+    return true;
+}
+
+static bool emit_tag_syntax_detected(Context *ctx, uint8_t syntax) {
+    // the token end is still marked in front of the opening bracket, so the
+    // directive itself is left to the grammar, while the detected syntax is
+    // kept in the serialized state of this zero width token
+    if (!ctx->opt[_TAG_SYNTAX_DETECTED]) {
+        return false;
+    }
+    ctx->tag_syntax = syntax;
+    lex_emit(ctx->lex, _TAG_SYNTAX_DETECTED);
+    return true;
+}
+
+static bool ftl_spec(Context *ctx, uint8_t syntax) {
     TSLexer *lex = ctx->lex;
-    // shift '<'
+    const bool *opt = ctx->opt;
+    const char close_char = (syntax == TAG_SYNTAX_SQUARE) ? ']' : '>';
+    const bool detecting = (ctx->tag_syntax == TAG_SYNTAX_AUTO_DETECT);
+    bool is_tag = false;
+    bool is_comment = false;
+    bool is_interpolation = false;
+    // mark the token end in front of '<' or '[' for the detection of the tag
+    // syntax, then shift it
+    lex->mark_end(lex);
     lex_advance(lex);
     switch (lex_nextchar(lex)) {
+    case '#':
+    case '/':
+    case '@':
+        // while detecting, the token end is kept in front of the bracket in
+        // case it opens the first directive
+        if (!detecting) {
+            lex->mark_end(lex);
+        }
+        break;
+    default:
+        lex->mark_end(lex);
+        break;
+    }
+    switch (lex_nextchar(lex)) {
     case '#':
         lex_advance(lex);
-        if (lex_nextchar(lex) == '-') {
-            return scan_comment(lex);
+        is_tag = true;
+        is_comment = (lex_nextchar(lex) == '-');
+        if (detecting && !is_comment) {
+            // the first directive might be the ftl header
+            scan_interpolation_syntax(ctx, close_char);
         }
         break;
+    case '/':
+        lex_advance(lex);
+        is_tag = (lex_nextchar(lex) == '#' || lex_nextchar(lex) == '@');
+        break;
+    case '@':
+        is_tag = true;
+        break;
+    case '=':
+        // "[=expr]" in both tag syntaxes
+        is_interpolation =
+            (syntax == TAG_SYNTAX_SQUARE &&
+             ctx->interpolation_syntax == INTERPOLATION_SYNTAX_SQUARE);
+        break;
+    }
+
+    if (!opt[_TAG_SYNTAX_TEXT]) {
+        // not the beginning of a template element
+        return false;
+    }
+    if (is_interpolation) {
+        // left to the grammar
+        return false;
+    }
+    if (detecting) {
+        if (is_comment) {
+            // comments of both syntaxes are allowed before the first directive
+            return opt[COMMENT] && scan_comment(lex, close_char);
+        }
+        if (is_tag) {
+            // the first directive decides the tag syntax of the whole template
+            return emit_tag_syntax_detected(ctx, syntax);
+        }
+        // a '[' that opens nothing is plain text, while the '<' is left to the
+        // grammar, e.g. "[/x" is shifted as a whole as the '/' is already
+        // consumed
+        lex->mark_end(lex);
+        return syntax == TAG_SYNTAX_SQUARE && emit_tag_syntax_text(ctx);
+    }
+    if (ctx->tag_syntax != syntax) {
+        // tags of the other syntax are plain text
+        return (is_tag || syntax == TAG_SYNTAX_SQUARE) &&
+               emit_tag_syntax_text(ctx);
+    }
+    if (is_comment) {
+        return opt[COMMENT] && scan_comment(lex, close_char);
+    }
+    if (!is_tag && syntax == TAG_SYNTAX_SQUARE) {
+        return emit_tag_syntax_text(ctx);
     }
     return false;
 }
 
+static bool interpolation_text(Context *ctx, char prepend) {
+    // "${" in square bracket interpolation syntax, or "#{" in other than the
+    // legacy one, is static text
+    TSLexer *lex = ctx->lex;
+    const bool is_text =
+        (prepend == '$')
+            ? (ctx->interpolation_syntax == INTERPOLATION_SYNTAX_SQUARE)
+            : (ctx->interpolation_syntax != INTERPOLATION_SYNTAX_LEGACY);
+    if (!is_text || !ctx->opt[_TAG_SYNTAX_TEXT]) {
+        return false;
+    }
+    // shift '$' or '#', the '{' is left to the grammar as plain text
+    lex_advance(lex);
+    lex->mark_end(lex);
+    return lex_nextchar(lex) == '{' && emit_tag_syntax_text(ctx);
+}
+
 static bool recognize_token(Context *ctx, TSLexer *lex,
                             const bool *valid_symbols) {
     // attach params to context
@@ -197,15 +400,21 @@ static bool recognize_token(Context *ctx, TSLexer *lex,
 
     switch (lex_nextchar(lex)) {
     case '<':
-        return ftl_spec(ctx);
+        return ftl_spec(ctx, TAG_SYNTAX_ANGLE);
+    case '[':
+        return ftl_spec(ctx, TAG_SYNTAX_SQUARE);
+    case '$':
+    case '#':
+        return interpolation_text(ctx, (char)lex_nextchar(lex));
     case '(':
         return try_emit(ctx, _OPEN_PAREN);
     case ')':
         return try_emit(ctx, _CLOSE_PAREN);
     case '>': {
         // Priority 1: Is the parser expecting a _DIRECTIVE_CLOSE_TAG AND we are
-        // not inside parentheses?
+        // not inside parentheses? (In square bracket syntax '>' never closes)
         if (valid_symbols[_DIRECTIVE_CLOSE_TAG] &&
+            ctx->tag_syntax != TAG_SYNTAX_SQUARE &&
             ctx->parenthesis_depth == 0) {
             lex_advance(lex);
             lex_emit(lex, _DIRECTIVE_CLOSE_TAG);
@@ -225,6 +434,17 @@ static bool recognize_token(Context *ctx, TSLexer *lex,
         }
         break;
     }
+    case ']': {
+        // In square bracket syntax, ']' closes the directive
+        if (valid_symbols[_DIRECTIVE_CLOSE_TAG] &&
+            ctx->tag_syntax == TAG_SYNTAX_SQUARE &&
+            ctx->parenthesis_depth == 0) {
+            lex_advance(lex);
+            lex_emit(lex, _DIRECTIVE_CLOSE_TAG);
+            return true;
+        }
+        break;
+    }
     case '=': {
         // advance the 1st one
         lex_advance(lex);
@@ -7,13 +7,17 @@ use tower_lsp_server::{
     jsonrpc::Result as JsonRpcResult,
     ls_types::{
         CodeAction, CodeActionKind, CodeActionOptions, CodeActionOrCommand, CodeActionParams,
-        CodeActionProviderCapability, Diagnostic, NumberOrString, TextEdit, Uri, WorkspaceEdit,
+        CodeActionProviderCapability, Diagnostic, NumberOrString, Position, Range, TextEdit, Uri,
+        WorkspaceEdit,
     },
 };
 
+use tree_sitter::Node;
 use tree_sitter_freemarker::grammar::Rule;
 
-use crate::{reactor::Reactor, server::ActionFeature};
+use crate::{doc::TextDocument, hover, reactor::Reactor, server::ActionFeature, utils};
+
+const UNCLOSED_DIRECTIVE: &str = "unclosed_directive";
 
 #[allow(clippy::mutable_key_type)]
 fn create_fix_warning_action(
@@ -48,9 +52,114 @@ fn create_fix_warning_action(
     }))
 }
 
+fn ranges_overlap(a: &Range, b: &Range) -> bool {
+    a.start <= b.end && b.start <= a.end
+}
+
+/// Returns the position to insert the closing tag of the directive opened at the given line,
+/// that is before the first line indented no deeper than the opener, or the end of the document
+fn closing_tag_position(doc: &TextDocument, opener_line: usize, indentation: &str) -> Position {
+    let mut body_indented = false;
+    for line in opener_line + 1..doc.line_count() {
+        let text = doc.get_line_text(line);
+        if text.trim().is_empty() {
+            continue;
+        }
+        if utils::leading_whitespace(&text).len() > indentation.len() {
+            body_indented = true;
+        } else if body_indented {
+            return Position::new(line as u32, 0);
+        } else {
+            // the body is not indented, no clue where it ends
+            break;
+        }
+    }
+    let last_line = doc.line_count().saturating_sub(1);
+    let last_line_len = doc.get_line_text(last_line).len();
+    Position::new(
+        last_line as u32,
+        doc.byte_column_to_character(last_line, last_line_len),
+    )
+}
+
+#[allow(clippy::mutable_key_type)]
+fn create_insert_closer_action(
+    doc: &TextDocument,
+    uri: &Uri,
+    diagnostic: Diagnostic,
+) -> Option<CodeActionOrCommand> {
+    // the diagnostic range starts with the opening tag, e.g. "<#list xs as x>"
+    let opener_line = diagnostic.range.start.line as usize;
+    let line_text = doc.get_line_text(opener_line);
+    let opener_column =
+        doc.character_to_byte_column(opener_line, diagnostic.range.start.character)?;
+    let opener_text = line_text.get(opener_column..)?;
+    let opener_tag = opener_text
+        .split(|c: char| c.is_whitespace() || matches!(c, '>' | ']'))
+        .next()?;
+    let name = utils::directive_name(opener_tag);
+    if name.is_empty() {
+        return None;
+    }
+    let closer = match opener_tag.starts_with('[') {
+        true => format!("[/#{}]", name),
+        false => format!("</#{}>", name),
+    };
+    let indentation = utils::leading_whitespace(&line_text);
+    let position = closing_tag_position(doc, opener_line, indentation);
+    let new_text = match position.character {
+        0 => format!("{}{}\n", indentation, closer),
+        // append to the last line which has no line break yet
+        _ => format!("\n{}{}", indentation, closer),
+    };
+    let text_edit = TextEdit {
+        range: Range::new(position, position),
+        new_text,
+    };
+
+    Some(CodeActionOrCommand::CodeAction(CodeAction {
+        title: format!("Add closing `{}`", closer),
+        kind: Some(CodeActionKind::QUICKFIX),
+        diagnostics: Some(vec![diagnostic]),
+        edit: Some(WorkspaceEdit {
+            changes: Some(vec![(uri.clone(), vec![text_edit])].into_iter().collect()),
+            ..Default::default()
+        }),
+        is_preferred: Some(true),
+        ..Default::default()
+    }))
+}
+
+#[allow(clippy::mutable_key_type)]
+fn create_rewrite_numeric_interpolation_action(
+    interpolation: &Node,
+    doc: &TextDocument,
+    uri: &Uri,
+) -> Option<CodeActionOrCommand> {
+    // formats which can not be translated are left as is
+    let new_text = hover::numeric_interpolation_replacement(interpolation, doc)?;
+    let text_edit = TextEdit {
+        range: utils::parser_node_to_document_range(interpolation, doc),
+        new_text: new_text.clone(),
+    };
+
+    Some(CodeActionOrCommand::CodeAction(CodeAction {
+        title: format!("Rewrite as `{}`", new_text),
+        kind: Some(CodeActionKind::REFACTOR_REWRITE),
+        edit: Some(WorkspaceEdit {
+            changes: Some(vec![(uri.clone(), vec![text_edit])].into_iter().collect()),
+            ..Default::default()
+        }),
+        ..Default::default()
+    }))
+}
+
 pub fn code_action_capability() -> CodeActionProviderCapability {
     CodeActionProviderCapability::Options(CodeActionOptions {
-        code_action_kinds: Some(vec![CodeActionKind::QUICKFIX]),
+        code_action_kinds: Some(vec![
+            CodeActionKind::QUICKFIX,
+            CodeActionKind::REFACTOR_REWRITE,
+        ]),
         ..Default::default()
     })
 }
@@ -64,7 +173,17 @@ impl ActionFeature for Reactor {
         for diagnostic in params.context.diagnostics {
             if let Some(NumberOrString::String(code)) = &diagnostic.code {
                 // string codes
-                if let Some(fix_action) =
+                if code == UNCLOSED_DIRECTIVE {
+                    if ranges_overlap(&params.range, &diagnostic.range)
+                        && let Some(insert_action) = create_insert_closer_action(
+                            self.get_document(),
+                            &params.text_document.uri,
+                            diagnostic.clone(),
+                        )
+                    {
+                        actions.push(insert_action);
+                    }
+                } else if let Some(fix_action) =
                     create_fix_warning_action(code, &params.text_document.uri, diagnostic.clone())
                 {
                     // Create a CodeAction for this specific diagnostic
@@ -72,6 +191,95 @@ impl ActionFeature for Reactor {
                 }
             }
         }
+        // refactorings of the node at the cursor
+        let point = utils::lsp_position_to_parser_point(&params.range.start, self.get_document());
+        let mut cursor = self.get_parser().get_node_at_point(point);
+        while let Some(node) = cursor {
+            if node.kind() == Rule::NumericInterpolation.to_string() {
+                if let Some(rewrite_action) = create_rewrite_numeric_interpolation_action(
+                    &node,
+                    self.get_document(),
+                    &params.text_document.uri,
+                ) {
+                    actions.push(rewrite_action);
+                }
+                break;
+            }
+            cursor = node.parent();
+        }
         Ok(Some(actions))
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        CodeActionContext, CodeActionOrCommand, CodeActionParams, NumberOrString, Position, Range,
+        TextDocumentIdentifier, TextEdit, Uri,
+    };
+
+    use crate::{action::UNCLOSED_DIRECTIVE, reactor::Reactor, server::ActionFeature};
+
+    // the body is indented, so the closing tag goes before the first line which is not
+    const TEXT: &str = "<#list xs as x>\n  ${x}\n<p>\n";
+
+    async fn closer_edits(range: Range) -> Vec<TextEdit> {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let reactor = Reactor::new(&uri, TEXT, 0);
+        let diagnostics = reactor
+            .get_analysis()
+            .get_analyzed_full_diagnostics()
+            .full_document_diagnostic_report
+            .items
+            .into_iter()
+            .filter(|diagnostic| {
+                diagnostic.code == Some(NumberOrString::String(UNCLOSED_DIRECTIVE.to_owned()))
+            })
+            .collect();
+        let actions = reactor
+            .on_code_action(CodeActionParams {
+                text_document: TextDocumentIdentifier::new(uri.clone()),
+                range,
+                context: CodeActionContext {
+                    diagnostics,
+                    only: None,
+                    trigger_kind: None,
+                },
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+            })
+            .await;
+        actions
+            .ok()
+            .flatten()
+            .unwrap_or_default()
+            .into_iter()
+            .filter_map(|action| match action {
+                CodeActionOrCommand::CodeAction(action)
+                    if action.title == "Add closing `</#list>`" =>
+                {
+                    action.edit?.changes?.remove(&uri)
+                }
+                _ => None,
+            })
+            .flatten()
+            .collect()
+    }
+
+    #[tokio::test]
+    async fn test_insert_closing_tag() {
+        let at_opener = Range::new(Position::new(0, 3), Position::new(0, 3));
+        assert_eq!(
+            closer_edits(at_opener).await,
+            vec![TextEdit::new(
+                Range::new(Position::new(2, 0), Position::new(2, 0)),
+                "</#list>\n".to_owned()
+            )]
+        );
+        // only offered at the diagnostic
+        let elsewhere = Range::new(Position::new(2, 1), Position::new(2, 1));
+        assert!(closer_edits(elsewhere).await.is_empty());
+    }
+}
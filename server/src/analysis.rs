@@ -21,11 +21,44 @@ pub struct Symbol {
     pub(crate) range: Range,
 }
 
+/// A parameter declared by `<#macro>`, e.g. "c=10" of `<#macro foo a b c=10>`
+#[derive(Clone, Debug)]
+pub struct MacroParameter {
+    pub(crate) name: String,
+    pub(crate) default: Option<String>,
+}
+
+/// An assignment of a variable, with the keys if a hash literal is assigned, e.g. "host" and
+/// "port" of `<#assign cfg = {"host": "x", "port": 8080}>`
+#[derive(Clone, Debug)]
+pub struct HashAssignment {
+    pub(crate) start_byte: usize,
+    pub(crate) keys: Option<Vec<String>>,
+}
+
+/// Settings of the `<#ftl>` header, e.g. `<#ftl output_format="HTML" strip_whitespace=true>`
+#[derive(Clone, Debug, Default)]
+pub struct FtlHeader {
+    pub(crate) output_format: Option<String>,
+    pub(crate) auto_esc: Option<bool>,
+    pub(crate) strip_whitespace: Option<bool>,
+    pub(crate) range: Range,
+}
+
+/// A block directive whose closing tag is not met yet
+#[derive(Clone, Debug)]
+pub struct OpenDirective {
+    pub(crate) name: String,
+    pub(crate) closer: String,
+    pub(crate) range: Range,
+}
+
 #[derive(Default)]
 pub struct AnalysisContext {
     pub prev_start: Point,
     pub ranges_set: HashSet<usize>,
     pub scope: Vec<Rule>,
+    pub open_directives: Vec<OpenDirective>,
     pub import_map: HashMap<String, Vec<Symbol>>,
     pub macro_call_map: HashMap<String, Vec<Symbol>>,
 }
@@ -43,6 +76,14 @@ pub struct Analysis {
     folding_range: Vec<FoldingRange>,
     symbol_map: HashMap<String, Vec<Symbol>>,
     import_uri_map: HashMap<String, Uri>,
+    namespace_uri_map: HashMap<String, Uri>,
+    include_uris: Vec<Uri>,
+    ftl_header: Option<FtlHeader>,
+    hash_assignment_map: HashMap<String, Vec<HashAssignment>>,
+    // keyed by the start byte of the macro name
+    macro_parameter_map: HashMap<usize, Vec<MacroParameter>>,
+    // loop variables passed by `<#nested>`, keyed by the start byte of the macro name
+    nested_variable_map: HashMap<usize, Vec<String>>,
 }
 
 // TODO: wrap parser methods and document methods
@@ -54,12 +95,35 @@ impl Analysis {
         let mut ctx = AnalysisContext {
             ..Default::default()
         };
-        let ast = parser.get_ast().unwrap();
+        let Some(ast) = parser.get_ast() else {
+            // the parsing timed out
+            return analysis;
+        };
         analysis.syntatic_analysis(&ast.root_node(), doc, &mut ctx);
         analysis.post_syntatic_analysis(doc, &mut ctx);
+        analysis.post_diagnostic_analysis(&ast.root_node(), doc, &mut ctx);
+        analysis
+    }
+
+    /// Analyzes the semantic tokens only, for the templates too large for the other features
+    pub fn highlight_only(doc: &TextDocument, parser: &TextParser) -> Self {
+        let mut analysis = Analysis::default();
+        let mut ctx = AnalysisContext::default();
+        if let Some(ast) = parser.get_ast() {
+            analysis.highlight_analysis(&ast.root_node(), doc, &mut ctx);
+        }
         analysis
     }
 
+    fn highlight_analysis(&mut self, node: &Node, doc: &TextDocument, ctx: &mut AnalysisContext) {
+        self.analyze_semantic_highlight(node, doc, ctx);
+        for i in 0..node.child_count() {
+            if let Some(child) = node.child(i) {
+                self.highlight_analysis(&child, doc, ctx)
+            }
+        }
+    }
+
     fn syntatic_analysis(&mut self, node: &Node, doc: &TextDocument, ctx: &mut AnalysisContext) {
         // semantic highlight
         self.analyze_semantic_highlight(node, doc, ctx);
@@ -100,6 +164,42 @@ impl Analysis {
         }
     }
 
+    /// Returns the nearest definition preceding the given byte offset, or the
+    /// first definition if all of them come after it.
+    pub fn find_nearest_definition(
+        &self,
+        name: &str,
+        byte: usize,
+    ) -> Result<Symbol, AnalysisError> {
+        let symbols = self.find_symbol_definition(name)?;
+        Ok(symbols
+            .iter()
+            .rev()
+            .find(|symbol| symbol.start_byte < byte)
+            .copied()
+            .unwrap_or(symbols[0]))
+    }
+
+    pub fn add_macro_parameters(&mut self, macro_name: &Symbol, parameters: Vec<MacroParameter>) {
+        self.macro_parameter_map
+            .insert(macro_name.start_byte, parameters);
+    }
+
+    pub fn get_macro_parameters(&self, macro_name: &Symbol) -> Option<&Vec<MacroParameter>> {
+        self.macro_parameter_map.get(&macro_name.start_byte)
+    }
+
+    pub fn add_nested_variables(&mut self, macro_name: &Symbol, variables: Vec<String>) {
+        self.nested_variable_map
+            .insert(macro_name.start_byte, variables);
+    }
+
+    /// Returns the loop variables which the macro passes to the body of its calls, e.g. "item"
+    /// of `<#nested item=x>`
+    pub fn get_nested_variables(&self, macro_name: &Symbol) -> Option<&Vec<String>> {
+        self.nested_variable_map.get(&macro_name.start_byte)
+    }
+
     pub fn record_valid_import(&mut self, path: &str, uri: Uri) {
         self.import_uri_map.insert(path.to_owned(), uri);
     }
@@ -108,6 +208,49 @@ impl Analysis {
         self.import_uri_map.get(path)
     }
 
+    pub fn record_namespace(&mut self, alias: &str, uri: Uri) {
+        self.namespace_uri_map.insert(alias.to_owned(), uri);
+    }
+
+    pub fn get_namespace_uri(&self, alias: &str) -> Option<&Uri> {
+        self.namespace_uri_map.get(alias)
+    }
+
+    pub fn record_include(&mut self, uri: Uri) {
+        self.include_uris.push(uri);
+    }
+
+    pub fn get_includes(&self) -> &Vec<Uri> {
+        &self.include_uris
+    }
+
+    pub fn add_hash_assignment(&mut self, name: &str, assignment: HashAssignment) {
+        self.hash_assignment_map
+            .entry(name.to_owned())
+            .or_default()
+            .push(assignment);
+    }
+
+    /// Returns the keys of the hash literal assigned by the nearest assignment preceding the
+    /// given byte offset, `None` if the value is not a statically known hash
+    pub fn find_hash_keys(&self, name: &str, byte: usize) -> Option<&Vec<String>> {
+        self.hash_assignment_map
+            .get(name)?
+            .iter()
+            .rev()
+            .find(|assignment| assignment.start_byte < byte)?
+            .keys
+            .as_ref()
+    }
+
+    pub fn set_ftl_header(&mut self, header: FtlHeader) {
+        self.ftl_header = Some(header);
+    }
+
+    pub fn get_ftl_header(&self) -> Option<&FtlHeader> {
+        self.ftl_header.as_ref()
+    }
+
     pub fn add_diagnostic(&mut self, item: Diagnostic) {
         self.full_diagnostic
             .full_document_diagnostic_report
@@ -175,4 +318,11 @@ pub trait DiagnosticAnalysis {
         doc: &TextDocument,
         ctx: &mut AnalysisContext,
     );
+
+    fn post_diagnostic_analysis(
+        &mut self,
+        root: &Node,
+        doc: &TextDocument,
+        ctx: &mut AnalysisContext,
+    );
 }
@@ -0,0 +1,215 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+//! The table of known built-ins, shared by diagnostics, completion and hover.
+
+use std::str::FromStr;
+
+use strum::IntoEnumIterator;
+use tree_sitter_freemarker::grammar::Builtin;
+
+/// The FreeMarker version which the table of built-ins follows
+pub const BUILTINS_VERSION: &str = "2.3.34";
+
+/// Built-ins which FreeMarker accepts but the grammar does not model (yet),
+/// e.g. deprecated ones and the built-ins of loop variables
+const UNMODELLED_BUILTINS: [&str; 33] = [
+    // deprecated
+    "default",
+    "exists",
+    "html",
+    "if_exists",
+    "rtf",
+    "substring",
+    "web_safe",
+    "xhtml",
+    "xml",
+    // loop variables
+    "counter",
+    "has_next",
+    "index",
+    "is_even_item",
+    "is_first",
+    "is_last",
+    "is_odd_item",
+    "item_cycle",
+    "item_parity",
+    "item_parity_cap",
+    // dates, besides the "iso_*" family
+    "date_if_unknown",
+    "datetime_if_unknown",
+    "iso",
+    "iso_local",
+    "iso_utc",
+    "time_if_unknown",
+    // nodes
+    "ancestors",
+    "children",
+    "node_name",
+    "node_namespace",
+    "node_type",
+    "parent",
+    "root",
+    // expert
+    "switch",
+];
+
+/// Returns true if the name is a built-in known by FreeMarker, e.g. "upper_case"
+pub fn is_known_builtin(name: &str) -> bool {
+    Builtin::from_str(name).is_ok()
+        || UNMODELLED_BUILTINS.contains(&name)
+        // e.g. "iso_utc_ms", "iso_local_nz"
+        || name.starts_with("iso_")
+}
+
+pub fn category_detail(builtin: &Builtin) -> &'static str {
+    // titles of https://freemarker.apache.org/docs/ref_builtins.html
+    match builtin.category() {
+        "string" => "Built-in for strings",
+        "number" => "Built-in for numbers",
+        "boolean" => "Built-in for booleans",
+        "sequence" => "Built-in for sequences",
+        "hash" => "Built-in for hashes",
+        _ => "Seldom used and expert built-in",
+    }
+}
+
+/// Parameters of the built-ins taking arguments, the optional ones are bracketed, see
+/// https://freemarker.apache.org/docs/ref_builtins_alphaidx.html
+const BUILTIN_PARAMETERS: [(&str, &[&str]); 39] = [
+    // strings
+    ("contains", &["substring"]),
+    ("ends_with", &["suffix"]),
+    ("ensure_ends_with", &["suffix"]),
+    ("ensure_starts_with", &["prefix"]),
+    ("index_of", &["substring", "[start]"]),
+    ("keep_after", &["substring", "[flags]"]),
+    ("keep_after_last", &["substring", "[flags]"]),
+    ("keep_before", &["substring", "[flags]"]),
+    ("keep_before_last", &["substring", "[flags]"]),
+    ("last_index_of", &["substring", "[start]"]),
+    ("left_pad", &["length", "[padding]"]),
+    ("matches", &["regexp", "[flags]"]),
+    ("remove_beginning", &["prefix"]),
+    ("remove_ending", &["suffix"]),
+    ("replace", &["search", "replacement", "[flags]"]),
+    ("right_pad", &["length", "[padding]"]),
+    ("split", &["separator", "[flags]"]),
+    ("starts_with", &["prefix"]),
+    ("string", &["[format]"]),
+    (
+        "truncate",
+        &["length", "[terminator]", "[terminator_length]"],
+    ),
+    ("url", &["[charset]"]),
+    ("url_path", &["[charset]"]),
+    // booleans
+    ("then", &["when_true", "when_false"]),
+    // sequences
+    ("chunk", &["size", "[filler]"]),
+    ("drop_while", &["predicate"]),
+    ("filter", &["predicate"]),
+    ("join", &["separator", "[empty]", "[suffix]"]),
+    ("map", &["mapper"]),
+    ("seq_contains", &["value"]),
+    ("seq_index_of", &["value", "[start]"]),
+    ("seq_last_index_of", &["value", "[start]"]),
+    ("sort_by", &["key"]),
+    ("take_while", &["predicate"]),
+    // expert
+    ("absolute_template_name", &["[base]"]),
+    ("new", &["[arguments]"]),
+    ("with_args", &["arguments"]),
+    ("with_args_last", &["arguments"]),
+    // deprecated
+    ("default", &["[default_value]"]),
+    ("substring", &["from", "[to]"]),
+];
+
+/// Returns the parameters of the built-in, `None` if it takes no arguments, e.g. "size"
+pub fn builtin_parameters(name: &str) -> Option<&'static [&'static str]> {
+    BUILTIN_PARAMETERS
+        .iter()
+        .find(|(builtin, _)| *builtin == name)
+        .map(|(_, parameters)| *parameters)
+}
+
+/// Returns the signature of a built-in taking arguments, e.g. "left_pad(length, [padding])"
+pub fn builtin_signature(name: &str) -> Option<String> {
+    builtin_parameters(name).map(|parameters| format!("{}({})", name, parameters.join(", ")))
+}
+
+/// Returns the snippet calling the built-in with a tab stop per required parameter, e.g.
+/// "then(${1:when_true}, ${2:when_false})", `None` if no argument is required
+pub fn builtin_snippet(name: &str) -> Option<String> {
+    let required: Vec<String> = builtin_parameters(name)?
+        .iter()
+        .filter(|parameter| !parameter.starts_with('['))
+        .enumerate()
+        .map(|(index, parameter)| format!("${{{}:{}}}", index + 1, parameter))
+        .collect();
+    (!required.is_empty()).then(|| format!("{}({})", name, required.join(", ")))
+}
+
+fn edit_distance(a: &str, b: &str) -> usize {
+    let b: Vec<char> = b.chars().collect();
+    let mut row: Vec<usize> = (0..=b.len()).collect();
+    for (i, ca) in a.chars().enumerate() {
+        let mut diagonal = row[0];
+        row[0] = i + 1;
+        for (j, cb) in b.iter().enumerate() {
+            let substitution = diagonal + usize::from(ca != *cb);
+            diagonal = row[j + 1];
+            row[j + 1] = substitution.min(row[j] + 1).min(row[j + 1] + 1);
+        }
+    }
+    row[b.len()]
+}
+
+/// Returns the known built-in closest to a mistyped name, e.g. "upper_case" for "uppercase"
+pub fn suggest_builtin(name: &str) -> Option<String> {
+    let max_distance = (name.len() / 3).max(1);
+    Builtin::iter()
+        .map(|builtin| builtin.to_string())
+        .chain(UNMODELLED_BUILTINS.iter().map(|name| name.to_string()))
+        .map(|known| (edit_distance(name, &known), known))
+        .filter(|(distance, _)| *distance <= max_distance)
+        .min_by_key(|(distance, _)| *distance)
+        .map(|(_, known)| known)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_known_builtin() {
+        assert!(is_known_builtin("upper_case"));
+        assert!(is_known_builtin("html"));
+        assert!(!is_known_builtin("uppercase"));
+        assert_eq!(suggest_builtin("uppercase").as_deref(), Some("upper_case"));
+        assert_eq!(suggest_builtin("sise").as_deref(), Some("size"));
+        assert!(suggest_builtin("qqqq").is_none());
+    }
+
+    #[test]
+    fn test_builtin_signature() {
+        assert_eq!(
+            builtin_signature("left_pad").as_deref(),
+            Some("left_pad(length, [padding])")
+        );
+        assert!(builtin_signature("size").is_none());
+        assert_eq!(
+            builtin_snippet("then").as_deref(),
+            Some("then(${1:when_true}, ${2:when_false})")
+        );
+        assert_eq!(
+            builtin_snippet("index_of").as_deref(),
+            Some("index_of(${1:substring})")
+        );
+        // only optional parameters
+        assert!(builtin_snippet("url").is_none());
+        assert!(builtin_snippet("size").is_none());
+    }
+}
@@ -0,0 +1,288 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::io::{self, Write};
+
+use serde_json::{Value, json};
+use tree_sitter::Node;
+
+use crate::parser::TextParser;
+
+const PARSE_USAGE: &str = "usage: lsp-for-freemarker parse [--json | --errors-only] <file>";
+const SERVE_USAGE: &str = "usage: lsp-for-freemarker [--stdio | --listen [HOST]:PORT]";
+
+// exit codes of the subcommands
+pub const EXIT_OK: i32 = 0;
+pub const EXIT_PARSE_ERRORS: i32 = 1;
+pub const EXIT_USAGE: i32 = 2;
+
+/// How the language server talks to its client
+#[derive(Debug, PartialEq)]
+pub enum Transport {
+    Stdio,
+    // a single client connecting to the address, e.g. "127.0.0.1:9257"
+    Listen(String),
+}
+
+/// Returns the address to listen on, the host defaults to the loopback interface, e.g.
+/// "127.0.0.1:9257" of ":9257"
+fn listen_address(address: &str) -> Option<String> {
+    let (host, port) = address.rsplit_once(':')?;
+    port.parse::<u16>().ok()?;
+    match host.is_empty() {
+        true => Some(format!("127.0.0.1:{}", port)),
+        false => Some(address.to_owned()),
+    }
+}
+
+/// Reads the transport of the language server, stdio by default. It returns the exit code if
+/// the arguments are invalid.
+pub fn transport_of(args: &[String]) -> Result<Transport, i32> {
+    let mut transport = Transport::Stdio;
+    let mut args = args.iter();
+    while let Some(arg) = args.next() {
+        let address = match arg.as_str() {
+            "--stdio" => {
+                transport = Transport::Stdio;
+                continue;
+            }
+            "--listen" => args.next().map(String::as_str),
+            _ => arg.strip_prefix("--listen="),
+        };
+        match address.and_then(listen_address) {
+            Some(address) => transport = Transport::Listen(address),
+            None => {
+                let _ = writeln!(io::stderr(), "{}", SERVE_USAGE);
+                return Err(EXIT_USAGE);
+            }
+        }
+    }
+    Ok(transport)
+}
+
+enum ParseOutput {
+    SExpression,
+    Json,
+    ErrorsOnly,
+}
+
+fn node_to_json(node: &Node) -> Value {
+    let mut cursor = node.walk();
+    let children: Vec<Value> = node
+        .named_children(&mut cursor)
+        .map(|child| node_to_json(&child))
+        .collect();
+    json!({
+        "kind": node.kind(),
+        "start_byte": node.start_byte(),
+        "end_byte": node.end_byte(),
+        "start": [node.start_position().row, node.start_position().column],
+        "end": [node.end_position().row, node.end_position().column],
+        "is_error": node.is_error(),
+        "is_missing": node.is_missing(),
+        "children": children,
+    })
+}
+
+fn collect_error_nodes<'tree>(node: Node<'tree>, errors: &mut Vec<Node<'tree>>) {
+    if node.is_error() || node.is_missing() {
+        errors.push(node);
+    }
+    if !node.has_error() {
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        collect_error_nodes(child, errors);
+    }
+}
+
+/// Dumps the syntax tree of a template, e.g. `lsp-for-freemarker parse foo.ftl`. It exits with
+/// a non-zero code if the template has syntax errors, to be usable in CI.
+pub fn parse_command(args: &[String]) -> i32 {
+    run_parse_command(args, &mut io::stdout().lock())
+}
+
+fn run_parse_command(args: &[String], stdout: &mut impl Write) -> i32 {
+    let mut output = ParseOutput::SExpression;
+    let mut file = None;
+    for arg in args {
+        match arg.as_str() {
+            "--json" => output = ParseOutput::Json,
+            "--errors-only" => output = ParseOutput::ErrorsOnly,
+            _ if arg.starts_with("--") || file.is_some() => {
+                let _ = writeln!(io::stderr(), "{}", PARSE_USAGE);
+                return EXIT_USAGE;
+            }
+            _ => file = Some(arg),
+        }
+    }
+    let Some(file) = file else {
+        let _ = writeln!(io::stderr(), "{}", PARSE_USAGE);
+        return EXIT_USAGE;
+    };
+    let text = match std::fs::read_to_string(file) {
+        Ok(text) => text,
+        Err(e) => {
+            let _ = writeln!(io::stderr(), "cannot read {}: {}", file, e);
+            return EXIT_USAGE;
+        }
+    };
+    let parser = TextParser::new(&text);
+    let Some(ast) = parser.get_ast() else {
+        let _ = writeln!(io::stderr(), "cannot parse {}", file);
+        return EXIT_PARSE_ERRORS;
+    };
+    let root = ast.root_node();
+    let _ = match output {
+        ParseOutput::SExpression => writeln!(stdout, "{}", root.to_sexp()),
+        ParseOutput::Json => writeln!(stdout, "{}", node_to_json(&root)),
+        ParseOutput::ErrorsOnly => {
+            let mut errors = vec![];
+            collect_error_nodes(root, &mut errors);
+            errors.iter().try_for_each(|node| {
+                // 1-based lines and columns as the editors and compilers print them
+                let start = node.start_position();
+                let description = match node.is_missing() {
+                    true => format!("MISSING {}", node.kind()),
+                    false => "ERROR".to_owned(),
+                };
+                writeln!(
+                    stdout,
+                    "{}:{}:{}: {} [{}..{}]",
+                    file,
+                    start.row + 1,
+                    start.column + 1,
+                    description,
+                    node.start_byte(),
+                    node.end_byte()
+                )
+            })
+        }
+    };
+    match root.has_error() {
+        true => EXIT_PARSE_ERRORS,
+        false => EXIT_OK,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::env;
+
+    use serde_json::Value;
+
+    use crate::cli::{
+        EXIT_OK, EXIT_PARSE_ERRORS, EXIT_USAGE, Transport, run_parse_command, transport_of,
+    };
+
+    fn args(args: &[&str]) -> Vec<String> {
+        args.iter().map(|arg| arg.to_string()).collect()
+    }
+
+    /// Parses the text saved in a temporary template, returns the exit code and the output
+    fn parse(name: &str, text: &str, options: &[&str]) -> (i32, String) {
+        let path = env::temp_dir().join(format!("freemarker-cli-{}-{}", std::process::id(), name));
+        std::fs::write(&path, text).unwrap();
+        let mut command = args(options);
+        command.push(path.to_string_lossy().into_owned());
+        let mut output = vec![];
+        let code = run_parse_command(&command, &mut output);
+        std::fs::remove_file(&path).unwrap();
+        (code, String::from_utf8(output).unwrap())
+    }
+
+    #[test]
+    fn test_transport_of() {
+        assert_eq!(transport_of(&args(&[])), Ok(Transport::Stdio));
+        assert_eq!(transport_of(&args(&["--stdio"])), Ok(Transport::Stdio));
+        assert_eq!(
+            transport_of(&args(&["--listen", ":9257"])),
+            Ok(Transport::Listen("127.0.0.1:9257".to_owned()))
+        );
+        assert_eq!(
+            transport_of(&args(&["--listen=0.0.0.0:9257"])),
+            Ok(Transport::Listen("0.0.0.0:9257".to_owned()))
+        );
+        // the last transport wins
+        assert_eq!(
+            transport_of(&args(&["--listen", ":9257", "--stdio"])),
+            Ok(Transport::Stdio)
+        );
+    }
+
+    #[test]
+    fn test_transport_of_bad_addresses() {
+        for bad in [
+            &["--listen"][..],
+            &["--listen", "9257"],
+            &["--listen", ":port"],
+            &["--listen", ":65536"],
+            &["--listen="],
+            &["--verbose"],
+        ] {
+            assert_eq!(transport_of(&args(bad)), Err(EXIT_USAGE), "{:?}", bad);
+        }
+    }
+
+    #[test]
+    fn test_parse_usage_errors() {
+        let mut output = vec![];
+        assert_eq!(run_parse_command(&args(&[]), &mut output), EXIT_USAGE);
+        assert_eq!(
+            run_parse_command(&args(&["--tree", "foo.ftl"]), &mut output),
+            EXIT_USAGE
+        );
+        assert_eq!(
+            run_parse_command(&args(&["foo.ftl", "bar.ftl"]), &mut output),
+            EXIT_USAGE
+        );
+        let missing = env::temp_dir().join("freemarker-cli-missing.ftl");
+        assert_eq!(
+            run_parse_command(&[missing.to_string_lossy().into_owned()], &mut output),
+            EXIT_USAGE
+        );
+        assert!(output.is_empty());
+    }
+
+    #[test]
+    fn test_parse_s_expression() {
+        let (code, output) = parse("sexp.ftl", "${x}", &[]);
+        assert_eq!(code, EXIT_OK);
+        assert!(output.starts_with("(source_file"));
+    }
+
+    #[test]
+    fn test_parse_json() {
+        let (code, output) = parse("json.ftl", "${x}", &["--json"]);
+        assert_eq!(code, EXIT_OK);
+        let root: Value = serde_json::from_str(&output).unwrap();
+        assert_eq!(root["kind"], "source_file");
+        assert_eq!(root["start_byte"], 0);
+        assert_eq!(root["end_byte"], 4);
+        assert_eq!(root["start"], serde_json::json!([0, 0]));
+        assert_eq!(root["end"], serde_json::json!([0, 4]));
+        assert_eq!(root["is_error"], false);
+        assert_eq!(root["is_missing"], false);
+        // only the named children are dumped
+        let text = &root["children"][0];
+        assert_eq!(text["kind"], "text");
+        assert_eq!(text["children"][0]["kind"], "interpolation");
+    }
+
+    #[test]
+    fn test_parse_errors_only() {
+        let (code, output) = parse("clean.ftl", "<#if x>y</#if>", &["--errors-only"]);
+        assert_eq!((code, output.as_str()), (EXIT_OK, ""));
+        let (code, output) = parse("broken.ftl", "<#if x>${}</#if>", &["--errors-only"]);
+        assert_eq!(code, EXIT_PARSE_ERRORS);
+        assert!(!output.is_empty());
+        for line in output.lines() {
+            // "<file>:<line>:<column>: ERROR [<start>..<end>]", 1-based
+            let (location, description) = line.split_once(": ").unwrap();
+            assert!(location.contains("broken.ftl:1:"));
+            assert!(description.starts_with("ERROR [") || description.starts_with("MISSING "));
+        }
+    }
+}
@@ -2,11 +2,23 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
+use std::sync::atomic::{AtomicBool, AtomicU8, Ordering};
+
 use tokio::sync::{OnceCell, SetError};
-use tower_lsp_server::{self, Client};
+use tower_lsp_server::{
+    self, Client,
+    ls_types::{MessageType, TraceValue},
+};
 
 static CLIENT_ONCE: OnceCell<Client> = OnceCell::const_new();
 
+// the most verbose message type sent by `window/logMessage`, INFO by default
+static LOG_LEVEL: AtomicU8 = AtomicU8::new(3);
+// the trace value of `$/logTrace`, 0 for "off", 1 for "messages" and 2 for "verbose"
+static TRACE_VALUE: AtomicU8 = AtomicU8::new(0);
+// whether `workspace/didChangeWatchedFiles` can be registered dynamically
+static WATCHED_FILES_REGISTRATION: AtomicBool = AtomicBool::new(false);
+
 pub fn save_client(c: Client) -> Result<(), SetError<Client>> {
     CLIENT_ONCE.set(c)
 }
@@ -15,32 +27,92 @@ pub fn get_client() -> Option<&'static Client> {
     CLIENT_ONCE.get()
 }
 
+fn message_type_rank(message_type: MessageType) -> u8 {
+    match message_type {
+        MessageType::ERROR => 1,
+        MessageType::WARNING => 2,
+        MessageType::INFO => 3,
+        _ => 4,
+    }
+}
+
+/// Sets the verbosity of `window/logMessage` by its name, e.g. "warn", returns false if unknown
+pub fn set_log_level(level: &str) -> bool {
+    let message_type = match level {
+        "error" => MessageType::ERROR,
+        "warn" | "warning" => MessageType::WARNING,
+        "info" => MessageType::INFO,
+        "debug" | "log" => MessageType::LOG,
+        _ => return false,
+    };
+    LOG_LEVEL.store(message_type_rank(message_type), Ordering::Relaxed);
+    true
+}
+
+pub fn is_log_enabled(message_type: MessageType) -> bool {
+    message_type_rank(message_type) <= LOG_LEVEL.load(Ordering::Relaxed)
+}
+
+pub fn set_trace_value(value: &TraceValue) {
+    let rank = match value {
+        TraceValue::Off => 0,
+        TraceValue::Messages => 1,
+        TraceValue::Verbose => 2,
+    };
+    TRACE_VALUE.store(rank, Ordering::Relaxed);
+}
+
+pub fn trace_value() -> TraceValue {
+    match TRACE_VALUE.load(Ordering::Relaxed) {
+        0 => TraceValue::Off,
+        1 => TraceValue::Messages,
+        _ => TraceValue::Verbose,
+    }
+}
+
+pub fn set_watched_files_registration(supported: bool) {
+    WATCHED_FILES_REGISTRATION.store(supported, Ordering::Relaxed);
+}
+
+pub fn supports_watched_files_registration() -> bool {
+    WATCHED_FILES_REGISTRATION.load(Ordering::Relaxed)
+}
+
+#[macro_export]
+macro_rules! window_log {
+    ($message_type:expr, $message:expr) => {
+        if let Some(c) = $crate::client::get_client()
+            && $crate::client::is_log_enabled($message_type)
+        {
+            c.log_message($message_type, $message).await;
+        }
+    };
+}
+
+#[macro_export]
+macro_rules! window_log_debug {
+    ($message:expr) => {
+        $crate::window_log!(tower_lsp_server::ls_types::MessageType::LOG, $message)
+    };
+}
+
 #[macro_export]
 macro_rules! window_log_info {
     ($message:expr) => {
-        if let Some(c) = $crate::client::get_client() {
-            c.log_message(tower_lsp_server::ls_types::MessageType::INFO, $message)
-                .await;
-        }
+        $crate::window_log!(tower_lsp_server::ls_types::MessageType::INFO, $message)
     };
 }
 
 #[macro_export]
 macro_rules! window_log_warn {
     ($message:expr) => {
-        if let Some(c) = $crate::client::get_client() {
-            c.log_message(tower_lsp_server::ls_types::MessageType::WARNING, $message)
-                .await;
-        }
+        $crate::window_log!(tower_lsp_server::ls_types::MessageType::WARNING, $message)
     };
 }
 
 #[macro_export]
 macro_rules! window_log_error {
     ($message:expr) => {
-        if let Some(c) = $crate::client::get_client() {
-            c.log_message(tower_lsp_server::ls_types::MessageType::WARNING, $message)
-                .await;
-        }
+        $crate::window_log!(tower_lsp_server::ls_types::MessageType::ERROR, $message)
     };
 }
@@ -7,18 +7,28 @@ use tower_lsp_server::{
     ls_types::{
         CompletionItem, CompletionItemKind, CompletionItemLabelDetails, CompletionOptions,
         CompletionOptionsCompletionItem, CompletionParams, CompletionResponse, Documentation,
-        InsertTextFormat, InsertTextMode, MarkupContent, MarkupKind, Position,
+        InsertTextFormat, InsertTextMode, MarkupContent, MarkupKind, Position, Uri,
     },
 };
 
 use once_cell::sync::Lazy;
 use rust_embed::Embed;
 use serde::Deserialize;
+use std::{collections::HashSet, str::FromStr};
 use strum::IntoEnumIterator;
+use tree_sitter::Node;
 use tree_sitter_freemarker::grammar::{Builtin, Rule};
 
+use crate::builtin;
+use crate::diagnosis::{self, DirectiveTag};
+use crate::doc::TextDocument;
+use crate::indexer::IndexedSymbol;
+use crate::outline::find_named_child;
 use crate::reactor::Reactor;
+use crate::reference::{self, Binding, LOOP_VARIABLE_SUFFIXES};
 use crate::server::CompletionFeature;
+use crate::signature::{argument_name, macro_call_prefix};
+use crate::utils;
 
 #[derive(Embed)]
 #[folder = "assets/completion"]
@@ -114,17 +124,287 @@ impl CompletionAsset {
 
 static STATIC_ASSETS: Lazy<CompletionAsset> = Lazy::new(CompletionAsset::new);
 
-fn completion_for_builtin() -> Vec<CompletionItem> {
-    // todo: improve filter result by partial identifier
+/// Completes the built-ins, those taking arguments are called by snippets unless the arguments
+/// are already there, e.g. `?then|(a, b)`
+fn completion_for_builtin(prefix: &str, has_arguments: bool) -> Vec<CompletionItem> {
     Builtin::iter()
-        .map(|i| CompletionItem {
-            label: i.to_string(),
-            kind: Some(CompletionItemKind::FIELD),
+        .filter(|i| i.to_string().starts_with(prefix))
+        .map(|i| {
+            let name = i.to_string();
+            let snippet = builtin::builtin_snippet(&name).filter(|_| !has_arguments);
+            CompletionItem {
+                label: name.clone(),
+                kind: Some(CompletionItemKind::FUNCTION),
+                // e.g. "left_pad(length, [padding])"
+                detail: Some(
+                    builtin::builtin_signature(&name)
+                        .unwrap_or_else(|| builtin::category_detail(&i).to_owned()),
+                ),
+                insert_text_format: snippet.as_ref().map(|_| InsertTextFormat::SNIPPET),
+                insert_text: Some(snippet.unwrap_or(name)),
+                ..Default::default()
+            }
+        })
+        .collect()
+}
+
+/// Returns the partially typed built-in name if the text ends with a built-in
+/// operator, e.g. `x?upper_case?tr` gives `Some("tr")`, while `x??` gives `None`
+/// since `??` is the missing value test operator.
+fn builtin_prefix_of(text: &str) -> Option<&str> {
+    let prefix_start = text
+        .char_indices()
+        .rev()
+        .find(|(_, c)| !(c.is_ascii_alphanumeric() || *c == '_'))
+        .map(|(i, c)| i + c.len_utf8())
+        .unwrap_or_default();
+    let (operand, prefix) = text.split_at(prefix_start);
+    let operand = operand.strip_suffix('?')?;
+    match operand.chars().last() {
+        Some(c) if c != '?' && !c.is_whitespace() => Some(prefix),
+        _ => None,
+    }
+}
+
+/// Returns the partially typed macro name and whether the square bracket syntax
+/// is used if the text ends with a macro call, e.g. `<@row` gives `Some(("row", false))`.
+fn macro_prefix_of(text: &str) -> Option<(&str, bool)> {
+    let prefix_start = text
+        .char_indices()
+        .rev()
+        .find(|(_, c)| !(c.is_alphanumeric() || *c == '_'))
+        .map(|(i, c)| i + c.len_utf8())
+        .unwrap_or_default();
+    let (call, prefix) = text.split_at(prefix_start);
+    match call {
+        _ if call.ends_with("<@") => Some((prefix, false)),
+        _ if call.ends_with("[@") => Some((prefix, true)),
+        _ => None,
+    }
+}
+
+/// Returns the partially typed variable name if the text ends inside an interpolation, e.g.
+/// `${us` gives `Some("us")`, while `${user.na` gives `None` since it is a member access.
+fn variable_prefix_of(text: &str) -> Option<&str> {
+    let opener = text.rfind("${").into_iter().chain(text.rfind("#{")).max()?;
+    let expression = &text[opener + 2..];
+    if expression.contains('}') {
+        return None;
+    }
+    let prefix_start = expression
+        .char_indices()
+        .rev()
+        .find(|(_, c)| !(c.is_alphanumeric() || *c == '_'))
+        .map(|(i, c)| i + c.len_utf8())
+        .unwrap_or_default();
+    let (head, prefix) = expression.split_at(prefix_start);
+    match head.chars().last() {
+        // members, built-ins and numbers are not variables
+        Some('.' | '?') => None,
+        _ if prefix.starts_with(|c: char| c.is_ascii_digit()) => None,
+        _ => Some(prefix),
+    }
+}
+
+/// Returns the variable and the partially typed key if the text ends with a member access of a
+/// variable, e.g. `${cfg.ho` gives `Some(("cfg", "ho"))`, while `${a.b.c` gives `None`.
+fn member_prefix_of(text: &str) -> Option<(&str, &str)> {
+    let is_name_char = |c: char| c.is_alphanumeric() || c == '_';
+    let (head, prefix) = text.split_at(text.trim_end_matches(is_name_char).len());
+    let head = head.strip_suffix('.')?;
+    let (before, base) = head.split_at(head.trim_end_matches(is_name_char).len());
+    match base.chars().next() {
+        // nested members and numbers are not resolved
+        Some(c) if !c.is_ascii_digit() && !before.ends_with(['.', '?']) => Some((base, prefix)),
+        _ => None,
+    }
+}
+
+/// Returns the partially typed directive name, whether it is a closing tag and whether the
+/// square bracket syntax is used if the text ends with a directive tag, e.g. `</#li` gives
+/// `Some(("li", true, false))`.
+fn directive_prefix_of(text: &str) -> Option<(&str, bool, bool)> {
+    let prefix = &text[text
+        .trim_end_matches(|c: char| c.is_alphanumeric() || c == '_')
+        .len()..];
+    let tag = &text[..text.len() - prefix.len()];
+    match tag {
+        _ if tag.ends_with("</#") => Some((prefix, true, false)),
+        _ if tag.ends_with("[/#") => Some((prefix, true, true)),
+        _ if tag.ends_with("<#") => Some((prefix, false, false)),
+        _ if tag.ends_with("[#") => Some((prefix, false, true)),
+        _ => None,
+    }
+}
+
+/// Returns the block directives which are open at the cursor and not closed after it, the
+/// nearest enclosing one first
+fn unclosed_directives<'a>(before: &[&'a DirectiveTag], after: &[&DirectiveTag]) -> Vec<&'a str> {
+    let mut open: Vec<&str> = vec![];
+    for tag in before {
+        match tag.is_closer {
+            // directives opened after the matched one are never closed
+            true => {
+                if let Some(index) = open.iter().rposition(|open_name| *open_name == tag.name) {
+                    open.truncate(index);
+                }
+            }
+            false => open.push(&tag.name),
+        }
+    }
+    let mut nested: Vec<&str> = vec![];
+    for tag in after {
+        let name = tag.name.as_str();
+        if !tag.is_closer {
+            nested.push(name);
+        } else if let Some(index) = nested.iter().rposition(|nested_name| *nested_name == name) {
+            nested.truncate(index);
+        } else if let Some(index) = open.iter().rposition(|open_name| *open_name == name) {
+            // balanced already
+            open.remove(index);
+        }
+    }
+    open.reverse();
+    open
+}
+
+/// Lists the closing tags of the unclosed directives, the nearest enclosing one first
+fn directive_closer_completions(
+    unclosed: &[&str],
+    is_square: bool,
+    is_tag_closed: bool,
+) -> Vec<CompletionItem> {
+    let tag_end = match (is_tag_closed, is_square) {
+        (true, _) => "",
+        (false, true) => "]",
+        (false, false) => ">",
+    };
+    unclosed
+        .iter()
+        .enumerate()
+        .map(|(index, name)| CompletionItem {
+            label: name.to_string(),
+            kind: Some(CompletionItemKind::KEYWORD),
+            detail: Some(match is_square {
+                true => format!("closes [#{}]", name),
+                false => format!("closes <#{}>", name),
+            }),
+            insert_text: Some(format!("{}{}", name, tag_end)),
+            sort_text: Some(format!("{:02}", index)),
+            preselect: Some(index == 0),
+            ..Default::default()
+        })
+        .collect()
+}
+
+/// Returns the import alias and whether a macro is called if the text ends with a member access
+/// of a namespace, e.g. `<@u.ro` gives `Some(("u", true))` and `${u.` gives `Some(("u", false))`
+fn namespace_prefix_of(text: &str) -> Option<(&str, bool)> {
+    let (alias, prefix) = member_prefix_of(text)?;
+    let head = &text[..text.len() - prefix.len() - 1 - alias.len()];
+    Some((alias, head.ends_with("<@") || head.ends_with("[@")))
+}
+
+/// Lists the members of an imported namespace, only macros can be called by `<@ns.x/>`, while
+/// the functions and variables are used in expressions
+pub fn namespace_member_completions(
+    alias: &str,
+    symbols: &[IndexedSymbol],
+    is_macro_call: bool,
+) -> Vec<CompletionItem> {
+    symbols
+        .iter()
+        .filter(|symbol| (symbol.rule == Rule::MacroName) == is_macro_call)
+        .map(|symbol| CompletionItem {
+            label: symbol.name.clone(),
+            kind: Some(match symbol.rule {
+                Rule::MacroName | Rule::FunctionName => CompletionItemKind::FUNCTION,
+                _ => CompletionItemKind::VARIABLE,
+            }),
+            detail: Some(match symbol.rule {
+                Rule::MacroName => format!("macro of {}", alias),
+                Rule::FunctionName => format!("function of {}", alias),
+                _ => format!("variable of {}", alias),
+            }),
+            insert_text: Some(symbol.name.clone()),
             ..Default::default()
         })
         .collect()
 }
 
+impl Reactor {
+    /// Returns the imported template and whether a macro is called if a member of a namespace
+    /// is being typed, e.g. `<@u.` after `<#import "utils.ftl" as u>`
+    pub fn namespace_completion_target(&self, position: &Position) -> Option<(String, Uri, bool)> {
+        let line = self.get_document().get_line_text(position.line as usize);
+        let column = self
+            .get_document()
+            .character_to_byte_column(position.line as usize, position.character)?;
+        let (alias, is_macro_call) = namespace_prefix_of(line.get(..column)?)?;
+        let uri = self.get_analysis().get_namespace_uri(alias)?;
+        Some((alias.to_owned(), uri.clone(), is_macro_call))
+    }
+}
+
+/// Describes a declared variable by where it is declared, e.g. a parameter of a macro
+fn variable_detail(declaration: &Node, scope: Option<&Node>) -> &'static str {
+    let Some(scope) = scope else {
+        return "variable";
+    };
+    if scope.kind() == Rule::ListClause.to_string() {
+        return "loop variable";
+    }
+    let mut holder = declaration.parent();
+    while let Some(current) = holder {
+        match Rule::from_str(current.kind()) {
+            Ok(Rule::MacroClause | Rule::FunctionClause) => return "parameter",
+            Ok(Rule::Variable | Rule::AssignExpression) => holder = current.parent(),
+            _ => break,
+        }
+    }
+    "local variable"
+}
+
+/// Returns the name of the innermost macro call whose body encloses the offset, e.g. "row" of
+/// `<@row cols=2>${x}</@row>`, self-closed calls have no body
+fn enclosing_macro_call(root: &Node, offset: usize, doc: &TextDocument) -> Option<String> {
+    let mut node = root.descendant_for_byte_range(offset, offset);
+    while let Some(current) = node {
+        node = current.parent();
+        if current.kind() != Rule::MacroCall.to_string() {
+            continue;
+        }
+        let Some(close_tag) = find_named_child(&current, Rule::CloseTag) else {
+            continue;
+        };
+        // the closing tag is not part of the body
+        let body_end = find_named_child(&current, Rule::MacroCallClose)
+            .map_or(current.end_byte(), |closer| closer.start_byte());
+        if close_tag.end_byte() > offset || offset > body_end {
+            continue;
+        }
+        let namespace = find_named_child(&current, Rule::MacroNamespace)?;
+        let name_end = namespace
+            .next_named_sibling()
+            .filter(|specs| specs.kind() == Rule::MacroSpecs.to_string())
+            .map_or(namespace.end_byte(), |specs| specs.end_byte());
+        return Some(doc.get_ranged_text(namespace.start_byte()..name_end));
+    }
+    None
+}
+
+/// Returns true if the macro call has been closed after the cursor, e.g. `<@|/>`
+fn is_macro_call_closed(rest: &str) -> bool {
+    let closer = rest.find("/>").into_iter().chain(rest.find("/]")).min();
+    closer.is_some_and(|closer| !rest[..closer].contains(['<', '>']))
+}
+
+fn escape_snippet(text: &str) -> String {
+    text.replace('\\', "\\\\")
+        .replace('$', "\\$")
+        .replace('}', "\\}")
+}
+
 pub fn completion_capability() -> CompletionOptions {
     CompletionOptions {
         resolve_provider: Some(false),
@@ -142,82 +422,444 @@ pub fn completion_capability() -> CompletionOptions {
 }
 
 impl CompletionFeature for Reactor {
-    fn list_macro_definitions(&self) -> Vec<CompletionItem> {
+    fn list_builtin_completions(&self, position: &Position) -> Option<Vec<CompletionItem>> {
+        let line = self.get_document().get_line_text(position.line as usize);
+        let column = self
+            .get_document()
+            .character_to_byte_column(position.line as usize, position.character)?;
+        let prefix = builtin_prefix_of(line.get(..column)?)?;
+        // built-ins are not expected in text, comments or string literals
+        let node = self
+            .get_parser()
+            .get_node_at_point(utils::lsp_position_to_parser_point(
+                position,
+                self.get_document(),
+            ))?;
+        if matches!(
+            Rule::from_str(node.kind()),
+            Ok(Rule::Comment | Rule::StringLiteral | Rule::Text)
+        ) {
+            return None;
+        }
+        let has_arguments = line[column..]
+            .trim_start_matches(|c: char| c.is_ascii_alphanumeric() || c == '_')
+            .starts_with('(');
+        Some(completion_for_builtin(prefix, has_arguments))
+    }
+
+    fn list_macro_definitions(&self, position: &Position) -> Option<Vec<CompletionItem>> {
+        let line = self.get_document().get_line_text(position.line as usize);
+        let column = self
+            .get_document()
+            .character_to_byte_column(position.line as usize, position.character)?;
+        let head = line.get(..column)?;
+        let (_, is_square) = macro_prefix_of(head)?;
+        // do not close the call twice
+        let is_closed = is_macro_call_closed(&line[column..]);
         let mut macro_definitions = vec![];
         self.get_analysis().foreach_symbol(|symbol_name, symbols| {
             let first_definition = symbols[0];
-            if matches!(first_definition.rule, Rule::MacroName | Rule::ImportAlias) {
-                macro_definitions.push(CompletionItem {
-                    label: symbol_name.to_owned(),
-                    kind: Some(CompletionItemKind::MODULE),
-                    documentation: Some(Documentation::MarkupContent(MarkupContent {
-                        kind: MarkupKind::Markdown,
-                        value: self
-                            .get_document()
-                            .get_ranged_text(first_definition.start_byte..first_definition.end_byte)
-                            .to_string(),
-                    })),
-                    insert_text: Some(symbol_name.to_owned()),
-                    insert_text_format: Some(InsertTextFormat::SNIPPET),
-                    insert_text_mode: Some(InsertTextMode::AS_IS),
+            match first_definition.rule {
+                Rule::MacroName => {
+                    let parameters = self
+                        .get_analysis()
+                        .get_macro_parameters(&first_definition)
+                        .cloned()
+                        .unwrap_or_default();
+                    let mut signature = symbol_name.to_owned();
+                    let mut insert_text = escape_snippet(symbol_name);
+                    let mut tab_stop = 0;
+                    for parameter in &parameters {
+                        signature += &match &parameter.default {
+                            Some(default) => format!(" {}={}", parameter.name, default),
+                            None => format!(" {}", parameter.name),
+                        };
+                        if parameter.default.is_none() && !is_closed {
+                            // required parameters are expanded as tab stops
+                            tab_stop += 1;
+                            insert_text +=
+                                &format!(" {}=${{{}}}", escape_snippet(&parameter.name), tab_stop);
+                        }
+                    }
+                    if !is_closed {
+                        insert_text += if is_square { "/]" } else { "/>" };
+                    }
+                    macro_definitions.push(CompletionItem {
+                        label: symbol_name.to_owned(),
+                        kind: Some(CompletionItemKind::FUNCTION),
+                        detail: Some(signature),
+                        insert_text: Some(insert_text),
+                        insert_text_format: Some(InsertTextFormat::SNIPPET),
+                        insert_text_mode: Some(InsertTextMode::AS_IS),
+                        ..Default::default()
+                    });
+                }
+                Rule::ImportAlias => {
+                    macro_definitions.push(CompletionItem {
+                        label: symbol_name.to_owned(),
+                        kind: Some(CompletionItemKind::MODULE),
+                        documentation: Some(Documentation::MarkupContent(MarkupContent {
+                            kind: MarkupKind::Markdown,
+                            value: self
+                                .get_document()
+                                .get_ranged_text(
+                                    first_definition.start_byte..first_definition.end_byte,
+                                )
+                                .to_string(),
+                        })),
+                        insert_text: Some(symbol_name.to_owned()),
+                        insert_text_format: Some(InsertTextFormat::SNIPPET),
+                        insert_text_mode: Some(InsertTextMode::AS_IS),
+                        ..Default::default()
+                    });
+                }
+                _ => {}
+            }
+        });
+        Some(macro_definitions)
+    }
+
+    fn list_macro_parameters(&self, position: &Position) -> Option<Vec<CompletionItem>> {
+        let offset = self.get_document().position_to_byte(position)?;
+        let call = macro_call_prefix(&self.get_document().get_ranged_text(0..offset))?;
+        // a parameter name is being typed, not a value
+        if !call
+            .current
+            .chars()
+            .all(|c| c.is_alphanumeric() || c == '_')
+        {
+            return None;
+        }
+        let definition = self
+            .get_analysis()
+            .find_nearest_definition(&call.name, offset)
+            .ok()
+            .filter(|definition| definition.rule == Rule::MacroName)?;
+        let parameters = self.get_analysis().get_macro_parameters(&definition)?;
+        // named arguments supplied before the cursor, and the ones after it if the call is parsed
+        let mut supplied: Vec<String> = call
+            .arguments
+            .iter()
+            .filter_map(|argument| argument_name(argument).map(str::to_owned))
+            .collect();
+        let mut node = self
+            .get_parser()
+            .get_node_at_point(utils::lsp_position_to_parser_point(
+                position,
+                self.get_document(),
+            ));
+        while let Some(current) = node {
+            if matches!(Rule::from_str(current.kind()), Ok(Rule::MacroCall)) {
+                let mut cursor = current.walk();
+                supplied.extend(
+                    current
+                        .children_by_field_name("parameter", &mut cursor)
+                        .filter_map(|argument| argument.child_by_field_name("left"))
+                        .map(|left| {
+                            self.get_document()
+                                .get_ranged_text(left.start_byte()..left.end_byte())
+                        }),
+                );
+                break;
+            }
+            node = current.parent();
+        }
+        Some(
+            parameters
+                .iter()
+                .enumerate()
+                .filter(|(_, parameter)| !supplied.contains(&parameter.name))
+                .map(|(index, parameter)| CompletionItem {
+                    label: parameter.name.clone(),
+                    kind: Some(CompletionItemKind::PROPERTY),
+                    detail: Some(match &parameter.default {
+                        Some(default) => format!("default: {}", default),
+                        None => "required".to_owned(),
+                    }),
+                    // required parameters first, then in the declared order
+                    sort_text: Some(format!("{}{:04}", parameter.default.is_some() as u8, index)),
+                    insert_text: Some(format!("{}=", parameter.name)),
+                    ..Default::default()
+                })
+                .collect(),
+        )
+    }
+
+    fn list_hash_keys(&self, position: &Position) -> Option<Vec<CompletionItem>> {
+        let line = self.get_document().get_line_text(position.line as usize);
+        let column = self
+            .get_document()
+            .character_to_byte_column(position.line as usize, position.character)?;
+        let (base, _) = member_prefix_of(line.get(..column)?)?;
+        let node = self
+            .get_parser()
+            .get_node_at_point(utils::lsp_position_to_parser_point(
+                position,
+                self.get_document(),
+            ))?;
+        if matches!(
+            Rule::from_str(node.kind()),
+            Ok(Rule::Comment | Rule::StringLiteral | Rule::Text)
+        ) {
+            return None;
+        }
+        let offset = self.get_document().position_to_byte(position)?;
+        // nothing is offered unless the variable holds a hash literal
+        let keys = self.get_analysis().find_hash_keys(base, offset)?;
+        Some(
+            keys.iter()
+                .map(|key| CompletionItem {
+                    label: key.clone(),
+                    kind: Some(CompletionItemKind::PROPERTY),
+                    detail: Some(format!("key of {}", base)),
+                    insert_text: Some(key.clone()),
+                    ..Default::default()
+                })
+                .collect(),
+        )
+    }
+
+    fn list_scope_variables(&self, position: &Position) -> Option<Vec<CompletionItem>> {
+        let line = self.get_document().get_line_text(position.line as usize);
+        let column = self
+            .get_document()
+            .character_to_byte_column(position.line as usize, position.character)?;
+        variable_prefix_of(line.get(..column)?)?;
+        let offset = self.get_document().position_to_byte(position)?;
+        let ast = self.get_parser().get_ast()?;
+        let occurrences = reference::find_occurrences(&ast.root_node(), self.get_document());
+        let mut seen = HashSet::new();
+        let mut variables = vec![];
+        // the innermost declarations come last in the document, and take precedence
+        for occurrence in occurrences.iter().rev() {
+            let Binding::Variable(name, scope_id) = &occurrence.binding else {
+                continue;
+            };
+            // variables declared later in the file are not visible yet
+            if !occurrence.declaration || occurrence.node.end_byte() > offset {
+                continue;
+            }
+            let mut scope = None;
+            if let Some(scope_id) = scope_id {
+                let mut ancestor = occurrence.node.parent();
+                while let Some(current) = ancestor {
+                    if current.id() == *scope_id {
+                        break;
+                    }
+                    ancestor = current.parent();
+                }
+                // scoped variables are only visible within their block
+                match ancestor {
+                    Some(node) if node.start_byte() <= offset && offset <= node.end_byte() => {
+                        scope = Some(node)
+                    }
+                    _ => continue,
+                }
+            }
+            let detail = variable_detail(&occurrence.node, scope.as_ref());
+            let mut names = vec![name.clone()];
+            if detail == "loop variable" {
+                names.extend(LOOP_VARIABLE_SUFFIXES.map(|suffix| format!("{}{}", name, suffix)));
+            }
+            for (index, label) in names.into_iter().enumerate() {
+                if !seen.insert(label.clone()) {
+                    continue;
+                }
+                variables.push(CompletionItem {
+                    label: label.clone(),
+                    kind: Some(CompletionItemKind::VARIABLE),
+                    detail: Some(match index {
+                        0 => detail.to_owned(),
+                        _ => format!("generated by the loop of {}", name),
+                    }),
+                    // the variables of the enclosing blocks first
+                    sort_text: Some(format!("{}{}", scope.is_none() as u8, label)),
+                    insert_text: Some(label),
                     ..Default::default()
                 });
             }
-        });
-        macro_definitions
+        }
+        // the loop variables passed by `<#nested>` to the body of the enclosing macro call
+        if let Some(name) = enclosing_macro_call(&ast.root_node(), offset, self.get_document())
+            && let Ok(definition) = self.get_analysis().find_nearest_definition(&name, offset)
+            && let Some(nested_variables) = self.get_analysis().get_nested_variables(&definition)
+        {
+            for label in nested_variables {
+                if !seen.insert(label.clone()) {
+                    continue;
+                }
+                variables.push(CompletionItem {
+                    label: label.clone(),
+                    kind: Some(CompletionItemKind::VARIABLE),
+                    detail: Some(format!("passed by <#nested> of {}", name)),
+                    sort_text: Some(format!("0{}", label)),
+                    insert_text: Some(label.clone()),
+                    ..Default::default()
+                });
+            }
+        }
+        Some(variables)
+    }
+
+    fn list_directive_completions(&self, position: &Position) -> Option<Vec<CompletionItem>> {
+        let line = self.get_document().get_line_text(position.line as usize);
+        let column = self
+            .get_document()
+            .character_to_byte_column(position.line as usize, position.character)?;
+        let head = line.get(..column)?;
+        let (prefix, is_closer, is_square) = directive_prefix_of(head)?;
+        if !is_closer {
+            // the snippets are written in the angle bracket syntax
+            return (!is_square).then(|| {
+                STATIC_ASSETS
+                    .directive_completion
+                    .iter()
+                    .filter(|item| item.label.starts_with(prefix))
+                    .cloned()
+                    .collect()
+            });
+        }
+        // the closing tag being typed is left out of the balance
+        let offset = self.get_document().position_to_byte(position)?;
+        let tag_start = offset - prefix.len() - "</#".len();
+        let ast = self.get_parser().get_ast()?;
+        let mut tags = vec![];
+        diagnosis::collect_directive_tags(&ast.root_node(), self.get_document(), &mut tags);
+        let before: Vec<&DirectiveTag> = tags
+            .iter()
+            .filter(|(node, _)| node.end_byte() <= tag_start)
+            .map(|(_, tag)| tag)
+            .collect();
+        let after: Vec<&DirectiveTag> = tags
+            .iter()
+            .filter(|(node, _)| node.start_byte() >= offset)
+            .map(|(_, tag)| tag)
+            .collect();
+        let is_tag_closed = line[column..].starts_with(['>', ']']);
+        Some(directive_closer_completions(
+            &unclosed_directives(&before, &after),
+            is_square,
+            is_tag_closed,
+        ))
     }
 
     async fn on_completion(
         &self,
         params: CompletionParams,
     ) -> JsonRpcResult<Option<CompletionResponse>> {
-        if params
-            .context
-            .as_ref()
-            .is_none_or(|ctx| ctx.trigger_character.is_none())
-        {
-            return Ok(None);
-        }
         // the position has point to 1 char after trigger
         let position = params.text_document_position.position;
-        assert!(position.character > 0);
-        let trigger_position = Position {
-            line: position.line,
-            character: position.character - 1,
-        };
-        let prev_char = self.get_document().get_prev_char_at(&trigger_position);
-        if prev_char.as_ref().is_none() {
-            return Ok(None);
-        }
-        let prev_char = prev_char.unwrap();
-        let ctx = params.context.unwrap();
-        let trigger = ctx.trigger_character.unwrap();
-        let mut result: Option<CompletionResponse> = None;
-
-        match trigger.as_str() {
-            "#" if prev_char == '<' => {
-                // triggered by '<#', expect a directive keyword
-                result = Some(CompletionResponse::Array(
-                    STATIC_ASSETS.directive_completion.clone(),
-                ));
-            }
-            "@" if prev_char == '<' => {
-                // triggered by '<@', expect a macro call
-                result = Some(CompletionResponse::Array(self.list_macro_definitions()));
-            }
-            "?" => {
-                // triggered by '?', expect a built-in
-                result = Some(CompletionResponse::Array(completion_for_builtin()));
-            }
-            _ => {}
+        if let Some(builtins) = self.list_builtin_completions(&position) {
+            // triggered by '?' or typing after it, expect a built-in
+            return Ok(Some(CompletionResponse::Array(builtins)));
+        }
+        if let Some(macros) = self.list_macro_definitions(&position) {
+            // triggered by '<@' or typing after it, expect a macro call
+            return Ok(Some(CompletionResponse::Array(macros)));
+        }
+        if let Some(parameters) = self.list_macro_parameters(&position) {
+            // typing inside the argument list of a macro call, expect a named argument
+            return Ok(Some(CompletionResponse::Array(parameters)));
         }
-        Ok(result)
+        if let Some(keys) = self.list_hash_keys(&position) {
+            // typing after '.' of a variable holding a hash literal, expect a key
+            return Ok(Some(CompletionResponse::Array(keys)));
+        }
+        if let Some(variables) = self.list_scope_variables(&position) {
+            // triggered by '${' or typing an expression in it, expect a variable
+            return Ok(Some(CompletionResponse::Array(variables)));
+        }
+        if let Some(directives) = self.list_directive_completions(&position) {
+            // triggered by '<#' or '</#' or typing after it, expect a directive keyword
+            return Ok(Some(CompletionResponse::Array(directives)));
+        }
+        Ok(None)
     }
 }
 
 #[cfg(test)]
 mod tests {
-    use crate::completion::{CompletionAsset, CompletionAssetItem};
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::Uri;
+
+    use crate::completion::{
+        CompletionAsset, CompletionAssetItem, builtin_prefix_of, directive_prefix_of,
+        enclosing_macro_call, is_macro_call_closed, macro_prefix_of, member_prefix_of,
+        namespace_prefix_of, unclosed_directives, variable_prefix_of,
+    };
+    use crate::{diagnosis, doc::TextDocument, parser::TextParser};
+
+    #[test]
+    fn test_directive_prefix() {
+        assert_eq!(directive_prefix_of("<#l"), Some(("l", false, false)));
+        assert_eq!(directive_prefix_of("  </#"), Some(("", true, false)));
+        assert_eq!(directive_prefix_of("[/#li"), Some(("li", true, true)));
+        assert_eq!(directive_prefix_of("<#--"), None);
+        assert_eq!(directive_prefix_of("<@l"), None);
+    }
+
+    fn unclosed(before: &str, after: &str) -> Vec<String> {
+        let text = format!("{}{}", before, after);
+        let doc = TextDocument::new(&Uri::from_str("file:///test.ftl").unwrap(), &text);
+        let parser = TextParser::new(&text);
+        let ast = parser.get_ast().unwrap();
+        let mut tags = vec![];
+        diagnosis::collect_directive_tags(&ast.root_node(), &doc, &mut tags);
+        let (before_tags, after_tags): (Vec<_>, Vec<_>) = tags
+            .iter()
+            .partition(|(node, _)| node.end_byte() <= before.len());
+        let before_tags: Vec<_> = before_tags.into_iter().map(|(_, tag)| tag).collect();
+        let after_tags: Vec<_> = after_tags.into_iter().map(|(_, tag)| tag).collect();
+        unclosed_directives(&before_tags, &after_tags)
+            .into_iter()
+            .map(String::from)
+            .collect()
+    }
+
+    #[test]
+    fn test_unclosed_directives() {
+        let before = "<#list xs as x><#if x>";
+        assert_eq!(unclosed(before, ""), vec!["if", "list"]);
+        // the "if" is balanced by the closer after the cursor
+        assert_eq!(unclosed(before, "</#if>"), vec!["list"]);
+        assert_eq!(unclosed(before, "<#if y></#if>"), vec!["if", "list"]);
+        assert!(unclosed("<#if x></#if>", "").is_empty());
+        // comments and inline assignments are not blocks
+        assert!(unclosed("<#-- <#if x> --><#assign y = 1>", "").is_empty());
+        assert_eq!(unclosed("<#assign y>", ""), vec!["assign"]);
+    }
+
+    fn enclosing(before: &str, after: &str) -> Option<String> {
+        let text = format!("{}{}", before, after);
+        let doc = TextDocument::new(&Uri::from_str("file:///test.ftl").unwrap(), &text);
+        let parser = TextParser::new(&text);
+        let ast = parser.get_ast().unwrap();
+        enclosing_macro_call(&ast.root_node(), before.len(), &doc)
+    }
+
+    #[test]
+    fn test_enclosing_macro_call() {
+        assert_eq!(
+            enclosing("<@row cols=2>${x", "}</@row>").as_deref(),
+            Some("row")
+        );
+        assert_eq!(
+            enclosing("<@row><@cell>", "</@cell></@row>").as_deref(),
+            Some("cell")
+        );
+        assert_eq!(
+            enclosing("<@row><@cell></@cell>", "</@row>").as_deref(),
+            Some("row")
+        );
+        assert_eq!(
+            enclosing("[@lib.row][@cell/]", "[/@lib.row]").as_deref(),
+            Some("lib.row")
+        );
+        assert_eq!(enclosing("<@row></@>", ""), None);
+        assert_eq!(enclosing("<@row/>", ""), None);
+        assert_eq!(enclosing("<@row cols=", "2/>"), None);
+    }
 
     #[test]
     fn test_asset_assign_directive() {
@@ -250,4 +892,62 @@ mod tests {
         let asset = CompletionAsset::new();
         assert!(!asset.directive_completion.is_empty());
     }
+
+    #[test]
+    fn test_builtin_prefix() {
+        assert_eq!(builtin_prefix_of("${name?"), Some(""));
+        assert_eq!(builtin_prefix_of("${seq?s"), Some("s"));
+        assert_eq!(builtin_prefix_of("${x?upper_case?tr"), Some("tr"));
+        assert_eq!(builtin_prefix_of("${x.y()?"), Some(""));
+        // the missing value test operator
+        assert_eq!(builtin_prefix_of("<#if x??"), None);
+        assert_eq!(builtin_prefix_of("${x?? "), None);
+        assert_eq!(builtin_prefix_of("?"), None);
+        assert_eq!(builtin_prefix_of("${name"), None);
+    }
+
+    #[test]
+    fn test_macro_prefix() {
+        assert_eq!(macro_prefix_of("<p><@"), Some(("", false)));
+        assert_eq!(macro_prefix_of("<@row"), Some(("row", false)));
+        assert_eq!(macro_prefix_of("[@row"), Some(("row", true)));
+        assert_eq!(macro_prefix_of("<#row"), None);
+        assert!(is_macro_call_closed("/>"));
+        assert!(is_macro_call_closed("ow a=1/>"));
+        assert!(!is_macro_call_closed(" <@other/>"));
+        assert!(!is_macro_call_closed(""));
+    }
+
+    #[test]
+    fn test_member_prefix() {
+        assert_eq!(member_prefix_of("${cfg."), Some(("cfg", "")));
+        assert_eq!(member_prefix_of("<#if cfg.ho"), Some(("cfg", "ho")));
+        assert_eq!(member_prefix_of("${a.b.c"), None);
+        assert_eq!(member_prefix_of("${x?keys."), None);
+        assert_eq!(member_prefix_of("${1."), None);
+        assert_eq!(member_prefix_of("${."), None);
+        assert_eq!(member_prefix_of("${cfg"), None);
+    }
+
+    #[test]
+    fn test_namespace_prefix() {
+        assert_eq!(namespace_prefix_of("<@u."), Some(("u", true)));
+        assert_eq!(namespace_prefix_of("[@u.ro"), Some(("u", true)));
+        assert_eq!(namespace_prefix_of("${u."), Some(("u", false)));
+        assert_eq!(namespace_prefix_of("<@u.u"), Some(("u", true)));
+        assert_eq!(namespace_prefix_of("<@u"), None);
+    }
+
+    #[test]
+    fn test_variable_prefix() {
+        assert_eq!(variable_prefix_of("${"), Some(""));
+        assert_eq!(variable_prefix_of("<p>${us"), Some("us"));
+        assert_eq!(variable_prefix_of("${a + b"), Some("b"));
+        assert_eq!(variable_prefix_of("#{coun"), Some("coun"));
+        assert_eq!(variable_prefix_of("${user.na"), None);
+        assert_eq!(variable_prefix_of("${user?up"), None);
+        assert_eq!(variable_prefix_of("${1"), None);
+        assert_eq!(variable_prefix_of("${user} na"), None);
+        assert_eq!(variable_prefix_of("user"), None);
+    }
 }
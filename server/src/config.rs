@@ -0,0 +1,184 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::{
+    path::PathBuf,
+    sync::{
+        RwLock,
+        atomic::{AtomicU64, Ordering},
+    },
+    time::Duration,
+};
+
+use once_cell::sync::Lazy;
+use serde_json::Value;
+
+use crate::doc::PositionEncodingKind;
+
+// section of the client settings, e.g. `"freemarker.templateRoots"` of VS Code
+const SETTINGS_SECTION: &str = "freemarker";
+const TEMPLATE_ROOTS_KEY: &str = "templateRoots";
+const LOG_LEVEL_KEY: &str = "logLevel";
+const MAX_FILE_SIZE_KEY: &str = "maxFileSizeBytes";
+const PARSE_TIMEOUT_KEY: &str = "parseTimeoutMs";
+
+// templates larger than this are only highlighted, e.g. the generated ones
+const DEFAULT_MAX_FILE_SIZE: u64 = 2 * 1024 * 1024;
+// a pathological template gives up parsing instead of blocking the requests
+const DEFAULT_PARSE_TIMEOUT_MS: u64 = 1000;
+
+static TEMPLATE_ROOTS: Lazy<RwLock<Vec<PathBuf>>> = Lazy::new(|| RwLock::new(vec![]));
+static WORKSPACE_FOLDERS: Lazy<RwLock<Vec<PathBuf>>> = Lazy::new(|| RwLock::new(vec![]));
+static MAX_FILE_SIZE: AtomicU64 = AtomicU64::new(DEFAULT_MAX_FILE_SIZE);
+static PARSE_TIMEOUT_MS: AtomicU64 = AtomicU64::new(DEFAULT_PARSE_TIMEOUT_MS);
+// bumped whenever applied settings change how the templates are resolved
+static SETTINGS_REVISION: AtomicU64 = AtomicU64::new(0);
+// UTF-16 is the default of LSP until the client agrees on another one
+static POSITION_ENCODING: Lazy<RwLock<PositionEncodingKind>> =
+    Lazy::new(|| RwLock::new(PositionEncodingKind::UTF16));
+
+/// Returns the directories which absolute template paths (e.g. "/commons.ftl") are relative to
+pub fn template_roots() -> Vec<PathBuf> {
+    TEMPLATE_ROOTS
+        .read()
+        .map(|roots| roots.clone())
+        .unwrap_or_default()
+}
+
+pub fn set_template_roots(roots: Vec<PathBuf>) {
+    if let Ok(mut template_roots) = TEMPLATE_ROOTS.write() {
+        *template_roots = roots;
+    }
+}
+
+/// Returns the workspace folders which the configured template roots are relative to
+pub fn workspace_folders() -> Vec<PathBuf> {
+    WORKSPACE_FOLDERS
+        .read()
+        .map(|folders| folders.clone())
+        .unwrap_or_default()
+}
+
+pub fn set_workspace_folders(folders: Vec<PathBuf>) {
+    if let Ok(mut workspace_folders) = WORKSPACE_FOLDERS.write() {
+        *workspace_folders = folders;
+    }
+}
+
+/// Reads `templateRoots` of the settings, either at the top level as `initializationOptions`
+/// sends it or under the "freemarker" section as `workspace/didChangeConfiguration` does. A
+/// relative root is looked up in each workspace folder, the roots keep their configured order.
+fn parse_template_roots(settings: &Value, folders: &[PathBuf]) -> Option<Vec<PathBuf>> {
+    let roots = settings
+        .get(TEMPLATE_ROOTS_KEY)
+        .or_else(|| settings.get(SETTINGS_SECTION)?.get(TEMPLATE_ROOTS_KEY))?
+        .as_array()?;
+    let mut resolved = vec![];
+    for root in roots.iter().filter_map(Value::as_str) {
+        let root = PathBuf::from(root);
+        match root.is_absolute() || folders.is_empty() {
+            true => resolved.push(root),
+            false => resolved.extend(folders.iter().map(|folder| folder.join(&root))),
+        }
+    }
+    if resolved.is_empty() {
+        // the workspace folders are the roots by default
+        resolved = folders.to_vec();
+    }
+    Some(resolved)
+}
+
+/// Reads `logLevel` of the settings, e.g. "warn", in the same places as `templateRoots`
+pub fn log_level_of(settings: &Value) -> Option<&str> {
+    settings
+        .get(LOG_LEVEL_KEY)
+        .or_else(|| settings.get(SETTINGS_SECTION)?.get(LOG_LEVEL_KEY))?
+        .as_str()
+}
+
+/// Reads `maxFileSizeBytes` of the settings, in the same places as `templateRoots`
+pub fn max_file_size_of(settings: &Value) -> Option<u64> {
+    settings
+        .get(MAX_FILE_SIZE_KEY)
+        .or_else(|| settings.get(SETTINGS_SECTION)?.get(MAX_FILE_SIZE_KEY))?
+        .as_u64()
+}
+
+/// Returns the size in bytes above which a template gets no features but highlighting
+pub fn max_file_size() -> u64 {
+    MAX_FILE_SIZE.load(Ordering::Relaxed)
+}
+
+pub fn set_max_file_size(size: u64) {
+    MAX_FILE_SIZE.store(size, Ordering::Relaxed);
+}
+
+/// Reads `parseTimeoutMs` of the settings, in the same places as `templateRoots`
+pub fn parse_timeout_of(settings: &Value) -> Option<u64> {
+    settings
+        .get(PARSE_TIMEOUT_KEY)
+        .or_else(|| settings.get(SETTINGS_SECTION)?.get(PARSE_TIMEOUT_KEY))?
+        .as_u64()
+}
+
+/// Returns how long a template is parsed before the parsing is cancelled
+pub fn parse_timeout() -> Duration {
+    Duration::from_millis(PARSE_TIMEOUT_MS.load(Ordering::Relaxed))
+}
+
+pub fn set_parse_timeout(millis: u64) {
+    PARSE_TIMEOUT_MS.store(millis, Ordering::Relaxed);
+}
+
+/// Returns the position encoding negotiated with the client, which counts the characters of
+/// every position sent or received
+pub fn position_encoding() -> PositionEncodingKind {
+    POSITION_ENCODING
+        .read()
+        .map(|encoding| *encoding)
+        .unwrap_or(PositionEncodingKind::UTF16)
+}
+
+pub fn set_position_encoding(encoding: PositionEncodingKind) {
+    if let Ok(mut position_encoding) = POSITION_ENCODING.write() {
+        *position_encoding = encoding;
+    }
+}
+
+/// Returns the revision of the applied settings, which changes whenever the template roots do
+pub fn settings_revision() -> u64 {
+    SETTINGS_REVISION.load(Ordering::Relaxed)
+}
+
+/// Applies the template roots of the settings, returns whether they changed. The roots are left
+/// untouched if the settings do not mention them.
+pub fn apply_settings(settings: &Value) -> bool {
+    let Some(roots) = parse_template_roots(settings, &workspace_folders()) else {
+        return false;
+    };
+    if roots == template_roots() {
+        return false;
+    }
+    set_template_roots(roots);
+    SETTINGS_REVISION.fetch_add(1, Ordering::Relaxed);
+    true
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use crate::config::{max_file_size_of, parse_timeout_of};
+
+    #[test]
+    fn test_settings_of() {
+        // "initializationOptions" holds the keys at the top level
+        let settings = json!({ "maxFileSizeBytes": 1024, "parseTimeoutMs": 500 });
+        assert_eq!(max_file_size_of(&settings), Some(1024));
+        assert_eq!(parse_timeout_of(&settings), Some(500));
+        let settings = json!({ "freemarker": { "parseTimeoutMs": 250 } });
+        assert_eq!(parse_timeout_of(&settings), Some(250));
+        assert_eq!(max_file_size_of(&settings), None);
+    }
+}
@@ -2,14 +2,22 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
-use std::str::FromStr;
+use std::{
+    collections::{HashMap, HashSet},
+    hash::{DefaultHasher, Hash, Hasher},
+    str::FromStr,
+};
 
 use tower_lsp_server::{
     jsonrpc,
     ls_types::{
         CodeDescription, Diagnostic, DiagnosticOptions, DiagnosticServerCapabilities,
-        DiagnosticSeverity, DocumentDiagnosticParams, DocumentDiagnosticReport,
-        DocumentDiagnosticReportResult, NumberOrString,
+        DiagnosticSeverity, DiagnosticTag, DocumentDiagnosticParams, DocumentDiagnosticReport,
+        DocumentDiagnosticReportResult, FullDocumentDiagnosticReport, NumberOrString, Range,
+        RelatedFullDocumentDiagnosticReport, RelatedUnchangedDocumentDiagnosticReport,
+        UnchangedDocumentDiagnosticReport, Uri, WorkspaceDiagnosticParams,
+        WorkspaceDiagnosticReport, WorkspaceDocumentDiagnosticReport,
+        WorkspaceFullDocumentDiagnosticReport, WorkspaceUnchangedDocumentDiagnosticReport,
     },
 };
 use tree_sitter::Node;
@@ -17,15 +25,20 @@ use tree_sitter_freemarker::{
     SEMANTICS, SYNTAX,
     grammar::Rule,
     href::{
-        COMPARISION_EXPRESSION, DIRECTIVE_ASSIGN, DIRECTIVE_IMPORT, DIRECTIVE_LIST_BREAK,
-        TOPLEVEL_VARIABLE,
+        AUTO_ESCAPING, BUILTINS, COMPARISION_EXPRESSION, DIRECTIVE_ASSIGN, DIRECTIVE_FTL,
+        DIRECTIVE_FUNCTION, DIRECTIVE_IMPORT, DIRECTIVE_INCLUDE, DIRECTIVE_LIST,
+        DIRECTIVE_LIST_BREAK, DIRECTIVE_MACRO, DIRECTIVES, NUMERICAL_INTERPOLATION,
+        TEMPLATE_STRUCTURE, TOPLEVEL_VARIABLE,
     },
 };
 
 use crate::{
-    analysis::{Analysis, AnalysisContext, DiagnosticAnalysis, Symbol},
+    analysis::{Analysis, AnalysisContext, DiagnosticAnalysis, OpenDirective, Symbol},
+    builtin, config,
     doc::TextDocument,
+    goto,
     reactor::Reactor,
+    reference,
     server::DiagnosticFeature,
     utils,
 };
@@ -33,8 +46,9 @@ use crate::{
 pub fn diagnostic_capability() -> DiagnosticServerCapabilities {
     DiagnosticServerCapabilities::Options(DiagnosticOptions {
         identifier: None,
+        // imports and includes are resolved against the other templates
         inter_file_dependencies: true,
-        workspace_diagnostics: false,
+        workspace_diagnostics: true,
         work_done_progress_options: Default::default(),
     })
 }
@@ -56,6 +70,22 @@ impl Scenario {
         href: DIRECTIVE_IMPORT,
     };
 
+    pub const UNRESOLVED_IMPORT: Scenario = Scenario {
+        severity: DiagnosticSeverity::WARNING,
+        code: "unresolved_template",
+        source: SEMANTICS,
+        message: "Cannot resolve template.",
+        href: DIRECTIVE_IMPORT,
+    };
+
+    pub const UNRESOLVED_INCLUDE: Scenario = Scenario {
+        severity: DiagnosticSeverity::WARNING,
+        code: "unresolved_template",
+        source: SEMANTICS,
+        message: "Cannot resolve template.",
+        href: DIRECTIVE_INCLUDE,
+    };
+
     const BACKSLASHED_IDENTIFIER: Scenario = Scenario {
         severity: DiagnosticSeverity::INFORMATION,
         code: "identifier_has_backslash",
@@ -96,6 +126,118 @@ impl Scenario {
         href: DIRECTIVE_LIST_BREAK,
     };
 
+    const DEPRECATED_NUMERIC_INTERPOLATION: Scenario = Scenario {
+        severity: DiagnosticSeverity::WARNING,
+        code: "deprecated_numeric_interpolation",
+        source: SYNTAX,
+        message: "The numerical interpolation '#{...}' is deprecated. Use '${...}' with the ?string built-in instead, e.g. ${x?string(\"0.00#\")} for 'm2M3'.",
+        href: NUMERICAL_INTERPOLATION,
+    };
+
+    const LOOP_VARIABLE_OUT_OF_LOOP: Scenario = Scenario {
+        severity: DiagnosticSeverity::WARNING,
+        code: "loop_variable_out_of_loop",
+        source: SEMANTICS,
+        message: "The variable is generated by a loop, it is only defined inside the loop.",
+        href: DIRECTIVE_LIST,
+    };
+
+    const REDUNDANT_ESCAPING: Scenario = Scenario {
+        severity: DiagnosticSeverity::INFORMATION,
+        code: "redundant_escaping",
+        source: SEMANTICS,
+        message: "The built-in escapes the same way as the auto-escaping of the output format.",
+        href: AUTO_ESCAPING,
+    };
+
+    const UNESCAPED_INTERPOLATION: Scenario = Scenario {
+        severity: DiagnosticSeverity::WARNING,
+        code: "unescaped_interpolation",
+        source: SEMANTICS,
+        message: "The interpolation is not escaped, since auto-escaping is off here.",
+        href: AUTO_ESCAPING,
+    };
+
+    const MISPLACED_RETURN: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "misplaced_return",
+        source: SYNTAX,
+        message: "The <#return> directive with a value can only be used within <#function> blocks.",
+        href: DIRECTIVE_FUNCTION,
+    };
+
+    const RETURN_VALUE_IN_MACRO: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "return_value_in_macro",
+        source: SYNTAX,
+        message: "A macro can not return a value, use <#return> without a value or define a <#function> instead.",
+        href: DIRECTIVE_MACRO,
+    };
+
+    const MISPLACED_NESTED: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "misplaced_nested",
+        source: SYNTAX,
+        message: "The <#nested> directive can only be used within <#macro> blocks.",
+        href: DIRECTIVE_MACRO,
+    };
+
+    const SYNTAX_ERROR: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "syntax_error",
+        source: SYNTAX,
+        message: "Syntax error.",
+        href: TEMPLATE_STRUCTURE,
+    };
+
+    const MISSING_TOKEN: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "missing_token",
+        source: SYNTAX,
+        message: "Missing token.",
+        href: TEMPLATE_STRUCTURE,
+    };
+
+    const UNCLOSED_DIRECTIVE: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "unclosed_directive",
+        source: SYNTAX,
+        message: "Unclosed directive.",
+        href: DIRECTIVES,
+    };
+
+    const UNEXPECTED_CLOSE_TAG: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "unexpected_close_tag",
+        source: SYNTAX,
+        message: "Unexpected closing tag.",
+        href: DIRECTIVES,
+    };
+
+    const MISPLACED_FTL_HEADER: Scenario = Scenario {
+        severity: DiagnosticSeverity::ERROR,
+        code: "misplaced_ftl_header",
+        source: SYNTAX,
+        message: "The #ftl directive must be the very first thing in the template, only white-space is allowed before it.",
+        href: DIRECTIVE_FTL,
+    };
+
+    const UNKNOWN_FTL_SETTING: Scenario = Scenario {
+        severity: DiagnosticSeverity::WARNING,
+        code: "unknown_ftl_setting",
+        source: SEMANTICS,
+        message: "Unknown setting of the #ftl directive.",
+        href: DIRECTIVE_FTL,
+    };
+
+    const UNKNOWN_BUILTIN: Scenario = Scenario {
+        severity: DiagnosticSeverity::WARNING,
+        code: "unknown_builtin",
+        source: SEMANTICS,
+        message: "Unknown built-in.",
+        href: BUILTINS,
+    };
+
     const UNEXPECTED_BREAK_STMT: Scenario = Scenario {
         severity: DiagnosticSeverity::ERROR,
         code: "unexpected_break_stmt",
@@ -120,6 +262,547 @@ impl From<Scenario> for Diagnostic {
     }
 }
 
+// directives that always require a closing tag
+pub const BLOCK_DIRECTIVES: [&str; 8] = [
+    "autoesc",
+    "function",
+    "if",
+    "list",
+    "macro",
+    "noautoesc",
+    "outputformat",
+    "switch",
+];
+// directives that require a closing tag in the capture form only
+pub const CAPTURE_DIRECTIVES: [&str; 3] = ["assign", "global", "local"];
+
+fn opening_tag_range(opener: &Node, doc: &TextDocument) -> Range {
+    // the opening tag ends with the close tag of the following clause
+    let mut range = utils::parser_node_to_document_range(opener, doc);
+    let mut sibling = opener.next_sibling();
+    while let Some(node) = sibling {
+        let mut cursor = node.walk();
+        if let Some(close_tag) = node.children(&mut cursor).find(|child| {
+            matches!(
+                Rule::from_str(child.kind()),
+                Ok(Rule::CloseTag | Rule::MacroCloseTag)
+            )
+        }) {
+            range.end = utils::parser_node_to_document_range(&close_tag, doc).end;
+            break;
+        }
+        sibling = node.next_sibling();
+    }
+    range
+}
+
+/// An opening or closing tag of a block directive, including the capturing form of the
+/// assignments
+pub struct DirectiveTag {
+    pub(crate) name: String,
+    pub(crate) is_closer: bool,
+    pub(crate) is_square: bool,
+}
+
+/// Returns the block directive tag of a leaf token, tags are checked by text since they are
+/// anonymous tokens inside ERROR nodes
+pub fn directive_tag(node: &Node, doc: &TextDocument) -> Option<DirectiveTag> {
+    if node.child_count() > 0 || node.is_missing() {
+        return None;
+    }
+    let node_text = doc.get_ranged_text(node.start_byte()..node.end_byte());
+    let is_square = node_text.starts_with('[');
+    let name = utils::directive_name(&node_text);
+    let is_block = if node_text.starts_with("</#") || node_text.starts_with("[/#") {
+        BLOCK_DIRECTIVES.contains(&name) || CAPTURE_DIRECTIVES.contains(&name)
+    } else if node_text.starts_with("<#") || node_text.starts_with("[#") {
+        BLOCK_DIRECTIVES.contains(&name)
+            || (CAPTURE_DIRECTIVES.contains(&name)
+                && node.next_named_sibling().is_some_and(|sibling| {
+                    matches!(
+                        Rule::from_str(sibling.kind()),
+                        Ok(Rule::AssignClause | Rule::GlobalClause | Rule::LocalClause)
+                    )
+                }))
+    } else {
+        false
+    };
+    is_block.then(|| DirectiveTag {
+        name: name.to_owned(),
+        is_closer: node_text.as_bytes().get(1) == Some(&b'/'),
+        is_square,
+    })
+}
+
+/// Collects the block directive tags of the tree in document order
+pub fn collect_directive_tags<'a>(
+    node: &Node<'a>,
+    doc: &TextDocument,
+    tags: &mut Vec<(Node<'a>, DirectiveTag)>,
+) {
+    if let Some(tag) = directive_tag(node, doc) {
+        tags.push((*node, tag));
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        collect_directive_tags(&child, doc, tags);
+    }
+}
+
+fn analyze_directive_balance(
+    analysis: &mut Analysis,
+    node: &Node,
+    doc: &TextDocument,
+    ctx: &mut AnalysisContext,
+) {
+    let Some(tag) = directive_tag(node, doc) else {
+        return;
+    };
+    if tag.is_closer {
+        match ctx.open_directives.iter().rposition(|d| d.name == tag.name) {
+            Some(index) => {
+                // directives opened after the matched one are never closed
+                for unclosed in ctx.open_directives.split_off(index).iter().skip(1) {
+                    analysis.add_diagnostic(unclosed_directive_diagnostic(unclosed));
+                }
+            }
+            None => analysis.add_diagnostic(Diagnostic {
+                range: utils::parser_node_to_document_range(node, doc),
+                message: format!(
+                    "Unexpected closing tag `{}`, no `#{}` directive is open.",
+                    doc.get_ranged_text(node.start_byte()..node.end_byte()),
+                    tag.name
+                ),
+                ..Scenario::UNEXPECTED_CLOSE_TAG.into()
+            }),
+        }
+    } else {
+        ctx.open_directives.push(OpenDirective {
+            closer: match tag.is_square {
+                true => format!("[/#{}]", tag.name),
+                false => format!("</#{}>", tag.name),
+            },
+            name: tag.name,
+            range: opening_tag_range(node, doc),
+        });
+    }
+}
+
+// settings of https://freemarker.apache.org/docs/ref_directive_ftl.html, in both naming conventions
+const FTL_SETTINGS: [&str; 16] = [
+    "attributes",
+    "auto_esc",
+    "autoEsc",
+    "encoding",
+    "interpolation_syntax",
+    "interpolationSyntax",
+    "ns_prefixes",
+    "nsPrefixes",
+    "output_format",
+    "outputFormat",
+    "strict_syntax",
+    "strictSyntax",
+    "strip_text",
+    "stripText",
+    "strip_whitespace",
+    "stripWhitespace",
+];
+
+fn analyze_ftl_header(analysis: &mut Analysis, node: &Node, doc: &TextDocument) {
+    let leading_text = doc.get_ranged_text(0..node.start_byte());
+    if !leading_text
+        .trim_start_matches('\u{feff}')
+        .trim()
+        .is_empty()
+    {
+        analysis.add_diagnostic(Diagnostic {
+            range: utils::parser_node_to_document_range(node, doc),
+            ..Scenario::MISPLACED_FTL_HEADER.into()
+        });
+    }
+    let mut cursor = node.walk();
+    for name_node in node
+        .named_children(&mut cursor)
+        .filter_map(|parameter| parameter.child_by_field_name("name"))
+    {
+        let name = doc.get_ranged_text(name_node.start_byte()..name_node.end_byte());
+        if !FTL_SETTINGS.contains(&name.as_str()) {
+            analysis.add_diagnostic(Diagnostic {
+                range: utils::parser_node_to_document_range(&name_node, doc),
+                message: format!("Unknown setting `{}` of the #ftl directive.", name),
+                ..Scenario::UNKNOWN_FTL_SETTING.into()
+            });
+        }
+    }
+}
+
+// longest text quoted by a syntax error
+const SYNTAX_ERROR_SNIPPET_LENGTH: usize = 24;
+
+/// Describes what a MISSING node stands for, e.g. "}" or ">"
+fn expected_token(node: &Node) -> String {
+    if !node.is_named() {
+        return node.kind().to_owned();
+    }
+    match Rule::from_str(node.kind()) {
+        Ok(Rule::CloseTag | Rule::MacroCloseTag) => ">".to_owned(),
+        Ok(Rule::StringLiteral) => "\"".to_owned(),
+        _ => node.kind().replace('_', " "),
+    }
+}
+
+fn syntax_error_snippet(doc: &TextDocument, node: &Node) -> String {
+    let text = doc.get_ranged_text(node.start_byte()..node.end_byte());
+    let first_line = text.trim().lines().next().unwrap_or_default();
+    match first_line.char_indices().nth(SYNTAX_ERROR_SNIPPET_LENGTH) {
+        Some((end, _)) => format!("{}…", &first_line[..end]),
+        None => first_line.to_owned(),
+    }
+}
+
+fn collect_syntax_errors(node: &Node, doc: &TextDocument, diagnostics: &mut Vec<Diagnostic>) {
+    // one typo usually breaks several nodes around it, only the outermost one is reported
+    let is_reported = |range: &Range, diagnostics: &[Diagnostic]| {
+        diagnostics
+            .last()
+            .is_some_and(|last| range.start <= last.range.end)
+    };
+    if node.is_error() {
+        let range = utils::parser_node_to_document_range(node, doc);
+        if !is_reported(&range, diagnostics) {
+            diagnostics.push(Diagnostic {
+                range,
+                message: format!("Syntax error near `{}`.", syntax_error_snippet(doc, node)),
+                ..Scenario::SYNTAX_ERROR.into()
+            });
+        }
+        return;
+    }
+    // NOTE: missing closing tags are reported as unclosed directives
+    if node.is_missing() && !node.kind().ends_with("_close") {
+        let start = utils::parser_node_to_document_range(node, doc).start;
+        let range = Range::new(start, start);
+        if !is_reported(&range, diagnostics) {
+            diagnostics.push(Diagnostic {
+                range,
+                message: format!("Expected `{}`.", expected_token(node)),
+                ..Scenario::MISSING_TOKEN.into()
+            });
+        }
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        if child.has_error() {
+            collect_syntax_errors(&child, doc, diagnostics);
+        }
+    }
+}
+
+fn analyze_syntax_errors(analysis: &mut Analysis, root: &Node, doc: &TextDocument) {
+    let mut diagnostics = vec![];
+    collect_syntax_errors(root, doc, &mut diagnostics);
+    analysis.add_diagnostics(diagnostics);
+}
+
+/// The range of a built-in reference, including the leading '?'
+fn builtin_reference_range(name: &Node, doc: &TextDocument) -> Range {
+    let range = utils::parser_node_to_document_range(name, doc);
+    match name.prev_sibling() {
+        Some(question) => Range::new(
+            utils::parser_node_to_document_range(&question, doc).start,
+            range.end,
+        ),
+        None => range,
+    }
+}
+
+fn collect_builtin_names<'a>(node: &Node<'a>, names: &mut Vec<Node<'a>>) {
+    if node.kind() == Rule::BuiltinName.to_string() {
+        names.push(*node);
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        collect_builtin_names(&child, names);
+    }
+}
+
+fn analyze_unknown_builtin(analysis: &mut Analysis, node: &Node, doc: &TextDocument) {
+    let name = doc.get_ranged_text(node.start_byte()..node.end_byte());
+    // built-ins taking arguments are known even if the arguments are not typed yet
+    if builtin::is_known_builtin(&name) {
+        return;
+    }
+    let suggestion = match builtin::suggest_builtin(&name) {
+        Some(known) => format!(" Did you mean `?{}`?", known),
+        None => String::new(),
+    };
+    analysis.add_diagnostic(Diagnostic {
+        range: builtin_reference_range(node, doc),
+        message: format!("Unknown built-in `?{}`.{}", name, suggestion),
+        ..Scenario::UNKNOWN_BUILTIN.into()
+    });
+}
+
+fn analyze_loop_builtins(analysis: &mut Analysis, root: &Node, doc: &TextDocument) {
+    let occurrences = reference::find_occurrences(root, doc);
+    let name_of = |node: &Node| doc.get_ranged_text(node.start_byte()..node.end_byte());
+    let declared: HashSet<String> = occurrences
+        .iter()
+        .filter(|occurrence| occurrence.declaration)
+        .map(|occurrence| name_of(&occurrence.node))
+        .collect();
+    let loop_variables: HashSet<String> = occurrences
+        .iter()
+        .filter(|occurrence| {
+            occurrence.declaration
+                && occurrence
+                    .node
+                    .parent()
+                    .is_some_and(|parent| parent.kind() == Rule::ListClause.to_string())
+        })
+        .map(|occurrence| name_of(&occurrence.node))
+        .collect();
+    if loop_variables.is_empty() {
+        return;
+    }
+    for occurrence in occurrences
+        .iter()
+        .filter(|occurrence| !occurrence.declaration)
+    {
+        let name = name_of(&occurrence.node);
+        // e.g. "user_index" used after <#list users as user>, unless assigned explicitly
+        if declared.contains(&name) {
+            continue;
+        }
+        let Some((base, suffix)) = reference::split_loop_builtin(&name) else {
+            continue;
+        };
+        if loop_variables.contains(base)
+            && reference::enclosing_loop_variable(&occurrence.node, base, doc).is_none()
+        {
+            analysis.add_diagnostic(Diagnostic {
+                range: utils::parser_node_to_document_range(&occurrence.node, doc),
+                message: format!(
+                    "`{}` is only defined inside the loop of `{}`, use `{}?{}` there.",
+                    name,
+                    base,
+                    base,
+                    &suffix[1..]
+                ),
+                ..Scenario::LOOP_VARIABLE_OUT_OF_LOOP.into()
+            });
+        }
+    }
+}
+
+// output formats which are auto-escaped by default, see
+// https://freemarker.apache.org/docs/dgui_misc_autoescaping.html
+const MARKUP_OUTPUT_FORMATS: [&str; 4] = ["HTML", "XHTML", "XML", "RTF"];
+
+// the legacy escaping built-ins, and the output format escaping the same way
+const LEGACY_ESCAPING_BUILTINS: [(&str, &str); 3] =
+    [("html", "HTML"), ("xhtml", "XHTML"), ("xml", "XML")];
+
+// built-ins whose results are escaped, or safe to print in markup
+const ESCAPED_RESULT_BUILTINS: [&str; 17] = [
+    "c",
+    "cn",
+    "esc",
+    "no_esc",
+    "html",
+    "xhtml",
+    "xml",
+    "rtf",
+    "j_string",
+    "js_string",
+    "json_string",
+    "url",
+    "url_path",
+    "size",
+    "length",
+    "index_of",
+    "last_index_of",
+];
+
+/// The output format in effect at a point of the template
+#[derive(Clone, Debug)]
+struct OutputFormat {
+    // `None` if undefined
+    name: Option<String>,
+    auto_escaping: bool,
+    // a markup format is in effect around, e.g. the HTML around `<#noautoesc>`
+    within_markup: bool,
+}
+
+fn is_markup_format(name: &str) -> bool {
+    MARKUP_OUTPUT_FORMATS.contains(&name)
+}
+
+impl OutputFormat {
+    /// Whether user data is printed as is, i.e. in a `plainText` or undefined output format, or
+    /// with the auto-escaping of a markup format turned off. Formats like `JSON` or `CSS` are
+    /// not escaped on purpose.
+    fn is_unescaped(&self) -> bool {
+        !self.auto_escaping
+            && (self.within_markup
+                || self
+                    .name
+                    .as_deref()
+                    .is_none_or(|name| matches!(name, "plainText" | "undefined")))
+    }
+}
+
+fn template_output_format(analysis: &Analysis, doc: &TextDocument) -> OutputFormat {
+    let header = analysis.get_ftl_header();
+    // the standard file extensions imply the output format, unless the header sets one
+    let name = header
+        .and_then(|header| header.output_format.clone())
+        .or_else(|| {
+            let extension = doc.uri().to_file_path().and_then(|path| {
+                path.extension()
+                    .map(|extension| extension.to_string_lossy().into_owned())
+            });
+            match extension.as_deref() {
+                Some("ftlh") => Some("HTML".to_owned()),
+                Some("ftlx") => Some("XML".to_owned()),
+                _ => None,
+            }
+        });
+    let is_markup = name.as_deref().is_some_and(is_markup_format);
+    OutputFormat {
+        auto_escaping: is_markup && header.and_then(|header| header.auto_esc).unwrap_or(true),
+        within_markup: is_markup,
+        name,
+    }
+}
+
+/// Applies the `<#outputformat>`, `<#autoesc>` and `<#noautoesc>` blocks enclosing the node to
+/// the output format of the template, from the outermost one
+fn output_format_at(node: &Node, template: &OutputFormat, doc: &TextDocument) -> OutputFormat {
+    let mut blocks = vec![];
+    let mut cursor = node.parent();
+    while let Some(ancestor) = cursor {
+        if matches!(
+            Rule::from_str(ancestor.kind()),
+            Ok(Rule::OutputformatClause | Rule::AutoescClause | Rule::NoautoescClause)
+        ) {
+            blocks.push(ancestor);
+        }
+        cursor = ancestor.parent();
+    }
+    let mut format = template.clone();
+    for block in blocks.iter().rev() {
+        match Rule::from_str(block.kind()) {
+            Ok(Rule::OutputformatClause) => {
+                let Some(name_node) = block.child_by_field_name("format") else {
+                    continue;
+                };
+                let name = doc.get_ranged_text(name_node.start_byte()..name_node.end_byte());
+                let name = name.trim_matches(['"', '\'']).to_owned();
+                format.auto_escaping = is_markup_format(&name);
+                format.within_markup |= format.auto_escaping;
+                format.name = Some(name);
+            }
+            // only allowed in markup formats, where it restores the default
+            Ok(Rule::AutoescClause) => {
+                format.auto_escaping = format.name.as_deref().is_some_and(is_markup_format)
+            }
+            Ok(Rule::NoautoescClause) => format.auto_escaping = false,
+            _ => {}
+        }
+    }
+    format
+}
+
+fn is_escaped_interpolation(node: &Node, doc: &TextDocument) -> bool {
+    // literals are written by the template author, not user data
+    if node.named_child(1).is_some_and(|value| {
+        matches!(
+            Rule::from_str(value.kind()),
+            Ok(Rule::StringLiteral | Rule::Number | Rule::BooleanTrue | Rule::BooleanFalse)
+        )
+    }) {
+        return true;
+    }
+    let mut names = vec![];
+    collect_builtin_names(node, &mut names);
+    names.iter().any(|name| {
+        ESCAPED_RESULT_BUILTINS.contains(
+            &doc.get_ranged_text(name.start_byte()..name.end_byte())
+                .as_str(),
+        )
+    })
+}
+
+fn collect_interpolations<'a>(node: &Node<'a>, interpolations: &mut Vec<Node<'a>>) {
+    if node.kind() == Rule::Interpolation.to_string() {
+        interpolations.push(*node);
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.children(&mut cursor) {
+        collect_interpolations(&child, interpolations);
+    }
+}
+
+fn analyze_escaping(analysis: &mut Analysis, root: &Node, doc: &TextDocument) {
+    let template = template_output_format(analysis, doc);
+    let mut names = vec![];
+    collect_builtin_names(root, &mut names);
+    for node in names {
+        let name = doc.get_ranged_text(node.start_byte()..node.end_byte());
+        let Some((_, escaped_format)) = LEGACY_ESCAPING_BUILTINS
+            .iter()
+            .find(|(legacy, _)| *legacy == name)
+        else {
+            continue;
+        };
+        let format = output_format_at(&node, &template, doc);
+        if format.auto_escaping && format.name.as_deref() == Some(*escaped_format) {
+            analysis.add_diagnostic(Diagnostic {
+                range: builtin_reference_range(&node, doc),
+                message: format!(
+                    "Redundant `?{}` — output is already {}-escaped",
+                    name, escaped_format
+                ),
+                ..Scenario::REDUNDANT_ESCAPING.into()
+            });
+        }
+    }
+    let mut interpolations = vec![];
+    collect_interpolations(root, &mut interpolations);
+    for interpolation in interpolations {
+        let format = output_format_at(&interpolation, &template, doc);
+        if !format.is_unescaped() || is_escaped_interpolation(&interpolation, doc) {
+            continue;
+        }
+        analysis.add_diagnostic(Diagnostic {
+            range: utils::parser_node_to_document_range(&interpolation, doc),
+            message: format!(
+                "Unescaped interpolation — auto-escaping is off in this `{}` context, escape user data explicitly.",
+                format.name.as_deref().unwrap_or("undefined")
+            ),
+            ..Scenario::UNESCAPED_INTERPOLATION.into()
+        });
+    }
+}
+
+fn unclosed_directive_diagnostic(unclosed: &OpenDirective) -> Diagnostic {
+    Diagnostic {
+        range: unclosed.range,
+        message: format!(
+            "Unclosed directive `#{}` opened at line {}, expected `{}`.",
+            unclosed.name,
+            unclosed.range.start.line + 1,
+            unclosed.closer
+        ),
+        ..Scenario::UNCLOSED_DIRECTIVE.into()
+    }
+}
+
 impl DiagnosticAnalysis for Analysis {
     fn analyze_diagnostic_report(
         &mut self,
@@ -128,28 +811,16 @@ impl DiagnosticAnalysis for Analysis {
         ctx: &mut AnalysisContext,
     ) {
         let node_kind = node.kind();
-        let range = utils::parser_node_to_document_range(node);
-        // TODO: maybe use tree-sitter query in the future
-        if node.is_missing() {
-            // TODO : maybe use query in the future
-            self.add_diagnostic(Diagnostic {
-                range,
-                severity: Some(DiagnosticSeverity::ERROR),
-                source: Some(SYNTAX.to_owned()),
-                message: format!("Missing {} here", node_kind),
-                ..Default::default()
-            });
-        }
+        let range = utils::parser_node_to_document_range(node, doc);
 
-        if node.is_error() {
-            let node_text = doc.get_ranged_text(node.start_byte()..node.end_byte());
-            self.add_diagnostic(Diagnostic {
-                range,
-                severity: Some(DiagnosticSeverity::ERROR),
-                source: Some(SYNTAX.to_owned()),
-                message: format!("ERROR: Unexpected '{}'.\n", node_text),
-                ..Default::default()
-            });
+        analyze_directive_balance(self, node, doc, ctx);
+
+        // the errors of the whole tree are collected at once to deduplicate them
+        if node.parent().is_none() && node.has_error() {
+            analyze_syntax_errors(self, node, doc);
+        }
+        if node.parent().is_none() {
+            analyze_loop_builtins(self, node, doc);
         }
 
         if let Ok(rule) = Rule::from_str(node_kind) {
@@ -163,6 +834,8 @@ impl DiagnosticAnalysis for Analysis {
                         });
                     }
                 }
+                Rule::FtlStmt => analyze_ftl_header(self, node, doc),
+                Rule::BuiltinName => analyze_unknown_builtin(self, node, doc),
                 Rule::AmbiguousStringLiteral => {
                     self.add_diagnostic(Diagnostic {
                         range,
@@ -181,27 +854,58 @@ impl DiagnosticAnalysis for Analysis {
                         ..Scenario::UNDOCUMENTED_CLOSE_TAG.into()
                     });
                 }
-                Rule::ListBegin | Rule::SwitchBegin => {
+                Rule::NumericInterpolation => {
+                    self.add_diagnostic(Diagnostic {
+                        range,
+                        tags: Some(vec![DiagnosticTag::DEPRECATED]),
+                        ..Scenario::DEPRECATED_NUMERIC_INTERPOLATION.into()
+                    });
+                }
+                Rule::ListBegin | Rule::SwitchBegin | Rule::MacroBegin | Rule::FunctionBegin => {
                     ctx.scope.push(rule);
                 }
-                Rule::ListClose | Rule::SwitchClose => {
+                Rule::ListClose | Rule::SwitchClose | Rule::MacroClose | Rule::FunctionClose => {
                     ctx.scope.pop();
                 }
+                // a macro or function body can not break out of the loop around its definition
                 Rule::BreakStmt => match ctx.scope.last() {
-                    Some(scope_rule) => {
-                        if *scope_rule == Rule::ListBegin {
-                            self.add_diagnostic(Diagnostic {
-                                range,
-                                ..Scenario::DEPRECATED_LIST_BREAK.into()
-                            })
-                        }
-                    }
-                    None => self.add_diagnostic(Diagnostic {
+                    Some(Rule::ListBegin) => self.add_diagnostic(Diagnostic {
+                        range,
+                        ..Scenario::DEPRECATED_LIST_BREAK.into()
+                    }),
+                    Some(Rule::SwitchBegin) => {}
+                    _ => self.add_diagnostic(Diagnostic {
                         range,
                         ..Scenario::UNEXPECTED_BREAK_STMT.into()
                     }),
                 },
-                Rule::MacroNamespace => {
+                Rule::ReturnStmt | Rule::NestedStmt => {
+                    let definition = ctx.scope.iter().rev().find(|scope_rule| {
+                        matches!(scope_rule, Rule::MacroBegin | Rule::FunctionBegin)
+                    });
+                    let has_value = node.child_by_field_name("value").is_some();
+                    let scenario = match (rule, definition) {
+                        (Rule::NestedStmt, Some(Rule::MacroBegin)) => None,
+                        (Rule::NestedStmt, _) => Some(Scenario::MISPLACED_NESTED),
+                        (_, Some(Rule::MacroBegin)) if has_value => {
+                            Some(Scenario::RETURN_VALUE_IN_MACRO)
+                        }
+                        (_, None) if has_value => Some(Scenario::MISPLACED_RETURN),
+                        _ => None,
+                    };
+                    if let Some(scenario) = scenario {
+                        self.add_diagnostic(Diagnostic {
+                            range,
+                            ..scenario.into()
+                        });
+                    }
+                }
+                // the name repeated by the closing tag is no call of its own
+                Rule::MacroNamespace
+                    if node
+                        .prev_sibling()
+                        .is_none_or(|prev| prev.kind() != Rule::MacroCallClose.to_string()) =>
+                {
                     let node_text = doc.get_ranged_text(node.start_byte()..node.end_byte());
                     let macro_call = Symbol {
                         rule,
@@ -218,16 +922,328 @@ impl DiagnosticAnalysis for Analysis {
             }
         }
     }
+
+    fn post_diagnostic_analysis(
+        &mut self,
+        root: &Node,
+        doc: &TextDocument,
+        ctx: &mut AnalysisContext,
+    ) {
+        // the output format is known once the header is analyzed
+        analyze_escaping(self, root, doc);
+        // directives reaching EOF without the closing tag
+        for unclosed in ctx.open_directives.drain(..) {
+            self.add_diagnostic(unclosed_directive_diagnostic(&unclosed));
+        }
+    }
+}
+
+impl Reactor {
+    /// Identifies the diagnostics of the current document version, the text is hashed as well
+    /// since the versions restart when a document is reopened. The generation of the symbol
+    /// index and the revision of the settings are included, as the imported and included
+    /// templates might resolve differently without any change of the document.
+    pub fn diagnostic_result_id(&self, index_generation: u64) -> String {
+        let mut hasher = DefaultHasher::new();
+        self.get_document().to_string().hash(&mut hasher);
+        format!(
+            "{}-{:016x}-{}-{}",
+            self.version,
+            hasher.finish(),
+            index_generation,
+            config::settings_revision()
+        )
+    }
+
+    /// Whether the diagnostic reports an undefined macro, which is defined by one of the
+    /// templates included by this one after all
+    fn is_included_macro(&self, diagnostic: &Diagnostic, included: &HashSet<String>) -> bool {
+        if diagnostic.code
+            != Some(NumberOrString::String(
+                Scenario::UNDEFINED_MACRO.code.into(),
+            ))
+        {
+            return false;
+        }
+        let doc = self.get_document();
+        match (
+            doc.position_to_byte(&diagnostic.range.start),
+            doc.position_to_byte(&diagnostic.range.end),
+        ) {
+            (Some(start), Some(end)) => included.contains(&doc.get_ranged_text(start..end)),
+            _ => false,
+        }
+    }
+
+    /// Reports the diagnostics of the document, `None` if they are unchanged since the pull of
+    /// the given result id
+    pub fn diagnostic_report(
+        &self,
+        previous_result_id: Option<&str>,
+        index_generation: u64,
+        reactors: &HashMap<Uri, Reactor>,
+    ) -> Option<FullDocumentDiagnosticReport> {
+        let result_id = self.diagnostic_result_id(index_generation);
+        if previous_result_id == Some(result_id.as_str()) {
+            return None;
+        }
+        let mut report = self
+            .get_analysis()
+            .get_analyzed_full_diagnostics()
+            .full_document_diagnostic_report;
+        let includes = self.get_analysis().get_includes();
+        if !includes.is_empty() {
+            // the macros of the included templates are only known at the workspace level
+            let mut included = HashSet::new();
+            goto::collect_included_callables(
+                reactors,
+                includes,
+                &mut HashSet::from([self.get_document().uri()]),
+                &mut included,
+            );
+            report
+                .items
+                .retain(|diagnostic| !self.is_included_macro(diagnostic, &included));
+        }
+        report.result_id = Some(result_id);
+        Some(report)
+    }
+}
+
+/// Reports the diagnostics of all opened documents, documents unchanged since the previous pull
+/// are reported as such
+pub fn workspace_diagnostic_report(
+    reactors: &HashMap<Uri, Reactor>,
+    params: &WorkspaceDiagnosticParams,
+    index_generation: u64,
+) -> WorkspaceDiagnosticReport {
+    let items = reactors
+        .iter()
+        .map(|(uri, reactor)| {
+            let previous_result_id = params
+                .previous_result_ids
+                .iter()
+                .find(|previous| previous.uri == *uri)
+                .map(|previous| previous.value.as_str());
+            let version = Some(reactor.version as i64);
+            match reactor.diagnostic_report(previous_result_id, index_generation, reactors) {
+                Some(full_document_diagnostic_report) => {
+                    WorkspaceDocumentDiagnosticReport::Full(WorkspaceFullDocumentDiagnosticReport {
+                        uri: uri.clone(),
+                        version,
+                        full_document_diagnostic_report,
+                    })
+                }
+                None => WorkspaceDocumentDiagnosticReport::Unchanged(
+                    WorkspaceUnchangedDocumentDiagnosticReport {
+                        uri: uri.clone(),
+                        version,
+                        unchanged_document_diagnostic_report: UnchangedDocumentDiagnosticReport {
+                            result_id: reactor.diagnostic_result_id(index_generation),
+                        },
+                    },
+                ),
+            }
+        })
+        .collect();
+    WorkspaceDiagnosticReport { items }
 }
 
 impl DiagnosticFeature for Reactor {
     async fn on_diagnostic(
         &self,
-        _: DocumentDiagnosticParams,
+        params: DocumentDiagnosticParams,
+        index_generation: u64,
+        reactors: &HashMap<Uri, Reactor>,
     ) -> jsonrpc::Result<DocumentDiagnosticReportResult> {
-        // TODO: Unchanged support
-        Ok(DocumentDiagnosticReportResult::Report(
-            DocumentDiagnosticReport::Full(self.get_analysis().get_analyzed_full_diagnostics()),
-        ))
+        let report = match self.diagnostic_report(
+            params.previous_result_id.as_deref(),
+            index_generation,
+            reactors,
+        ) {
+            Some(full_document_diagnostic_report) => {
+                DocumentDiagnosticReport::Full(RelatedFullDocumentDiagnosticReport {
+                    related_documents: None,
+                    full_document_diagnostic_report,
+                })
+            }
+            None => DocumentDiagnosticReport::Unchanged(RelatedUnchangedDocumentDiagnosticReport {
+                related_documents: None,
+                unchanged_document_diagnostic_report: UnchangedDocumentDiagnosticReport {
+                    result_id: self.diagnostic_result_id(index_generation),
+                },
+            }),
+        };
+        Ok(DocumentDiagnosticReportResult::Report(report))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::{collections::HashMap, env, str::FromStr};
+
+    use tower_lsp_server::ls_types::{Diagnostic, NumberOrString, Position, Range, Uri};
+
+    use crate::reactor::Reactor;
+
+    fn diagnostics(text: &str, code: &str) -> Vec<Diagnostic> {
+        let reactor = Reactor::new(&Uri::from_str("file:///test.ftl").unwrap(), text, 0);
+        reactor
+            .get_analysis()
+            .get_analyzed_full_diagnostics()
+            .full_document_diagnostic_report
+            .items
+            .into_iter()
+            .filter(|diagnostic| diagnostic.code == Some(NumberOrString::String(code.into())))
+            .collect()
+    }
+
+    #[test]
+    fn test_unknown_builtins() {
+        let unknown = diagnostics("${x?upperCase}", "unknown_builtin");
+        assert_eq!(unknown.len(), 1);
+        assert_eq!(
+            unknown[0].range,
+            Range::new(Position::new(0, 3), Position::new(0, 13))
+        );
+        assert_eq!(
+            unknown[0].message,
+            "Unknown built-in `?upperCase`. Did you mean `?upper_case`?"
+        );
+        let unknown = diagnostics("<#if s?foo('a')>${s?qqqq}</#if>", "unknown_builtin");
+        assert_eq!(unknown.len(), 2);
+        assert_eq!(unknown[1].message, "Unknown built-in `?qqqq`.");
+        // the missing value test operator and known built-ins are fine
+        assert!(
+            diagnostics(
+                "<#if x?? && y?size gt 0>${y?upper_case}</#if>",
+                "unknown_builtin"
+            )
+            .is_empty()
+        );
+        // the built-ins inside comments and strings are no references
+        assert!(diagnostics("<#-- ${x?foo} -->${'a?b'?length}", "unknown_builtin").is_empty());
+    }
+
+    #[test]
+    fn test_redundant_escaping() {
+        let redundant = diagnostics(
+            "<#ftl output_format=\"HTML\">${x?html}${y?upper_case}",
+            "redundant_escaping",
+        );
+        assert_eq!(redundant.len(), 1);
+        assert_eq!(
+            redundant[0].range,
+            Range::new(Position::new(0, 30), Position::new(0, 35))
+        );
+    }
+
+    #[test]
+    fn test_unclosed_directives() {
+        let unclosed = diagnostics(
+            "<#list items as i>\n  <#if i>${i}</#if>\n",
+            "unclosed_directive",
+        );
+        assert_eq!(unclosed.len(), 1);
+        assert_eq!(
+            unclosed[0].message,
+            "Unclosed directive `#list` opened at line 1, expected `</#list>`."
+        );
+        assert_eq!(unclosed[0].range.start, Position::new(0, 0));
+        // the directives opened after the matched one are never closed
+        let unclosed = diagnostics("<#list xs as x><#if x></#list>", "unclosed_directive");
+        assert_eq!(unclosed.len(), 1);
+        assert!(unclosed[0].message.starts_with("Unclosed directive `#if`"));
+        assert!(
+            diagnostics("<#list xs as x><#if x></#if></#list>", "unclosed_directive").is_empty()
+        );
+        // inline assignments have no closing tag
+        assert!(diagnostics("<#assign x = 1>", "unclosed_directive").is_empty());
+    }
+
+    #[test]
+    fn test_stray_closing_tag() {
+        let stray = diagnostics("${x}\n</#if>", "unexpected_close_tag");
+        assert_eq!(stray.len(), 1);
+        assert_eq!(
+            stray[0].range,
+            Range::new(Position::new(1, 0), Position::new(1, 6))
+        );
+        assert_eq!(
+            stray[0].message,
+            "Unexpected closing tag `</#if>`, no `#if` directive is open."
+        );
+    }
+
+    fn syntax_diagnostics(text: &str) -> Vec<Diagnostic> {
+        let mut syntax = diagnostics(text, "syntax_error");
+        syntax.extend(diagnostics(text, "missing_token"));
+        syntax
+    }
+
+    #[test]
+    fn test_syntax_errors_are_deduplicated() {
+        // one typo breaks several nodes around it, but is reported once
+        assert_eq!(syntax_diagnostics("<#if x == >${x}</#if>").len(), 1);
+        let missing = syntax_diagnostics("${x");
+        assert_eq!(missing.len(), 1);
+        assert_eq!(missing[0].message, "Expected `}`.");
+        assert_eq!(
+            missing[0].range,
+            Range::new(Position::new(0, 3), Position::new(0, 3))
+        );
+        assert!(syntax_diagnostics("<#if x == 1>${x}</#if>").is_empty());
+    }
+
+    #[test]
+    fn test_macros_of_included_templates_are_defined() {
+        let dir = env::temp_dir().join(format!("freemarker-include-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let dir = dir.canonicalize().unwrap();
+        // the macro is only defined by the changes of the opened library not saved yet
+        std::fs::write(dir.join("lib.ftl"), "<#macro other></#macro>").unwrap();
+        std::fs::write(dir.join("page.ftl"), "").unwrap();
+        let lib_uri = Uri::from_file_path(dir.join("lib.ftl")).unwrap();
+        let page_uri = Uri::from_file_path(dir.join("page.ftl")).unwrap();
+        let page = Reactor::new(&page_uri, "<#include \"lib.ftl\"><@row/>", 0);
+        let undefined_macros = |reactors: &HashMap<Uri, Reactor>| {
+            page.diagnostic_report(None, 0, reactors)
+                .unwrap()
+                .items
+                .into_iter()
+                .filter(|diagnostic| {
+                    diagnostic.code == Some(NumberOrString::String("undefined_macro".into()))
+                })
+                .count()
+        };
+        let saved = undefined_macros(&HashMap::new());
+        let opened = undefined_macros(&HashMap::from([(
+            lib_uri.clone(),
+            Reactor::new(&lib_uri, "<#macro row></#macro>", 1),
+        )]));
+        std::fs::remove_dir_all(&dir).unwrap();
+        assert_eq!(saved, 1);
+        assert_eq!(opened, 0);
+    }
+
+    #[test]
+    fn test_unescaped_interpolations() {
+        let unescaped = |text| diagnostics(text, "unescaped_interpolation").len();
+        assert_eq!(unescaped("<#ftl output_format=\"plainText\">${user}"), 1);
+        assert_eq!(unescaped("${user}"), 1);
+        assert_eq!(
+            unescaped("<#ftl output_format=\"HTML\"><#noautoesc>${user}</#noautoesc>${user}"),
+            1
+        );
+        assert_eq!(
+            unescaped(
+                "<#ftl output_format=\"HTML\"><#outputformat \"plainText\">${user}</#outputformat>"
+            ),
+            1
+        );
+        // auto-escaped, not escaped on purpose, or no user data
+        assert_eq!(unescaped("<#ftl output_format=\"HTML\">${user}"), 0);
+        assert_eq!(unescaped("<#ftl output_format=\"JSON\">${user}"), 0);
+        assert_eq!(unescaped("${user?html}${\"literal\"}"), 0);
     }
 }
@@ -11,6 +11,8 @@ use thiserror::Error;
 use tower_lsp_server::ls_types::{Position, TextDocumentContentChangeEvent, Uri};
 use tree_sitter::{InputEdit, Point};
 
+use crate::config;
+
 #[derive(Debug)]
 pub struct TextDocument {
     uri: Uri,
@@ -30,12 +32,22 @@ pub enum DocumentError {
 /// type that is unconvenient to deal with.
 pub enum PositionEncodingKind {
     UTF8,
-    #[allow(dead_code)]
     UTF16,
-    #[allow(dead_code)]
     UTF32,
 }
 
+impl From<&tower_lsp_server::ls_types::PositionEncodingKind> for PositionEncodingKind {
+    fn from(kind: &tower_lsp_server::ls_types::PositionEncodingKind) -> Self {
+        use tower_lsp_server::ls_types::PositionEncodingKind as LspPositionEncodingKind;
+        match kind {
+            _ if *kind == LspPositionEncodingKind::UTF8 => PositionEncodingKind::UTF8,
+            _ if *kind == LspPositionEncodingKind::UTF32 => PositionEncodingKind::UTF32,
+            // UTF-16 is the default of LSP
+            _ => PositionEncodingKind::UTF16,
+        }
+    }
+}
+
 impl std::fmt::Display for TextDocument {
     #[inline]
     fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
@@ -68,6 +80,10 @@ impl TextDocument {
         parent.to_path_buf()
     }
 
+    pub fn len_bytes(&self) -> usize {
+        self.rope.len_bytes()
+    }
+
     pub fn line_count(&self) -> usize {
         self.rope.len_lines()
     }
@@ -92,13 +108,45 @@ impl TextDocument {
         line.to_string()
     }
 
-    pub fn get_prev_char_at(&self, position: &Position) -> Option<char> {
-        if let Some(line) = self.rope.get_line(position.line as usize)
-            && position.character > 0
-        {
-            return line.get_char(position.character as usize - 1);
+    /// Returns the byte offset of the position
+    pub fn position_to_byte(&self, position: &Position) -> Option<usize> {
+        let line_start = self.rope.try_line_to_byte(position.line as usize).ok()?;
+        let offset = line_start
+            + self.character_to_byte_column(position.line as usize, position.character)?;
+        (offset <= self.rope.len_bytes()).then_some(offset)
+    }
+
+    /// Returns the byte offset in the line of the character, which is counted in the negotiated
+    /// position encoding, e.g. in UTF-16 code units
+    pub fn character_to_byte_column(&self, line: usize, character: u32) -> Option<usize> {
+        let encoding = config::position_encoding();
+        if let PositionEncodingKind::UTF8 = encoding {
+            return Some(character as usize);
+        }
+        let slice = self.rope.get_line(line)?;
+        let char_idx = match encoding {
+            PositionEncodingKind::UTF16 => slice.try_utf16_cu_to_char(character as usize).ok()?,
+            _ => character as usize,
+        };
+        slice.try_char_to_byte(char_idx).ok()
+    }
+
+    /// Returns the character of the byte offset in the line, counted in the negotiated position
+    /// encoding, e.g. the column of a tree-sitter point
+    pub fn byte_column_to_character(&self, line: usize, column: usize) -> u32 {
+        let encoding = config::position_encoding();
+        let Some(slice) = self
+            .rope
+            .get_line(line)
+            .filter(|_| !matches!(encoding, PositionEncodingKind::UTF8))
+        else {
+            return column as u32;
+        };
+        let char_idx = slice.byte_to_char(column.min(slice.len_bytes()));
+        match encoding {
+            PositionEncodingKind::UTF16 => slice.char_to_utf16_cu(char_idx) as u32,
+            _ => char_idx as u32,
         }
-        None
     }
 
     pub fn line_len(&self, id: usize) -> Result<usize, DocumentError> {
@@ -204,19 +252,17 @@ impl TextDocument {
                 // change starts/ends. Required for tree-sitter.
                 let change_start_line_byte_idx = match position_encoding {
                     PositionEncodingKind::UTF8 => change_start_line_cu_idx,
-                    PositionEncodingKind::UTF16 => {
-                        change_start_line.char_to_utf16_cu(change_start_line_char_idx)
+                    PositionEncodingKind::UTF16 | PositionEncodingKind::UTF32 => {
+                        change_start_line.char_to_byte(change_start_line_char_idx)
                     }
-                    PositionEncodingKind::UTF32 => change_start_line_char_idx,
                 };
                 let change_end_line_byte_idx = match same_line && same_character {
                     true => change_start_line_byte_idx,
                     false => match position_encoding {
                         PositionEncodingKind::UTF8 => change_end_line_cu_idx,
-                        PositionEncodingKind::UTF16 => {
-                            change_end_line.char_to_utf16_cu(change_end_line_char_idx)
+                        PositionEncodingKind::UTF16 | PositionEncodingKind::UTF32 => {
+                            change_end_line.char_to_byte(change_end_line_char_idx)
                         }
-                        PositionEncodingKind::UTF32 => change_end_line_char_idx,
                     },
                 };
 
@@ -226,17 +272,17 @@ impl TextDocument {
 
                 // 6. Compute the byte index into the new end line where the
                 // change ends. Required for tree-sitter.
-                let change_new_end_line_idx = self
-                    .rope
-                    .byte_to_line(change_start_doc_byte_idx + change.text.len());
-                let change_new_end_line_byte_idx = change_start_doc_byte_idx + change.text.len();
+                let change_new_end_doc_byte_idx = change_start_doc_byte_idx + change.text.len();
+                let change_new_end_line_idx = self.rope.byte_to_line(change_new_end_doc_byte_idx);
+                let change_new_end_line_byte_idx =
+                    change_new_end_doc_byte_idx - self.rope.line_to_byte(change_new_end_line_idx);
 
                 // 7. Construct the tree-sitter edit. We stay mindful that
                 // tree-sitter Point::column is a byte offset.
                 let edit = InputEdit {
                     start_byte: change_start_doc_byte_idx,
                     old_end_byte: change_end_doc_byte_idx,
-                    new_end_byte: change_start_doc_byte_idx + change.text.len(),
+                    new_end_byte: change_new_end_doc_byte_idx,
                     start_position: Point {
                         row: change_start_line_idx,
                         column: change_start_line_byte_idx,
@@ -264,3 +310,67 @@ impl TextDocument {
         Ok(None)
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{Position, Range, TextDocumentContentChangeEvent, Uri};
+    use tree_sitter::Point;
+
+    use crate::doc::{PositionEncodingKind, TextDocument};
+
+    // "ü" takes 2 bytes and 1 UTF-16 code unit, "😀" takes 4 bytes and 2 UTF-16 code units
+    const TEXT: &str = "<#-- ü😀 -->${x}\n";
+
+    fn new_document() -> TextDocument {
+        TextDocument::new(&Uri::from_str("file:///test.ftl").unwrap(), TEXT)
+    }
+
+    fn replace(line: u32, start: u32, end: u32, text: &str) -> TextDocumentContentChangeEvent {
+        TextDocumentContentChangeEvent {
+            range: Some(Range::new(
+                Position::new(line, start),
+                Position::new(line, end),
+            )),
+            range_length: None,
+            text: text.to_owned(),
+        }
+    }
+
+    #[test]
+    fn test_change_after_multi_byte_characters() {
+        let mut doc = new_document();
+        let edit = doc
+            .apply_content_change(&replace(0, 14, 15, "y"), PositionEncodingKind::UTF16)
+            .unwrap()
+            .unwrap();
+        assert_eq!(doc.to_string(), "<#-- ü😀 -->${y}\n");
+        assert_eq!(
+            (edit.start_byte, edit.old_end_byte, edit.new_end_byte),
+            (17, 18, 18)
+        );
+        assert_eq!(edit.start_position, Point { row: 0, column: 17 });
+        assert_eq!(edit.new_end_position, Point { row: 0, column: 18 });
+
+        // the same change in the other encodings
+        let mut doc = new_document();
+        doc.apply_content_change(&replace(0, 17, 18, "y"), PositionEncodingKind::UTF8)
+            .unwrap();
+        assert_eq!(doc.to_string(), "<#-- ü😀 -->${y}\n");
+        let mut doc = new_document();
+        doc.apply_content_change(&replace(0, 13, 14, "y"), PositionEncodingKind::UTF32)
+            .unwrap();
+        assert_eq!(doc.to_string(), "<#-- ü😀 -->${y}\n");
+    }
+
+    #[test]
+    fn test_utf16_columns() {
+        // UTF-16 is the default until the client agrees on another encoding
+        let doc = new_document();
+        assert_eq!(doc.character_to_byte_column(0, 14), Some(17));
+        assert_eq!(doc.byte_column_to_character(0, 17), 14);
+        assert_eq!(doc.position_to_byte(&Position::new(0, 14)), Some(17));
+        assert_eq!(doc.byte_column_to_character(0, 7), 6);
+    }
+}
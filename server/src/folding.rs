@@ -4,7 +4,7 @@
 
 use std::str::FromStr;
 
-use tower_lsp_server::ls_types::{FoldingRange, FoldingRangeProviderCapability};
+use tower_lsp_server::ls_types::{FoldingRange, FoldingRangeKind, FoldingRangeProviderCapability};
 use tree_sitter::Node;
 use tree_sitter_freemarker::grammar::Rule;
 
@@ -18,37 +18,87 @@ pub fn folding_capability() -> FoldingRangeProviderCapability {
     FoldingRangeProviderCapability::Simple(true)
 }
 
+fn opening_tag_end_row(stmt: &Node) -> Option<usize> {
+    // the opening tag ends with the close tag of the first clause
+    let mut cursor = stmt.walk();
+    let clause = stmt
+        .named_children(&mut cursor)
+        .find(|child| child.kind().ends_with("_clause"))?;
+    let mut clause_cursor = clause.walk();
+    let close_tag = clause.children(&mut clause_cursor).find(|child| {
+        matches!(
+            Rule::from_str(child.kind()),
+            Ok(Rule::CloseTag | Rule::MacroCloseTag)
+        )
+    })?;
+    Some(close_tag.end_position().row)
+}
+
+fn closing_tag_start_row(stmt: &Node) -> Option<usize> {
+    let closer = stmt.named_child(stmt.named_child_count().checked_sub(1)?)?;
+    match closer.kind().ends_with("_close") && !closer.is_missing() {
+        true => Some(closer.start_position().row),
+        false => None,
+    }
+}
+
+/// Returns the rows of a branch of `<#switch>`, from its opening tag to the line before the next
+/// branch or the closing tag
+fn switch_branch_rows(clause: &Node) -> Option<(usize, usize)> {
+    let begin = clause.prev_sibling()?;
+    let next_tag = clause
+        .next_sibling()
+        .or_else(|| clause.parent()?.next_sibling())
+        .filter(|tag| !tag.is_missing())?;
+    Some((
+        begin.start_position().row,
+        next_tag.start_position().row.checked_sub(1)?,
+    ))
+}
+
 impl FoldingAnalysis for Analysis {
     fn analyze_folding_ranges(&mut self, node: &Node, ctx: &mut AnalysisContext) {
         if node.is_error() || node.is_missing() {
             // not sure if it is proper
             return;
         }
-        if let Ok(
-            Rule::Comment
-            | Rule::AssignClause
-            | Rule::CaseClause
-            | Rule::DefaultClause
-            | Rule::ElseClause
-            | Rule::FunctionClause
-            | Rule::IfClause
-            | Rule::ListClause
-            | Rule::LocalClause
-            | Rule::MacroClause
-            | Rule::OnClause
-            | Rule::SwitchClause,
-        ) = Rule::from_str(node.kind())
-        {
-            // node kind with "_clause" requires indent increasing
-            let id = node.id();
-            if !ctx.ranges_set.contains(&id) {
-                ctx.ranges_set.insert(id);
-                self.add_folding_range(FoldingRange {
-                    start_line: node.start_position().row as u32,
-                    end_line: node.end_position().row as u32 - 1,
-                    ..Default::default()
-                });
+        let folding = match Rule::from_str(node.kind()) {
+            Ok(Rule::Comment) => Some((
+                node.start_position().row,
+                node.end_position().row,
+                FoldingRangeKind::Comment,
+            )),
+            Ok(
+                Rule::AssignStmt
+                | Rule::AutoescStmt
+                | Rule::FunctionStmt
+                | Rule::GlobalStmt
+                | Rule::IfStmt
+                | Rule::ListStmt
+                | Rule::LocalStmt
+                | Rule::MacroStmt
+                | Rule::NoautoescStmt
+                | Rule::OutputformatStmt
+                | Rule::SwitchStmt,
+            ) => opening_tag_end_row(node)
+                .zip(closing_tag_start_row(node))
+                .map(|(start, end)| (start, end, FoldingRangeKind::Region)),
+            Ok(Rule::CaseClause | Rule::DefaultClause | Rule::OnClause) => {
+                switch_branch_rows(node).map(|(start, end)| (start, end, FoldingRangeKind::Region))
             }
+            _ => None,
+        };
+        // single line constructs are not foldable
+        if let Some((start_line, end_line, kind)) = folding
+            && start_line < end_line
+            && ctx.ranges_set.insert(node.id())
+        {
+            self.add_folding_range(FoldingRange {
+                start_line: start_line as u32,
+                end_line: end_line as u32,
+                kind: Some(kind),
+                ..Default::default()
+            });
         }
     }
 }
@@ -61,3 +111,34 @@ impl FoldingFeature for Reactor {
         Ok(Some(self.get_analysis().get_analyzed_folding_ranges()))
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{FoldingRangeKind, Uri};
+
+    use crate::reactor::Reactor;
+
+    #[test]
+    fn test_fold_blocks_and_comments() {
+        let text = "<#--\n  note\n-->\n<#if x>\n  <#list xs as x>\n    ${x}\n  </#list>\n</#if>\n<#if y>y</#if>\n";
+        let reactor = Reactor::new(&Uri::from_str("file:///test.ftl").unwrap(), text, 0);
+        let mut ranges: Vec<(u32, u32, Option<FoldingRangeKind>)> = reactor
+            .get_analysis()
+            .get_analyzed_folding_ranges()
+            .into_iter()
+            .map(|range| (range.start_line, range.end_line, range.kind))
+            .collect();
+        ranges.sort_by_key(|(start_line, _, _)| *start_line);
+        // the single line block is not foldable
+        assert_eq!(
+            ranges,
+            vec![
+                (0, 2, Some(FoldingRangeKind::Comment)),
+                (3, 7, Some(FoldingRangeKind::Region)),
+                (4, 6, Some(FoldingRangeKind::Region)),
+            ]
+        );
+    }
+}
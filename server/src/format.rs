@@ -2,87 +2,219 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
+use std::{ops, str::FromStr};
+
 use tower_lsp_server::{
     jsonrpc::Result as JsonRpcResult,
     ls_types::{
-        DocumentFormattingOptions, DocumentFormattingParams, OneOf, Position, Range, TextEdit,
+        DocumentFormattingOptions, DocumentFormattingParams, DocumentOnTypeFormattingOptions,
+        DocumentOnTypeFormattingParams, DocumentRangeFormattingOptions,
+        DocumentRangeFormattingParams, FormattingOptions, NumberOrString, OneOf, Position, Range,
+        TextEdit,
     },
 };
-use tree_sitter::Point;
+use tree_sitter::{Node, Point};
+use tree_sitter_freemarker::grammar::Rule;
 
-use crate::{reactor::Reactor, server::FormatFeature, window_log_info};
+use crate::{reactor::Reactor, server::FormatFeature, utils, window_log_info};
 
-#[derive(Clone, Copy)]
-struct FormatState {
-    preset: Option<usize>,
-    indent: usize,
-    has_directive: bool,
+fn indent_unit(options: &FormattingOptions) -> String {
+    match options.insert_spaces {
+        true => " ".repeat(options.tab_size as usize),
+        false => "\t".to_owned(),
+    }
 }
 
-fn reset_state(mut state: FormatState) -> FormatState {
-    // MUST NOT reset the preset
-    state.indent = 0;
-    state.has_directive = false;
-    state
+fn is_line_head(node: &Node, point: Point) -> bool {
+    match Rule::from_str(node.kind()) {
+        // text keeps going across lines
+        Ok(Rule::Text) => true,
+        // a tag or comment starting at this line, not the rest of a multi-line one
+        Ok(Rule::BreakStmt | Rule::Comment | Rule::InterpolationPrepend | Rule::MacroCallBegin) => {
+            node.start_position() == point
+        }
+        _ => {
+            node.start_position() == point
+                && (node.kind().ends_with("_begin") || node.kind().ends_with("_close"))
+        }
+    }
 }
 
-fn update_state(
+/// Returns the expected indentation of the line, `None` if the line should be left untouched
+fn expected_indentation(
     reactor: &Reactor,
-    index: usize,
-    line: &str,
-    mut state: FormatState,
-) -> FormatState {
-    let trimed_line = line.trim_start();
-    if trimed_line.starts_with("</#") || trimed_line.starts_with("<#") {
-        let col = line.len() - trimed_line.len();
-        let node = reactor
-            .get_parser()
-            .get_node_at_point(Point {
-                row: index,
-                column: col,
-            })
-            .unwrap();
-        if node.kind() == "comment" {
-            // under comment section
-            state.has_directive = false;
-        } else {
-            state.has_directive = true;
-            // compute indent
-            let mut node_cursor = node;
-            while let Some(parent) = node_cursor.parent() {
-                let kind = node_cursor.kind();
-                if kind.ends_with("_clause") {
-                    // node kind with "_clause" requires indent increasing
-                    state.indent += 1;
-                }
-                node_cursor = parent;
+    lines: &[String],
+    row: usize,
+    unit: &str,
+) -> Option<String> {
+    let point = Point {
+        row,
+        column: utils::leading_whitespace(&lines[row]).len(),
+    };
+    let node = reactor.get_parser().get_node_at_point(point)?;
+    if !is_line_head(&node, point) {
+        return None;
+    }
+    let mut depth = 0;
+    let mut outermost_stmt = None;
+    let mut node_cursor = Some(node);
+    while let Some(current) = node_cursor {
+        if current.is_error() {
+            // no guess on broken blocks
+            return None;
+        }
+        if current.kind().ends_with("_clause") {
+            // node kind with "_clause" requires indent increasing
+            depth += 1;
+        } else if current.kind().ends_with("_stmt") {
+            outermost_stmt = Some(current);
+        }
+        node_cursor = current.parent();
+    }
+    // top level lines keep their indentation, nested ones follow the top level directive
+    let base = utils::leading_whitespace(&lines[outermost_stmt?.start_position().row]);
+    Some(base.to_owned() + &unit.repeat(depth))
+}
+
+// directives which are closed automatically, the capture forms are left out since they are
+// indistinguishable from the inline forms when the opening tag is typed
+const AUTO_CLOSED_DIRECTIVES: [&str; 8] = [
+    "autoesc",
+    "function",
+    "if",
+    "list",
+    "macro",
+    "noautoesc",
+    "outputformat",
+    "switch",
+];
+
+pub fn on_type_formatting_capability() -> DocumentOnTypeFormattingOptions {
+    DocumentOnTypeFormattingOptions {
+        first_trigger_character: ">".to_owned(),
+        more_trigger_character: None,
+    }
+}
+
+impl Reactor {
+    /// Inserts the closing tag after the opening tag just typed, with an indented line between
+    fn auto_close_directive(&self, position: &Position, unit: &str) -> Option<TextEdit> {
+        let line = self.get_document().get_line_text(position.line as usize);
+        let column = self
+            .get_document()
+            .character_to_byte_column(position.line as usize, position.character)?;
+        let (head, rest) = line.split_at_checked(column)?;
+        if !rest.trim().is_empty() {
+            // only at the end of the line
+            return None;
+        }
+        let opener_start = head.rfind("<#")?;
+        let name = utils::directive_name(
+            head[opener_start..]
+                .split(|c: char| c.is_whitespace())
+                .next()?,
+        );
+        if !AUTO_CLOSED_DIRECTIVES.contains(&name) {
+            return None;
+        }
+        // the opening tag is reported as unclosed if there is no matching closer already
+        let opener = Position::new(
+            position.line,
+            self.get_document()
+                .byte_column_to_character(position.line as usize, opener_start),
+        );
+        let is_unclosed = self
+            .get_analysis()
+            .get_analyzed_full_diagnostics()
+            .full_document_diagnostic_report
+            .items
+            .iter()
+            .any(|diagnostic| {
+                diagnostic.code == Some(NumberOrString::String("unclosed_directive".to_owned()))
+                    && diagnostic.range.start == opener
+                    && diagnostic.range.end == *position
+            });
+        if !is_unclosed {
+            return None;
+        }
+        let indentation = utils::leading_whitespace(&line);
+        Some(TextEdit::new(
+            Range::new(*position, *position),
+            format!("\n{}{}\n{}</#{}>", indentation, unit, indentation, name),
+        ))
+    }
+
+    /// Re-indents the lines of the given rows, the enclosing blocks are taken into account even
+    /// if they begin before the rows
+    fn reindent_lines(&self, rows: ops::Range<usize>, unit: &str) -> Vec<TextEdit> {
+        let mut lines = vec![];
+        self.get_document()
+            .enumerate_lines(|_, line| lines.push(line.to_owned()));
+        let mut edits = vec![];
+        for row in rows.start..rows.end.min(lines.len()) {
+            let line = &lines[row];
+            if line.trim().is_empty() {
+                // blank lines are left as they are
+                continue;
             }
-            // save the preset indentation for the top level directives
-            if state.indent == 0
-                && let Some(parent) = node.parent()
+            let current = utils::leading_whitespace(line);
+            if let Some(expected) = expected_indentation(self, &lines, row, unit)
+                && expected != current
             {
-                let _parentkind = parent.kind();
-                let end_row = parent.end_position().row;
-                let start_row = parent.start_position().row;
-                if end_row > start_row {
-                    if state.preset.is_none() {
-                        state.preset = Some(col);
-                    }
-                } else if col > 0 {
-                    state.preset = Some(col);
-                } else {
-                    state.preset = None;
-                }
+                edits.push(TextEdit::new(
+                    Range {
+                        start: Position {
+                            line: row as u32,
+                            character: 0,
+                        },
+                        end: Position {
+                            line: row as u32,
+                            character: current.len() as u32,
+                        },
+                    },
+                    expected,
+                ));
             }
         }
+        edits
+    }
+
+    /// Re-indents the line of the closing tag just typed to the indentation of its opener
+    fn dedent_closing_tag(&self, position: &Position, unit: &str) -> Option<TextEdit> {
+        let row = position.line as usize;
+        let line = self.get_document().get_line_text(row);
+        let column = self
+            .get_document()
+            .character_to_byte_column(row, position.character)?;
+        let head = line.get(..column)?;
+        let current = utils::leading_whitespace(head);
+        if !head[current.len()..].starts_with("</#") {
+            return None;
+        }
+        let mut lines = vec![];
+        self.get_document()
+            .enumerate_lines(|_, line| lines.push(line.to_owned()));
+        let expected = expected_indentation(self, &lines, row, unit)?;
+        (expected != current).then(|| {
+            TextEdit::new(
+                Range::new(
+                    Position::new(position.line, 0),
+                    Position::new(position.line, current.len() as u32),
+                ),
+                expected,
+            )
+        })
     }
-    state
 }
 
 pub fn formatting_capability() -> OneOf<bool, DocumentFormattingOptions> {
     OneOf::Left(true)
 }
 
+pub fn range_formatting_capability() -> OneOf<bool, DocumentRangeFormattingOptions> {
+    OneOf::Left(true)
+}
+
 impl FormatFeature for Reactor {
     async fn on_formatting(
         &self,
@@ -90,40 +222,165 @@ impl FormatFeature for Reactor {
     ) -> JsonRpcResult<Option<Vec<TextEdit>>> {
         let uri = params.text_document.uri;
         window_log_info!(format!("on_formatting: {}", uri.to_string()));
-        let mut state = FormatState {
-            preset: None,
-            indent: 0,
-            has_directive: false,
-        };
-        let mut formatted = String::from("");
-        let mut last_length = 0;
-        let line_count = self.get_document().line_count();
-        self.get_document().enumerate_lines(|index, line| {
-            last_length = line.len();
-            state = update_state(self, index, line, state);
-            let preset = state.preset.unwrap_or_default();
-            if state.has_directive {
-                // todo: make indent step become a configuration
-                // currently use 4 whitespaces as the indent step by default
-                formatted += &(" ".repeat(preset + state.indent * 4) + line.trim());
-            } else {
-                formatted += line;
-            }
-            if index < line_count - 1 {
-                formatted += "\n";
-            }
-            state = reset_state(state);
-        });
-        let range = Range {
-            start: Position {
-                line: 0,
-                character: 0,
-            },
-            end: Position {
-                line: line_count as u32,
-                character: last_length as u32,
-            },
+        let unit = indent_unit(&params.options);
+        let rows = 0..self.get_document().line_count();
+        Ok(Some(self.reindent_lines(rows, &unit)))
+    }
+
+    async fn on_range_formatting(
+        &self,
+        params: DocumentRangeFormattingParams,
+    ) -> JsonRpcResult<Option<Vec<TextEdit>>> {
+        let unit = indent_unit(&params.options);
+        let Range { start, end } = params.range;
+        // a selection of whole lines ends at the beginning of the line after them
+        let end_row = match end.character == 0 && end.line > start.line {
+            true => end.line,
+            false => end.line + 1,
         };
-        Ok(Some(vec![TextEdit::new(range, formatted)]))
+        let rows = start.line as usize..end_row as usize;
+        Ok(Some(self.reindent_lines(rows, &unit)))
+    }
+
+    async fn on_type_formatting(
+        &self,
+        params: DocumentOnTypeFormattingParams,
+    ) -> JsonRpcResult<Option<Vec<TextEdit>>> {
+        if params.ch != ">" {
+            return Ok(None);
+        }
+        let position = params.text_document_position.position;
+        let unit = indent_unit(&params.options);
+        Ok(self
+            .auto_close_directive(&position, &unit)
+            .or_else(|| self.dedent_closing_tag(&position, &unit))
+            .map(|edit| vec![edit]))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        DocumentFormattingParams, DocumentOnTypeFormattingParams, DocumentRangeFormattingParams,
+        FormattingOptions, Position, Range, TextDocumentIdentifier, TextDocumentPositionParams,
+        TextEdit, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::FormatFeature};
+
+    // the closing tag of "if" is indented already
+    const TEXT: &str = "<#list xs as x>\n<#if x>\n\n${x}\n  </#if>\n</#list>\n";
+
+    fn new_reactor() -> Reactor {
+        Reactor::new(&Uri::from_str("file:///test.ftl").unwrap(), TEXT, 0)
+    }
+
+    fn options() -> FormattingOptions {
+        FormattingOptions {
+            tab_size: 2,
+            insert_spaces: true,
+            ..Default::default()
+        }
+    }
+
+    fn indent(line: u32, indentation: &str) -> TextEdit {
+        TextEdit::new(
+            Range::new(Position::new(line, 0), Position::new(line, 0)),
+            indentation.to_owned(),
+        )
+    }
+
+    #[tokio::test]
+    async fn test_formatting_edits_only_misindented_lines() {
+        let edits = new_reactor()
+            .on_formatting(DocumentFormattingParams {
+                text_document: TextDocumentIdentifier::new(
+                    Uri::from_str("file:///test.ftl").unwrap(),
+                ),
+                options: options(),
+                work_done_progress_params: Default::default(),
+            })
+            .await;
+        // the blank line and the lines indented as expected are left out
+        assert_eq!(edits, Ok(Some(vec![indent(1, "  "), indent(3, "    ")])));
+    }
+
+    async fn typed_edits(text: &str, line: u32, character: u32) -> Option<Vec<TextEdit>> {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        Reactor::new(&uri, text, 0)
+            .on_type_formatting(DocumentOnTypeFormattingParams {
+                text_document_position: TextDocumentPositionParams::new(
+                    TextDocumentIdentifier::new(uri.clone()),
+                    Position::new(line, character),
+                ),
+                ch: ">".to_owned(),
+                options: options(),
+            })
+            .await
+            .ok()?
+    }
+
+    #[tokio::test]
+    async fn test_auto_close_directive() {
+        let position = Position::new(0, 15);
+        assert_eq!(
+            typed_edits("<#list xs as x>\n", 0, 15).await,
+            Some(vec![TextEdit::new(
+                Range::new(position, position),
+                "\n  \n</#list>".to_owned()
+            )])
+        );
+        // the closing tag follows already
+        assert_eq!(
+            typed_edits("<#list xs as x>\n</#list>\n", 0, 15).await,
+            None
+        );
+        // the capture form is indistinguishable from an inline assignment at this point
+        assert_eq!(typed_edits("<#assign x>\n", 0, 11).await, None);
+    }
+
+    #[tokio::test]
+    async fn test_dedent_closing_tag() {
+        assert_eq!(
+            typed_edits("<#if x>\n  ${x}\n  </#if>", 2, 8).await,
+            Some(vec![TextEdit::new(
+                Range::new(Position::new(2, 0), Position::new(2, 2)),
+                String::new()
+            )])
+        );
+    }
+
+    async fn range_edits(start: Position, end: Position) -> Option<Vec<TextEdit>> {
+        new_reactor()
+            .on_range_formatting(DocumentRangeFormattingParams {
+                text_document: TextDocumentIdentifier::new(
+                    Uri::from_str("file:///test.ftl").unwrap(),
+                ),
+                range: Range::new(start, end),
+                options: options(),
+                work_done_progress_params: Default::default(),
+            })
+            .await
+            .ok()?
+    }
+
+    #[tokio::test]
+    async fn test_range_formatting_keeps_to_the_selected_lines() {
+        // whole lines, the enclosing blocks begin before the selection
+        assert_eq!(
+            range_edits(Position::new(2, 0), Position::new(4, 0)).await,
+            Some(vec![indent(3, "    ")])
+        );
+        // a selection in the middle of a line covers that line only
+        assert_eq!(
+            range_edits(Position::new(1, 2), Position::new(1, 5)).await,
+            Some(vec![indent(1, "  ")])
+        );
+        assert_eq!(
+            range_edits(Position::new(4, 0), Position::new(5, 8)).await,
+            Some(vec![])
+        );
     }
 }
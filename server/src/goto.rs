@@ -2,34 +2,183 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
-use std::str::FromStr;
+use std::{
+    collections::{HashMap, HashSet},
+    str::FromStr,
+};
 
 use tower_lsp_server::{
     jsonrpc::Result as JsonRpcResult,
     ls_types::{
         DefinitionOptions, GotoDefinitionParams, GotoDefinitionResponse, Location, OneOf, Range,
+        Uri,
     },
 };
+use tree_sitter::{Node, Point};
 use tree_sitter_freemarker::grammar::Rule;
 
-use crate::{reactor::Reactor, server::GotoFeature, utils};
+use crate::{reactor::Reactor, reference, server::GotoFeature, utils};
 
 pub fn definition_capability() -> OneOf<bool, DefinitionOptions> {
     OneOf::Left(true)
 }
 
+fn find_callable_definition(reactor: &Reactor, name: &str) -> Option<Location> {
+    let symbols = reactor.get_analysis().find_symbol_definition(name).ok()?;
+    symbols
+        .iter()
+        .find(|symbol| matches!(symbol.rule, Rule::MacroName | Rule::FunctionName))
+        .map(|symbol| Location {
+            uri: reactor.get_document().uri(),
+            range: symbol.range,
+        })
+}
+
+/// Runs `func` on the opened template, so that the changes not saved yet are seen, or on the
+/// template loaded from the file, `None` for missing or unreadable files
+fn with_template<T>(
+    reactors: &HashMap<Uri, Reactor>,
+    uri: &Uri,
+    func: impl FnOnce(&Reactor) -> Option<T>,
+) -> Option<T> {
+    match reactors.get(uri) {
+        Some(reactor) => func(reactor),
+        None => func(&Reactor::from_file(uri)?),
+    }
+}
+
+fn find_included_definition(
+    reactors: &HashMap<Uri, Reactor>,
+    includes: &[Uri],
+    name: &str,
+    visited: &mut HashSet<Uri>,
+) -> Option<Location> {
+    // included templates share the namespace of the including one
+    includes.iter().find_map(|uri| {
+        if !visited.insert(uri.clone()) {
+            return None;
+        }
+        with_template(reactors, uri, |included| {
+            find_callable_definition(included, name).or_else(|| {
+                let includes = included.get_analysis().get_includes();
+                find_included_definition(reactors, includes, name, visited)
+            })
+        })
+    })
+}
+
+/// Collects the names of the macros and functions which the included templates, and the ones
+/// included by them, pull into the namespace of the including template
+pub fn collect_included_callables(
+    reactors: &HashMap<Uri, Reactor>,
+    includes: &[Uri],
+    visited: &mut HashSet<Uri>,
+    names: &mut HashSet<String>,
+) {
+    for uri in includes {
+        if !visited.insert(uri.clone()) {
+            continue;
+        }
+        with_template(reactors, uri, |included| {
+            included.get_analysis().foreach_symbol(|name, symbols| {
+                if symbols
+                    .iter()
+                    .any(|symbol| matches!(symbol.rule, Rule::MacroName | Rule::FunctionName))
+                {
+                    names.insert(name.to_owned());
+                }
+            });
+            let includes = included.get_analysis().get_includes();
+            collect_included_callables(reactors, includes, visited, names);
+            Some(())
+        });
+    }
+}
+
+impl Reactor {
+    /// Jumps to the declaration of a variable, e.g. the captured name of
+    /// `<#assign x>...</#assign>` or a loop variable
+    fn goto_variable_definition(&self, point: Point) -> Option<GotoDefinitionResponse> {
+        let ast = self.get_parser().get_ast()?;
+        let occurrences = reference::find_occurrences(&ast.root_node(), self.get_document());
+        let target = reference::occurrence_at(&occurrences, point)?;
+        let declaration = reference::nearest_declaration(&occurrences, target)?;
+        Some(GotoDefinitionResponse::Scalar(Location {
+            uri: self.get_document().uri(),
+            range: utils::parser_node_to_document_range(&declaration.node, self.get_document()),
+        }))
+    }
+
+    fn goto_callable_definition(
+        &self,
+        name_node: &Node,
+        reactors: &HashMap<Uri, Reactor>,
+    ) -> Option<GotoDefinitionResponse> {
+        // a macro or function might be redefined, jump to the nearest preceding one
+        let name = self
+            .get_document()
+            .get_ranged_text(name_node.start_byte()..name_node.end_byte());
+        match self
+            .get_analysis()
+            .find_nearest_definition(&name, name_node.start_byte())
+        {
+            Ok(definition) => Some(GotoDefinitionResponse::Scalar(Location {
+                uri: self.get_document().uri(),
+                range: definition.range,
+            })),
+            Err(_) => find_included_definition(
+                reactors,
+                self.get_analysis().get_includes(),
+                &name,
+                &mut HashSet::from([self.get_document().uri()]),
+            )
+            .map(GotoDefinitionResponse::Scalar),
+        }
+    }
+
+    fn goto_namespace_definition(
+        &self,
+        member_node: &Node,
+        reactors: &HashMap<Uri, Reactor>,
+    ) -> Option<GotoDefinitionResponse> {
+        // e.g. "renderRow" of "<@c.renderRow/>"
+        let namespace_node = member_node.parent()?.prev_named_sibling()?;
+        let text_of = |node: &Node| {
+            self.get_document()
+                .get_ranged_text(node.start_byte()..node.end_byte())
+        };
+        let uri = self
+            .get_analysis()
+            .get_namespace_uri(&text_of(&namespace_node))?;
+        with_template(reactors, uri, |imported| {
+            find_callable_definition(imported, &text_of(member_node)).or_else(|| {
+                find_included_definition(
+                    reactors,
+                    imported.get_analysis().get_includes(),
+                    &text_of(member_node),
+                    &mut HashSet::from([uri.clone()]),
+                )
+            })
+        })
+        .map(GotoDefinitionResponse::Scalar)
+    }
+}
+
 impl GotoFeature for Reactor {
     async fn on_goto_definition(
         &self,
         params: GotoDefinitionParams,
+        reactors: &HashMap<Uri, Reactor>,
     ) -> JsonRpcResult<Option<GotoDefinitionResponse>> {
-        let point =
-            utils::lsp_position_to_parser_point(&params.text_document_position_params.position);
+        let point = utils::lsp_position_to_parser_point(
+            &params.text_document_position_params.position,
+            self.get_document(),
+        );
         if let Some(node) = self.get_parser().get_node_at_point(point)
             && let Ok(rule) = Rule::from_str(node.kind())
         {
             return match rule {
-                Rule::ImportPath => {
+                Rule::ImportPath | Rule::IncludePath => {
                     // import path is always quoted
                     let path_text = self
                         .get_document()
@@ -42,20 +191,32 @@ impl GotoFeature for Reactor {
                     }
                     Ok(None)
                 }
-                Rule::MacroNamespace => {
-                    let macro_namespace = self
-                        .get_document()
-                        .get_ranged_text(node.start_byte()..node.end_byte());
-                    if let Ok(symbols) =
-                        self.get_analysis().find_symbol_definition(&macro_namespace)
-                    {
-                        let first_definition = symbols[0];
-                        return Ok(Some(GotoDefinitionResponse::Scalar(Location {
-                            uri: self.get_document().uri(),
-                            range: first_definition.range,
-                        })));
+                Rule::MacroNamespace => Ok(self.goto_callable_definition(&node, reactors)),
+                Rule::Identifier
+                    if node
+                        .parent()
+                        .is_some_and(|parent| parent.kind() == Rule::MacroSpecs.to_string()) =>
+                {
+                    Ok(self.goto_namespace_definition(&node, reactors))
+                }
+                Rule::Identifier | Rule::FunctionName => {
+                    // e.g. "total" of "${total()}"
+                    let mut name_node = node;
+                    if rule == Rule::Identifier {
+                        match node.parent() {
+                            Some(parent) => name_node = parent,
+                            None => return Ok(None),
+                        }
+                    }
+                    let is_call =
+                        matches!(Rule::from_str(name_node.kind()), Ok(Rule::FunctionName))
+                            && name_node.parent().is_some_and(|parent| {
+                                matches!(Rule::from_str(parent.kind()), Ok(Rule::CallExpression))
+                            });
+                    match is_call {
+                        true => Ok(self.goto_callable_definition(&name_node, reactors)),
+                        false => Ok(self.goto_variable_definition(point)),
                     }
-                    Ok(None)
                 }
                 _ => Ok(None),
             };
@@ -63,3 +224,53 @@ impl GotoFeature for Reactor {
         Ok(None)
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use std::{collections::HashMap, env};
+
+    use tower_lsp_server::ls_types::{
+        GotoDefinitionParams, GotoDefinitionResponse, Position, Range, TextDocumentIdentifier,
+        TextDocumentPositionParams, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::GotoFeature};
+
+    #[tokio::test]
+    async fn test_goto_definition_of_opened_included_template() {
+        let dir = env::temp_dir().join(format!("freemarker-goto-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let dir = dir.canonicalize().unwrap();
+        std::fs::write(dir.join("lib.ftl"), "<#macro row></#macro>").unwrap();
+        let lib_uri = Uri::from_file_path(dir.join("lib.ftl")).unwrap();
+        let page_uri = Uri::from_file_path(dir.join("page.ftl")).unwrap();
+        let page = Reactor::new(&page_uri, "<#include \"lib.ftl\"><@row/>", 0);
+        // the macro is moved down by the changes of the library not saved yet
+        let reactors = HashMap::from([(
+            lib_uri.clone(),
+            Reactor::new(&lib_uri, "\n<#macro row></#macro>", 1),
+        )]);
+        let definition = page
+            .on_goto_definition(
+                GotoDefinitionParams {
+                    text_document_position_params: TextDocumentPositionParams::new(
+                        TextDocumentIdentifier::new(page_uri),
+                        Position::new(0, 22),
+                    ),
+                    work_done_progress_params: Default::default(),
+                    partial_result_params: Default::default(),
+                },
+                &reactors,
+            )
+            .await;
+        std::fs::remove_dir_all(&dir).unwrap();
+        let Ok(Some(GotoDefinitionResponse::Scalar(location))) = definition else {
+            panic!("the definition should be found in the included template");
+        };
+        assert_eq!(location.uri, lib_uri);
+        assert_eq!(
+            location.range,
+            Range::new(Position::new(1, 8), Position::new(1, 11))
+        );
+    }
+}
@@ -0,0 +1,143 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::str::FromStr;
+
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{
+        DocumentHighlight, DocumentHighlightKind, DocumentHighlightOptions,
+        DocumentHighlightParams, OneOf,
+    },
+};
+use tree_sitter::Node;
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{reactor::Reactor, server::DocumentHighlightFeature, utils};
+
+pub fn document_highlight_capability() -> OneOf<bool, DocumentHighlightOptions> {
+    OneOf::Left(true)
+}
+
+/// Whether the node is a directive tag, e.g. `<#if`, `<#else>` or `</#if>`
+fn is_directive_tag(node: &Node) -> bool {
+    !node.is_missing() && (node.kind().ends_with("_begin") || node.kind().ends_with("_close"))
+}
+
+fn directive_tags<'tree>(node: &Node<'tree>) -> Vec<Node<'tree>> {
+    let mut cursor = node.walk();
+    node.children(&mut cursor)
+        .filter(|child| is_directive_tag(child))
+        .collect()
+}
+
+/// Returns the tags of the block which the given tag belongs to, nested blocks are not involved
+fn matching_tags<'tree>(tag: &Node<'tree>) -> Vec<Node<'tree>> {
+    let Some(mut block) = tag.parent() else {
+        return vec![];
+    };
+    // e.g. <#case> of <#switch>
+    if matches!(Rule::from_str(block.kind()), Ok(Rule::SwitchClause))
+        && let Some(parent) = block.parent()
+    {
+        block = parent;
+    }
+    let mut tags = directive_tags(&block);
+    if matches!(Rule::from_str(block.kind()), Ok(Rule::SwitchStmt)) {
+        let mut cursor = block.walk();
+        if let Some(clause) = block
+            .named_children(&mut cursor)
+            .find(|child| matches!(Rule::from_str(child.kind()), Ok(Rule::SwitchClause)))
+        {
+            tags.extend(directive_tags(&clause));
+        }
+        tags.sort_by_key(|tag| tag.start_byte());
+    }
+    tags
+}
+
+impl DocumentHighlightFeature for Reactor {
+    async fn on_document_highlight(
+        &self,
+        params: DocumentHighlightParams,
+    ) -> JsonRpcResult<Option<Vec<DocumentHighlight>>> {
+        let point = utils::lsp_position_to_parser_point(
+            &params.text_document_position_params.position,
+            self.get_document(),
+        );
+        let Some(node) = self.get_parser().get_node_at_point(point) else {
+            return Ok(None);
+        };
+        if !is_directive_tag(&node) {
+            return Ok(None);
+        }
+        let tags = matching_tags(&node);
+        // a single tag, e.g. <#import>, has nothing to match
+        if tags.len() < 2 {
+            return Ok(None);
+        }
+        Ok(Some(
+            tags.iter()
+                .map(|tag| DocumentHighlight {
+                    range: utils::parser_node_to_document_range(tag, self.get_document()),
+                    kind: Some(DocumentHighlightKind::TEXT),
+                })
+                .collect(),
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        DocumentHighlightParams, Position, Range, TextDocumentIdentifier,
+        TextDocumentPositionParams, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::DocumentHighlightFeature};
+
+    const TEXT: &str = "<#if a><#if b>${b}</#if><#else>${a}</#if>";
+
+    async fn highlighted_ranges(character: u32) -> Option<Vec<Range>> {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let reactor = Reactor::new(&uri, TEXT, 0);
+        let highlights = reactor
+            .on_document_highlight(DocumentHighlightParams {
+                text_document_position_params: TextDocumentPositionParams::new(
+                    TextDocumentIdentifier::new(uri),
+                    Position::new(0, character),
+                ),
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+            })
+            .await
+            .ok()??;
+        Some(
+            highlights
+                .into_iter()
+                .map(|highlight| highlight.range)
+                .collect(),
+        )
+    }
+
+    fn range(start: u32, end: u32) -> Range {
+        Range::new(Position::new(0, start), Position::new(0, end))
+    }
+
+    #[tokio::test]
+    async fn test_nested_blocks_of_the_same_name() {
+        assert_eq!(
+            highlighted_ranges(1).await,
+            Some(vec![range(0, 4), range(24, 31), range(35, 41)])
+        );
+        // the inner block only matches its own closing tag
+        assert_eq!(
+            highlighted_ranges(8).await,
+            Some(vec![range(7, 11), range(18, 24)])
+        );
+        assert_eq!(highlighted_ranges(16).await, None);
+    }
+}
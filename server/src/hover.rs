@@ -13,14 +13,16 @@ use tower_lsp_server::{
         MarkupKind,
     },
 };
+use tree_sitter::{Node, Point};
 use tree_sitter_freemarker::grammar::Rule;
 
 //use crate::symbol::MacroNamespace;
-use crate::{reactor::Reactor, server::HoverFeature, utils};
+use crate::{doc::TextDocument, reactor::Reactor, reference, server::HoverFeature, utils};
 
 #[derive(Embed)]
 #[folder = "assets/hover/"]
 #[include = "built-ins/*"]
+#[include = "directives/*"]
 #[include = "types/*"]
 struct HoverAssetPath;
 
@@ -58,6 +60,7 @@ impl HoverAssetItem {
 #[derive(Debug, Clone)]
 struct HoverAsset {
     built_in: HashMap<String, Hover>,
+    directives: HashMap<String, Hover>,
     types: HashMap<String, Hover>,
     // TODO: other hovers
 }
@@ -78,6 +81,7 @@ fn insert_to_hover_map(item: HoverAssetItem, hovers: &mut HashMap<String, Hover>
 impl HoverAsset {
     fn new() -> Self {
         let mut built_in: HashMap<String, Hover> = HashMap::new();
+        let mut directives: HashMap<String, Hover> = HashMap::new();
         let mut types: HashMap<String, Hover> = HashMap::new();
         HoverAssetPath::iter().for_each(|file| {
             if let Some(embedded_file) = HoverAssetPath::get(&file)
@@ -85,12 +89,17 @@ impl HoverAsset {
             {
                 match item.category.as_str() {
                     "built-in" => insert_to_hover_map(item, &mut built_in),
+                    "directive" => insert_to_hover_map(item, &mut directives),
                     "types" => insert_to_hover_map(item, &mut types),
                     _ => {}
                 }
             }
         });
-        HoverAsset { built_in, types }
+        HoverAsset {
+            built_in,
+            directives,
+            types,
+        }
     }
 }
 
@@ -100,10 +109,176 @@ pub fn hover_capability() -> HoverProviderCapability {
     HoverProviderCapability::Simple(true)
 }
 
+fn rule_of(node: &Node) -> Option<Rule> {
+    Rule::from_str(node.kind()).ok()
+}
+
+/// Describes the declaration of a variable in markdown, e.g. the assignment with its line
+fn describe_declaration(declaration: &Node, doc: &TextDocument) -> Option<String> {
+    let text_of = |node: &Node| doc.get_ranged_text(node.start_byte()..node.end_byte());
+    let line = declaration.start_position().row + 1;
+    let name = text_of(declaration);
+    // the identifier of a variable is wrapped, e.g. the "left" of an assignment
+    let mut holder = declaration.parent()?;
+    if rule_of(&holder) == Some(Rule::Variable) {
+        holder = holder.parent()?;
+    }
+    let (clause, expression) = match rule_of(&holder)? {
+        Rule::AssignExpression => (holder.parent()?, Some(holder)),
+        _ => (holder, None),
+    };
+    let code = match rule_of(&clause)? {
+        Rule::AssignInline | Rule::GlobalInline | Rule::LocalInline => {
+            let directive = utils::directive_name(&text_of(&clause.prev_sibling()?)).to_owned();
+            format!("<#{} {}>", directive, text_of(&expression?))
+        }
+        Rule::AssignClause | Rule::GlobalClause | Rule::LocalClause => {
+            let directive = utils::directive_name(&text_of(&clause.prev_sibling()?)).to_owned();
+            format!("<#{} {}>…</#{}>", directive, name, directive)
+        }
+        Rule::ListClause => {
+            // the loop header without the body, e.g. "<#list users as user>"
+            let collection = clause.child_by_field_name("collection")?;
+            let mut cursor = clause.walk();
+            let iterator = clause
+                .children_by_field_name("iterator", &mut cursor)
+                .last()?;
+            return Some(format!(
+                "Loop variable `{}` of\n```ftl\n<#list {}>\n```\n(line {})",
+                name,
+                doc.get_ranged_text(collection.start_byte()..iterator.end_byte()),
+                line
+            ));
+        }
+        Rule::MacroClause | Rule::FunctionClause => {
+            let stmt = clause.parent()?;
+            let callable = match rule_of(&stmt)? {
+                Rule::MacroStmt => stmt
+                    .child_by_field_name(Rule::MacroName.to_string())
+                    .map(|name_node| format!("macro `{}`", text_of(&name_node))),
+                _ => clause
+                    .child_by_field_name("name")
+                    .map(|name_node| format!("function `{}`", text_of(&name_node))),
+            }?;
+            let default = expression
+                .and_then(|expression| expression.child_by_field_name("right"))
+                .map(|right| format!(", default: `{}`", text_of(&right)))
+                .unwrap_or_default();
+            return Some(format!(
+                "Parameter `{}` of {}{} (line {})",
+                name, callable, default, line
+            ));
+        }
+        _ => return None,
+    };
+    Some(format!("```ftl\n{}\n```\n(line {})", code, line))
+}
+
+/// Returns the minimum and maximum fraction digits of a numeric interpolation format, e.g.
+/// (2, 3) of "m2M3", the maximum defaults to the minimum when only the minimum is given
+fn parse_numeric_format(format: &str) -> Option<(usize, usize)> {
+    let (mut min, mut max) = (None, None);
+    let mut rest = format;
+    while let Some(kind) = rest.chars().next() {
+        let digits_len = rest[1..]
+            .find(|c: char| !c.is_ascii_digit())
+            .unwrap_or(rest.len() - 1);
+        let digits: usize = rest[1..1 + digits_len].parse().ok()?;
+        match kind {
+            'm' => min = Some(digits),
+            'M' => max = Some(digits),
+            _ => return None,
+        }
+        rest = &rest[1 + digits_len..];
+    }
+    let min = min.unwrap_or(0);
+    Some((min, max.unwrap_or(min).max(min)))
+}
+
+/// The `?string` pattern equivalent to the fraction digits, e.g. "0.00#" of (2, 3)
+fn numeric_format_pattern(min: usize, max: usize) -> String {
+    match max {
+        0 => "0".to_owned(),
+        _ => format!("0.{}{}", "0".repeat(min), "#".repeat(max - min)),
+    }
+}
+
+/// Returns the interpolation equivalent to a numeric interpolation, e.g. `${x?string("0.00#")}`
+/// of `#{x; m2M3}`, `None` if the format can not be translated
+pub fn numeric_interpolation_replacement(
+    interpolation: &Node,
+    doc: &TextDocument,
+) -> Option<String> {
+    let value = interpolation.child_by_field_name("value")?;
+    let value_text = doc.get_ranged_text(value.start_byte()..value.end_byte());
+    match interpolation.child_by_field_name("format") {
+        Some(format) => {
+            let format_text = doc.get_ranged_text(format.start_byte()..format.end_byte());
+            let (min, max) = parse_numeric_format(&format_text)?;
+            Some(format!(
+                "${{{}?string(\"{}\")}}",
+                value_text,
+                numeric_format_pattern(min, max)
+            ))
+        }
+        // no digits limit given, the number format of the configuration applies
+        None => Some(format!("${{{}?string}}", value_text)),
+    }
+}
+
+fn describe_numeric_interpolation(interpolation: &Node, doc: &TextDocument) -> Option<String> {
+    let replacement = numeric_interpolation_replacement(interpolation, doc)?;
+    Some(format!(
+        "Numerical interpolation, deprecated in favor of\n```ftl\n{}\n```",
+        replacement
+    ))
+}
+
+/// Describes a variable generated by an enclosing loop, e.g. "user_index" of `<#list users as
+/// user>`, along with the built-in replacing it
+fn describe_loop_builtin(variable: &Node, doc: &TextDocument) -> Option<String> {
+    let name = doc.get_ranged_text(variable.start_byte()..variable.end_byte());
+    let (base, suffix) = reference::split_loop_builtin(&name)?;
+    let iterator = reference::enclosing_loop_variable(variable, base, doc)?;
+    Some(format!(
+        "Loop variable `{}` generated for `{}` (line {}), same as\n```ftl\n{}?{}\n```",
+        name,
+        base,
+        iterator.start_position().row + 1,
+        base,
+        &suffix[1..]
+    ))
+}
+
+impl Reactor {
+    /// Resolves the variable under the point to its nearest preceding declaration
+    fn hover_variable(&self, point: Point) -> Option<Hover> {
+        let ast = self.get_parser().get_ast()?;
+        let occurrences = reference::find_occurrences(&ast.root_node(), self.get_document());
+        let target = reference::occurrence_at(&occurrences, point)?;
+        let markdown = match reference::nearest_declaration(&occurrences, target) {
+            Some(declaration) => describe_declaration(&declaration.node, self.get_document())?,
+            None => describe_loop_builtin(&target.node, self.get_document())?,
+        };
+        Some(Hover {
+            contents: HoverContents::Markup(MarkupContent {
+                kind: MarkupKind::Markdown,
+                value: markdown,
+            }),
+            range: Some(utils::parser_node_to_document_range(
+                &target.node,
+                self.get_document(),
+            )),
+        })
+    }
+}
+
 impl HoverFeature for Reactor {
     async fn on_hover(&self, params: HoverParams) -> jsonrpc::Result<Option<Hover>> {
-        let point =
-            utils::lsp_position_to_parser_point(&params.text_document_position_params.position);
+        let point = utils::lsp_position_to_parser_point(
+            &params.text_document_position_params.position,
+            self.get_document(),
+        );
         if let Some(node) = self.get_parser().get_node_at_point(point)
             && let Ok(rule) = Rule::from_str(node.kind())
         {
@@ -116,7 +291,10 @@ impl HoverFeature for Reactor {
                     if let Some(hover) = STATIC_ASSETS.types.get(rule_str) {
                         return Ok(Some(Hover {
                             contents: hover.contents.clone(),
-                            range: Some(utils::parser_node_to_document_range(&node)),
+                            range: Some(utils::parser_node_to_document_range(
+                                &node,
+                                self.get_document(),
+                            )),
                         }));
                     }
                     return Ok(None);
@@ -128,7 +306,62 @@ impl HoverFeature for Reactor {
                     if let Some(hover) = STATIC_ASSETS.built_in.get(&node_text) {
                         return Ok(Some(Hover {
                             contents: hover.contents.clone(),
-                            range: Some(utils::parser_node_to_document_range(&node)),
+                            range: Some(utils::parser_node_to_document_range(
+                                &node,
+                                self.get_document(),
+                            )),
+                        }));
+                    }
+                    return Ok(None);
+                }
+                Rule::AssignBegin
+                | Rule::AssignClose
+                | Rule::AutoescBegin
+                | Rule::AutoescClose
+                | Rule::BreakStmt
+                | Rule::CaseBegin
+                | Rule::DefaultBegin
+                | Rule::ElseBegin
+                | Rule::ElseifBegin
+                | Rule::FtlBegin
+                | Rule::FunctionBegin
+                | Rule::FunctionClose
+                | Rule::GlobalBegin
+                | Rule::GlobalClose
+                | Rule::IfBegin
+                | Rule::IfClose
+                | Rule::ImportBegin
+                | Rule::IncludeBegin
+                | Rule::ListBegin
+                | Rule::ListClose
+                | Rule::LocalBegin
+                | Rule::LocalClose
+                | Rule::MacroBegin
+                | Rule::MacroClose
+                | Rule::NestedBegin
+                | Rule::NoautoescBegin
+                | Rule::NoautoescClose
+                | Rule::OnBegin
+                | Rule::OutputformatBegin
+                | Rule::OutputformatClose
+                | Rule::ReturnBegin
+                | Rule::SepBegin
+                | Rule::SepClose
+                | Rule::SwitchBegin
+                | Rule::SwitchClose => {
+                    let node_text = self
+                        .get_document()
+                        .get_ranged_text(node.start_byte()..node.end_byte());
+                    if let Some(hover) = STATIC_ASSETS
+                        .directives
+                        .get(utils::directive_name(&node_text))
+                    {
+                        return Ok(Some(Hover {
+                            contents: hover.contents.clone(),
+                            range: Some(utils::parser_node_to_document_range(
+                                &node,
+                                self.get_document(),
+                            )),
                         }));
                     }
                     return Ok(None);
@@ -147,12 +380,39 @@ impl HoverFeature for Reactor {
                                 contents: HoverContents::Scalar(MarkedString::LanguageString(
                                     utils::ftl_to_rust(definition_line.trim()),
                                 )),
-                                range: Some(utils::parser_node_to_document_range(&node)),
+                                range: Some(utils::parser_node_to_document_range(
+                                    &node,
+                                    self.get_document(),
+                                )),
                             }));
                         }
                         _ => Ok(None),
                     }
                 }
+                Rule::Identifier => Ok(self.hover_variable(point)),
+                Rule::InterpolationPrepend | Rule::NumericFormat => {
+                    // "#{" and the format of a numeric interpolation, "${" has no hover
+                    let Some(interpolation) = node
+                        .parent()
+                        .filter(|parent| parent.kind() == Rule::NumericInterpolation.to_string())
+                    else {
+                        return Ok(None);
+                    };
+                    Ok(
+                        describe_numeric_interpolation(&interpolation, self.get_document()).map(
+                            |markdown| Hover {
+                                contents: HoverContents::Markup(MarkupContent {
+                                    kind: MarkupKind::Markdown,
+                                    value: markdown,
+                                }),
+                                range: Some(utils::parser_node_to_document_range(
+                                    &interpolation,
+                                    self.get_document(),
+                                )),
+                            },
+                        ),
+                    )
+                }
                 _ => Ok(None),
             };
         }
@@ -162,7 +422,20 @@ impl HoverFeature for Reactor {
 
 #[cfg(test)]
 mod tests {
-    use crate::hover::{HoverAsset, HoverAssetItem, HoverAssetPath};
+    use crate::hover::{
+        HoverAsset, HoverAssetItem, HoverAssetPath, numeric_format_pattern, parse_numeric_format,
+    };
+
+    #[test]
+    fn test_numeric_format() {
+        assert_eq!(parse_numeric_format("m2M3"), Some((2, 3)));
+        assert_eq!(parse_numeric_format("M2"), Some((0, 2)));
+        assert_eq!(parse_numeric_format("m1"), Some((1, 1)));
+        assert_eq!(parse_numeric_format("m"), None);
+        assert_eq!(numeric_format_pattern(2, 3), "0.00#");
+        assert_eq!(numeric_format_pattern(0, 2), "0.##");
+        assert_eq!(numeric_format_pattern(0, 0), "0");
+    }
 
     #[test]
     fn test_asset_builtin_from_str() {
@@ -195,4 +468,12 @@ markdown = """baz"""
         let asset = HoverAsset::new();
         assert!(!asset.built_in.is_empty());
     }
+
+    #[test]
+    fn test_asset_directive() {
+        let asset = HoverAsset::new();
+        assert!(asset.directives.contains_key("list"));
+        assert!(asset.directives.contains_key("macro"));
+        assert!(!asset.directives.contains_key("nokia"));
+    }
 }
@@ -0,0 +1,510 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::{
+    collections::{HashMap, HashSet},
+    path::{Path, PathBuf},
+    str::FromStr,
+    time::{Duration, Instant},
+};
+
+use tower_lsp_server::ls_types::{
+    DidChangeWatchedFilesRegistrationOptions, FileSystemWatcher, GlobPattern, Location, OneOf,
+    Registration, SymbolInformation, SymbolKind, Uri, WorkspaceSymbolOptions,
+};
+use tree_sitter::Node;
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{config, doc::TextDocument, outline::find_named_child, parser::TextParser, utils};
+
+/// File extensions of the templates to be indexed
+const TEMPLATE_EXTENSIONS: [&str; 3] = ["ftl", "ftlh", "ftlx"];
+/// The templates watched by the client, changes outside of the editor are indexed again
+const WATCHED_TEMPLATES: &str = "**/*.{ftl,ftlh,ftlx}";
+/// Directories which never contain templates of interest, e.g. VCS metadata or build outputs
+const IGNORED_DIRECTORIES: [&str; 8] = [
+    "node_modules",
+    "target",
+    "build",
+    "dist",
+    "out",
+    "bin",
+    "vendor",
+    "__pycache__",
+];
+// bounds of the indexer, a huge repository is indexed partially rather than stalling the server
+const MAX_INDEXED_FILES: usize = 10_000;
+const MAX_INDEXED_FILE_SIZE: u64 = 1024 * 1024;
+const MAX_INDEXING_TIME: Duration = Duration::from_secs(30);
+const MAX_SEARCH_RESULTS: usize = 512;
+
+pub fn workspace_symbol_capability() -> OneOf<bool, WorkspaceSymbolOptions> {
+    OneOf::Left(true)
+}
+
+pub fn watched_files_registration() -> Registration {
+    let options = DidChangeWatchedFilesRegistrationOptions {
+        watchers: vec![FileSystemWatcher {
+            glob_pattern: GlobPattern::String(WATCHED_TEMPLATES.to_owned()),
+            // created, changed and deleted
+            kind: None,
+        }],
+    };
+    Registration {
+        id: "watched-templates".to_owned(),
+        method: "workspace/didChangeWatchedFiles".to_owned(),
+        register_options: serde_json::to_value(options).ok(),
+    }
+}
+
+/// A macro, function or variable defined in a template
+#[derive(Clone, Debug)]
+pub struct IndexedSymbol {
+    pub(crate) name: String,
+    // the rule of the name, i.e. macro_name, function_name or variable
+    pub(crate) rule: Rule,
+    pub(crate) kind: SymbolKind,
+    pub(crate) location: Location,
+}
+
+/// A call of a macro or function, e.g. "renderRow" of `<@c.renderRow/>` or of `${renderRow()}`
+#[derive(Clone, Debug)]
+pub struct IndexedCall {
+    pub(crate) namespace: Option<String>,
+    pub(crate) name: String,
+    pub(crate) location: Location,
+}
+
+#[derive(Default, Debug)]
+struct IndexedFile {
+    symbols: Vec<IndexedSymbol>,
+    calls: Vec<IndexedCall>,
+    // import aliases to the imported templates
+    namespaces: HashMap<String, Uri>,
+    includes: Vec<Uri>,
+}
+
+#[derive(Default, Debug)]
+pub struct SymbolIndex {
+    files: HashMap<Uri, IndexedFile>,
+    // bumped on every change, lets the users of the index know their results are outdated
+    generation: u64,
+}
+
+fn is_ignored_directory(path: &Path) -> bool {
+    path.file_name()
+        .and_then(|name| name.to_str())
+        // hidden directories, e.g. ".git", ".idea"
+        .is_none_or(|name| name.starts_with('.') || IGNORED_DIRECTORIES.contains(&name))
+}
+
+fn is_template_file(path: &Path) -> bool {
+    path.extension()
+        .and_then(|extension| extension.to_str())
+        .is_some_and(|extension| TEMPLATE_EXTENSIONS.contains(&extension))
+}
+
+/// Walks the given directories for templates, stops when any bound of the indexer is reached
+pub fn collect_template_files(roots: &[PathBuf]) -> Vec<PathBuf> {
+    let started = Instant::now();
+    let mut files = vec![];
+    let mut pending: Vec<PathBuf> = roots.to_vec();
+    while let Some(dir) = pending.pop() {
+        let Ok(entries) = std::fs::read_dir(&dir) else {
+            continue;
+        };
+        for entry in entries.flatten() {
+            if files.len() >= MAX_INDEXED_FILES || started.elapsed() > MAX_INDEXING_TIME {
+                tracing::warn!("workspace indexing stopped after {} files", files.len());
+                return files;
+            }
+            let path = entry.path();
+            // symbolic links are not followed to avoid cycles
+            let Ok(file_type) = entry.file_type() else {
+                continue;
+            };
+            if file_type.is_dir() && !is_ignored_directory(&path) {
+                pending.push(path);
+            } else if file_type.is_file()
+                && is_template_file(&path)
+                && entry
+                    .metadata()
+                    .is_ok_and(|metadata| metadata.len() <= MAX_INDEXED_FILE_SIZE)
+            {
+                files.push(path);
+            }
+        }
+    }
+    files
+}
+
+fn new_indexed_symbol(
+    uri: &Uri,
+    doc: &TextDocument,
+    rule: Rule,
+    name_node: &Node,
+) -> IndexedSymbol {
+    IndexedSymbol {
+        name: doc.get_ranged_text(name_node.start_byte()..name_node.end_byte()),
+        rule,
+        kind: match rule {
+            Rule::MacroName | Rule::FunctionName => SymbolKind::FUNCTION,
+            _ => SymbolKind::VARIABLE,
+        },
+        location: Location {
+            uri: uri.clone(),
+            range: utils::parser_node_to_document_range(name_node, doc),
+        },
+    }
+}
+
+fn new_indexed_call(
+    uri: &Uri,
+    doc: &TextDocument,
+    namespace: Option<String>,
+    name: String,
+    node: &Node,
+) -> IndexedCall {
+    IndexedCall {
+        namespace,
+        name,
+        location: Location {
+            uri: uri.clone(),
+            range: utils::parser_node_to_document_range(node, doc),
+        },
+    }
+}
+
+/// Resolves the quoted path of an import or include to the uri of the template
+fn resolve_quoted_path(doc: &TextDocument, path_node: &Node) -> Option<Uri> {
+    let path = doc.get_ranged_text(path_node.start_byte() + 1..path_node.end_byte() - 1);
+    let resolved = utils::resolve_template_path(&doc.dir(), &path).ok()?;
+    Uri::from_file_path(resolved.is_file().then_some(resolved)?)
+}
+
+fn collect_references(node: &Node, uri: &Uri, doc: &TextDocument, file: &mut IndexedFile) {
+    let text_of = |node: &Node| doc.get_ranged_text(node.start_byte()..node.end_byte());
+    match Rule::from_str(node.kind()) {
+        Ok(Rule::MacroCall) => {
+            // "<@foo/>" or "<@ns.foo/>"
+            let Some(head) = find_named_child(node, Rule::MacroNamespace) else {
+                return;
+            };
+            match find_named_child(node, Rule::MacroSpecs)
+                .and_then(|specs| specs.named_child(specs.named_child_count().checked_sub(1)?))
+            {
+                Some(member) => file.calls.push(new_indexed_call(
+                    uri,
+                    doc,
+                    Some(text_of(&head)),
+                    text_of(&member),
+                    &member,
+                )),
+                None => file
+                    .calls
+                    .push(new_indexed_call(uri, doc, None, text_of(&head), &head)),
+            }
+        }
+        Ok(Rule::FunctionName)
+            if node
+                .parent()
+                .is_some_and(|parent| parent.kind() == Rule::CallExpression.to_string()) =>
+        {
+            // "${foo()}" or "${ns.foo()}"
+            let name = text_of(node);
+            let call = match name.rsplit_once('.') {
+                Some((namespace, member)) => new_indexed_call(
+                    uri,
+                    doc,
+                    Some(namespace.to_owned()),
+                    member.to_owned(),
+                    node,
+                ),
+                None => new_indexed_call(uri, doc, None, name, node),
+            };
+            file.calls.push(call);
+        }
+        Ok(Rule::ImportStmt) => {
+            if let Some(alias_node) = node.child_by_field_name(Rule::ImportAlias.to_string())
+                && let Some(path_node) = node.child_by_field_name(Rule::ImportPath.to_string())
+                && let Some(imported) = resolve_quoted_path(doc, &path_node)
+            {
+                file.namespaces.insert(text_of(&alias_node), imported);
+            }
+        }
+        Ok(Rule::IncludeStmt) => {
+            if let Some(path_node) = node.child_by_field_name(Rule::IncludePath.to_string())
+                && let Some(included) = resolve_quoted_path(doc, &path_node)
+            {
+                file.includes.push(included);
+            }
+        }
+        _ => {}
+    }
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        collect_references(&child, uri, doc, file);
+    }
+}
+
+fn collect_indexed_symbols(
+    node: &Node,
+    uri: &Uri,
+    doc: &TextDocument,
+    symbols: &mut Vec<IndexedSymbol>,
+) {
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        match Rule::from_str(child.kind()) {
+            Ok(Rule::MacroStmt) => {
+                if let Some(name_node) = child.child_by_field_name(Rule::MacroName.to_string()) {
+                    symbols.push(new_indexed_symbol(uri, doc, Rule::MacroName, &name_node));
+                }
+            }
+            Ok(Rule::FunctionStmt) => {
+                if let Some(name_node) = find_named_child(&child, Rule::FunctionClause)
+                    .and_then(|clause| clause.child_by_field_name("name"))
+                {
+                    symbols.push(new_indexed_symbol(uri, doc, Rule::FunctionName, &name_node));
+                }
+            }
+            Ok(Rule::AssignStmt | Rule::GlobalStmt) => {
+                let mut stmt_cursor = child.walk();
+                for part in child.named_children(&mut stmt_cursor) {
+                    let mut name_nodes = vec![];
+                    match Rule::from_str(part.kind()) {
+                        Ok(Rule::AssignInline | Rule::GlobalInline) => {
+                            // e.g. <#global x=1 y=2>
+                            let mut inline_cursor = part.walk();
+                            name_nodes.extend(
+                                part.named_children(&mut inline_cursor)
+                                    .filter_map(|expression| {
+                                        expression.child_by_field_name("left")
+                                    }),
+                            );
+                        }
+                        Ok(Rule::AssignClause | Rule::GlobalClause) => {
+                            // e.g. <#global x>captured</#global>
+                            name_nodes.extend(part.child_by_field_name("into"));
+                        }
+                        _ => {}
+                    }
+                    for name_node in name_nodes {
+                        symbols.push(new_indexed_symbol(uri, doc, Rule::Variable, &name_node));
+                    }
+                }
+                // a variable might be set in the nested block of its capture form
+                collect_indexed_symbols(&child, uri, doc, symbols);
+            }
+            _ => collect_indexed_symbols(&child, uri, doc, symbols),
+        }
+    }
+}
+
+/// Reads the symbols of a template on the disk without indexing it, `None` if it is unreadable
+pub fn read_symbols(uri: &Uri) -> Option<Vec<IndexedSymbol>> {
+    let text = std::fs::read_to_string(uri.to_file_path()?).ok()?;
+    let doc = TextDocument::new(uri, &text);
+    let mut symbols = vec![];
+    if let Some(ast) = TextParser::new(&text).get_ast() {
+        collect_indexed_symbols(&ast.root_node(), uri, &doc, &mut symbols);
+    }
+    Some(symbols)
+}
+
+/// Matches the characters of the query in order, case-insensitively, e.g. "rrow" matches "renderRow"
+fn fuzzy_match(query: &str, name: &str) -> bool {
+    let mut name_chars = name.chars().flat_map(char::to_lowercase);
+    query
+        .chars()
+        .flat_map(char::to_lowercase)
+        .all(|q| name_chars.any(|c| c == q))
+}
+
+impl SymbolIndex {
+    /// Re-indexes a parsed template, e.g. an opened document after changes
+    pub fn update(&mut self, doc: &TextDocument, parser: &TextParser) {
+        let uri = doc.uri();
+        let mut file = IndexedFile::default();
+        if let Some(ast) = parser.get_ast() {
+            collect_indexed_symbols(&ast.root_node(), &uri, doc, &mut file.symbols);
+            collect_references(&ast.root_node(), &uri, doc, &mut file);
+        }
+        self.files.insert(uri, file);
+        self.generation += 1;
+    }
+
+    /// Indexes a template on the disk, unreadable files are skipped
+    pub fn update_file(&mut self, path: &Path) {
+        let Some(uri) = Uri::from_file_path(path) else {
+            return;
+        };
+        // the templates too large to be analyzed are not indexed either
+        if std::fs::metadata(path).is_ok_and(|metadata| metadata.len() > config::max_file_size()) {
+            return;
+        }
+        let Ok(text) = std::fs::read_to_string(path) else {
+            return;
+        };
+        let doc = TextDocument::new(&uri, &text);
+        let parser = TextParser::new(&text);
+        self.update(&doc, &parser);
+    }
+
+    pub fn remove(&mut self, uri: &Uri) {
+        self.files.remove(uri);
+        self.generation += 1;
+    }
+
+    /// Moves the templates indexed in background into this index, documents indexed meanwhile
+    /// are more recent and preserved
+    pub fn merge(&mut self, other: SymbolIndex) {
+        for (uri, file) in other.files {
+            self.files.entry(uri).or_insert(file);
+        }
+        self.generation += 1;
+    }
+
+    /// Drops the templates which are not kept, e.g. to index them again
+    pub fn retain<F>(&mut self, keep: F)
+    where
+        F: Fn(&Uri) -> bool,
+    {
+        self.files.retain(|uri, _| keep(uri));
+        self.generation += 1;
+    }
+
+    /// Returns the symbols defined in the template, `None` if it is not indexed
+    pub fn symbols_of(&self, uri: &Uri) -> Option<&Vec<IndexedSymbol>> {
+        self.files.get(uri).map(|file| &file.symbols)
+    }
+
+    pub fn generation(&self) -> u64 {
+        self.generation
+    }
+
+    /// Whether the template includes the target, directly or through the templates it includes
+    fn includes(&self, uri: &Uri, target: &Uri, visited: &mut HashSet<Uri>) -> bool {
+        if !visited.insert(uri.clone()) {
+            // the templates including each other
+            return false;
+        }
+        self.files.get(uri).is_some_and(|file| {
+            file.includes
+                .iter()
+                .any(|included| included == target || self.includes(included, target, visited))
+        })
+    }
+
+    /// Finds the calls of a macro or function defined in the given template from the other
+    /// templates, either through an import alias or by including the template. The imported and
+    /// included templates might in turn include the given one.
+    pub fn find_calls(&self, target: &Uri, name: &str) -> Vec<Location> {
+        let mut locations: Vec<Location> = self
+            .files
+            .iter()
+            .filter(|(uri, _)| *uri != target)
+            .flat_map(|(uri, file)| {
+                let includes_target = self.includes(uri, target, &mut HashSet::new());
+                let importing_aliases: HashSet<&String> = file
+                    .namespaces
+                    .iter()
+                    .filter(|(_, imported)| {
+                        *imported == target || self.includes(imported, target, &mut HashSet::new())
+                    })
+                    .map(|(alias, _)| alias)
+                    .collect();
+                file.calls.iter().filter(move |call| {
+                    call.name == name
+                        && match &call.namespace {
+                            Some(namespace) => importing_aliases.contains(namespace),
+                            None => includes_target,
+                        }
+                })
+            })
+            .map(|call| call.location.clone())
+            .collect();
+        // a stable order, the files are kept in a hash map
+        locations.sort_by_key(|location| {
+            (
+                location.uri.to_string(),
+                location.range.start.line,
+                location.range.start.character,
+            )
+        });
+        locations
+    }
+
+    #[allow(deprecated)]
+    pub fn search(&self, query: &str) -> Vec<SymbolInformation> {
+        let mut matches: Vec<&IndexedSymbol> = self
+            .files
+            .values()
+            .flat_map(|file| &file.symbols)
+            .filter(|symbol| fuzzy_match(query, &symbol.name))
+            .collect();
+        // shorter names are closer to the query
+        matches.sort_by(|a, b| a.name.len().cmp(&b.name.len()).then(a.name.cmp(&b.name)));
+        matches
+            .into_iter()
+            .take(MAX_SEARCH_RESULTS)
+            .map(|symbol| SymbolInformation {
+                name: symbol.name.clone(),
+                kind: symbol.kind,
+                tags: None,
+                deprecated: None,
+                location: symbol.location.clone(),
+                container_name: None,
+            })
+            .collect()
+    }
+}
+
+/// Indexes all templates under the given directories, it is blocking and to be run in background
+pub fn index_workspace(roots: &[PathBuf]) -> SymbolIndex {
+    let mut index = SymbolIndex::default();
+    for path in collect_template_files(roots) {
+        index.update_file(&path);
+    }
+    index
+}
+
+#[cfg(test)]
+mod tests {
+    use std::env;
+
+    use tower_lsp_server::ls_types::Uri;
+
+    use crate::indexer::SymbolIndex;
+
+    #[test]
+    fn test_calls_through_included_templates() {
+        let dir = env::temp_dir().join(format!("freemarker-index-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let templates = [
+            ("lib.ftl", "<#macro row></#macro>"),
+            ("layout.ftl", "<#include \"lib.ftl\">"),
+            ("page.ftl", "<#include \"layout.ftl\"><@row/>"),
+            ("other.ftl", "<#import \"layout.ftl\" as l><@l.row/>"),
+            ("unrelated.ftl", "<@row/>"),
+        ];
+        let mut index = SymbolIndex::default();
+        for (name, text) in templates {
+            std::fs::write(dir.join(name), text).unwrap();
+        }
+        for (name, _) in templates {
+            index.update_file(&dir.join(name));
+        }
+        let uri_of = |name: &str| Uri::from_file_path(dir.join(name)).unwrap();
+        let mut callers: Vec<Uri> = index
+            .find_calls(&uri_of("lib.ftl"), "row")
+            .into_iter()
+            .map(|location| location.uri)
+            .collect();
+        callers.sort_by_key(|uri| uri.to_string());
+        std::fs::remove_dir_all(&dir).unwrap();
+        // the page includes the library through the layout, the other template imports it
+        assert_eq!(callers, vec![uri_of("other.ftl"), uri_of("page.ftl")]);
+    }
+}
@@ -2,32 +2,72 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
+use std::path::PathBuf;
+
 use tower_lsp_server::ls_types::{
     FileOperationFilter, FileOperationPattern, FileOperationRegistrationOptions, InitializeParams,
-    InitializeResult, ServerCapabilities, ServerInfo, TextDocumentSyncCapability,
-    TextDocumentSyncKind, WorkspaceFileOperationsServerCapabilities, WorkspaceServerCapabilities,
+    InitializeResult, PositionEncodingKind, ServerCapabilities, ServerInfo,
+    TextDocumentSyncCapability, TextDocumentSyncKind, TextDocumentSyncOptions,
+    TextDocumentSyncSaveOptions, WorkspaceFileOperationsServerCapabilities,
+    WorkspaceServerCapabilities,
 };
 use tracing::{Level, event};
 
 use crate::server::{Initializer, Server};
 use crate::{
-    action, completion, diagnosis, folding, format, goto, hover, tokenizer, window_log_info,
+    action, client, completion, config, diagnosis, folding, format, goto, highlight, hover,
+    indexer, inlay, lens, link, linked, outline, reference, rename, selection, signature,
+    tokenizer, window_log_info,
 };
 
-fn do_initialize() -> InitializeResult {
+fn negotiate_position_encoding(params: &InitializeParams) -> PositionEncodingKind {
+    // positions are byte offsets in tree-sitter, so UTF-8 is preferred
+    let supports_utf8 = params
+        .capabilities
+        .general
+        .as_ref()
+        .and_then(|general| general.position_encodings.as_ref())
+        .is_some_and(|encodings| encodings.contains(&PositionEncodingKind::UTF8));
+    match supports_utf8 {
+        true => PositionEncodingKind::UTF8,
+        false => PositionEncodingKind::UTF16,
+    }
+}
+
+fn do_initialize(position_encoding: PositionEncodingKind) -> InitializeResult {
     InitializeResult {
         capabilities: ServerCapabilities {
-            text_document_sync: Some(TextDocumentSyncCapability::Kind(
-                TextDocumentSyncKind::INCREMENTAL,
+            position_encoding: Some(position_encoding),
+            text_document_sync: Some(TextDocumentSyncCapability::Options(
+                TextDocumentSyncOptions {
+                    open_close: Some(true),
+                    change: Some(TextDocumentSyncKind::INCREMENTAL),
+                    // the symbol index is refreshed on saving
+                    save: Some(TextDocumentSyncSaveOptions::Supported(true)),
+                    ..Default::default()
+                },
             )),
             definition_provider: Some(goto::definition_capability()),
+            document_link_provider: Some(link::document_link_capability()),
             hover_provider: Some(hover::hover_capability()),
+            document_highlight_provider: Some(highlight::document_highlight_capability()),
             code_action_provider: Some(action::code_action_capability()),
+            code_lens_provider: Some(lens::code_lens_capability()),
             completion_provider: Some(completion::completion_capability()),
             diagnostic_provider: Some(diagnosis::diagnostic_capability()),
             document_formatting_provider: Some(format::formatting_capability()),
+            document_range_formatting_provider: Some(format::range_formatting_capability()),
+            document_on_type_formatting_provider: Some(format::on_type_formatting_capability()),
             semantic_tokens_provider: Some(tokenizer::semantic_token_capability()),
             folding_range_provider: Some(folding::folding_capability()),
+            document_symbol_provider: Some(outline::document_symbol_capability()),
+            inlay_hint_provider: Some(inlay::inlay_hint_capability()),
+            linked_editing_range_provider: Some(linked::linked_editing_range_capability()),
+            references_provider: Some(reference::references_capability()),
+            rename_provider: Some(rename::rename_capability()),
+            selection_range_provider: Some(selection::selection_range_capability()),
+            signature_help_provider: Some(signature::signature_help_capability()),
+            workspace_symbol_provider: Some(indexer::workspace_symbol_capability()),
             workspace: Some(WorkspaceServerCapabilities {
                 file_operations: Some(WorkspaceFileOperationsServerCapabilities {
                     did_delete: Some(FileOperationRegistrationOptions {
@@ -55,7 +95,44 @@ fn do_initialize() -> InitializeResult {
 impl Initializer for Server {
     #[allow(deprecated)]
     async fn on_initialize(&self, params: InitializeParams) -> InitializeResult {
+        if let Some(trace) = params.trace.as_ref() {
+            client::set_trace_value(trace);
+        }
+        // before anything is logged
+        if let Some(level) = params
+            .initialization_options
+            .as_ref()
+            .and_then(config::log_level_of)
+            && !client::set_log_level(level)
+        {
+            tracing::warn!("unknown log level: {}", level);
+        }
+        if let Some(size) = params
+            .initialization_options
+            .as_ref()
+            .and_then(config::max_file_size_of)
+        {
+            config::set_max_file_size(size);
+        }
+        if let Some(millis) = params
+            .initialization_options
+            .as_ref()
+            .and_then(config::parse_timeout_of)
+        {
+            config::set_parse_timeout(millis);
+        }
         window_log_info!("[Server] initializing...");
+        client::set_watched_files_registration(
+            params
+                .capabilities
+                .workspace
+                .as_ref()
+                .and_then(|workspace| workspace.did_change_watched_files.as_ref())
+                .and_then(|watched_files| watched_files.dynamic_registration)
+                .unwrap_or(false),
+        );
+        let position_encoding = negotiate_position_encoding(&params);
+        config::set_position_encoding((&position_encoding).into());
         if let Ok(mut root_path) = self.root_path.try_write() {
             event!(
                 Level::DEBUG,
@@ -63,7 +140,29 @@ impl Initializer for Server {
                 pwd = params.root_path
             );
             root_path.clone_from(&params.root_path.unwrap_or_default());
+            if !root_path.is_empty() {
+                // absolute template paths are relative to the workspace root by default
+                config::set_template_roots(vec![PathBuf::from(root_path.as_str())]);
+            }
+        }
+        let workspace_roots: Vec<PathBuf> = match &params.workspace_folders {
+            Some(folders) if !folders.is_empty() => folders
+                .iter()
+                .filter_map(|folder| folder.uri.to_file_path())
+                .map(|path| path.to_path_buf())
+                .collect(),
+            _ => config::template_roots(),
+        };
+        config::set_workspace_folders(workspace_roots.clone());
+        if let Some(options) = &params.initialization_options
+            && config::apply_settings(options)
+        {
+            window_log_info!(format!(
+                "[Server] template roots: {:?}",
+                config::template_roots()
+            ));
         }
-        do_initialize()
+        self.workspace.index_workspace(workspace_roots);
+        do_initialize(position_encoding)
     }
 }
@@ -0,0 +1,152 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::str::FromStr;
+
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{
+        InlayHint, InlayHintKind, InlayHintLabel, InlayHintParams, InlayHintServerCapabilities,
+        OneOf,
+    },
+};
+use tree_sitter::{Node, Point};
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{outline::find_named_child, reactor::Reactor, server::InlayHintFeature, utils};
+
+pub fn inlay_hint_capability() -> OneOf<bool, InlayHintServerCapabilities> {
+    OneOf::Left(true)
+}
+
+impl Reactor {
+    fn macro_call_hints(&self, call: &Node, hints: &mut Vec<InlayHint>) {
+        let Some(namespace) = find_named_child(call, Rule::MacroNamespace) else {
+            return;
+        };
+        // only macros of the current template, e.g. not "<@c.row 1/>"
+        if namespace.next_named_sibling().is_some_and(|specs| {
+            specs.kind() == Rule::MacroSpecs.to_string() && specs.child_count() > 0
+        }) {
+            return;
+        }
+        let name = self
+            .get_document()
+            .get_ranged_text(namespace.start_byte()..namespace.end_byte());
+        let Ok(definition) = self
+            .get_analysis()
+            .find_nearest_definition(&name, call.start_byte())
+        else {
+            return;
+        };
+        if definition.rule != Rule::MacroName {
+            return;
+        }
+        let Some(parameters) = self.get_analysis().get_macro_parameters(&definition) else {
+            return;
+        };
+        let mut cursor = call.walk();
+        let positional_arguments = call
+            .children_by_field_name("parameter", &mut cursor)
+            .filter(|argument| {
+                !matches!(Rule::from_str(argument.kind()), Ok(Rule::AssignExpression))
+            });
+        // arguments beyond the declared parameters are left without hints
+        for (argument, parameter) in positional_arguments.zip(parameters) {
+            hints.push(InlayHint {
+                position: utils::parser_node_to_document_range(&argument, self.get_document())
+                    .start,
+                label: InlayHintLabel::String(format!("{}:", parameter.name)),
+                kind: Some(InlayHintKind::PARAMETER),
+                text_edits: None,
+                tooltip: None,
+                padding_left: None,
+                padding_right: Some(true),
+                data: None,
+            });
+        }
+    }
+
+    fn collect_inlay_hints(
+        &self,
+        node: &Node,
+        start: Point,
+        end: Point,
+        hints: &mut Vec<InlayHint>,
+    ) {
+        // skip the nodes out of the requested range
+        if node.end_position() < start || node.start_position() > end {
+            return;
+        }
+        if matches!(Rule::from_str(node.kind()), Ok(Rule::MacroCall)) {
+            self.macro_call_hints(node, hints);
+        }
+        let mut cursor = node.walk();
+        for child in node.named_children(&mut cursor) {
+            self.collect_inlay_hints(&child, start, end, hints);
+        }
+    }
+}
+
+impl InlayHintFeature for Reactor {
+    async fn on_inlay_hint(
+        &self,
+        params: InlayHintParams,
+    ) -> JsonRpcResult<Option<Vec<InlayHint>>> {
+        let mut hints = vec![];
+        if let Some(ast) = self.get_parser().get_ast() {
+            self.collect_inlay_hints(
+                &ast.root_node(),
+                utils::lsp_position_to_parser_point(&params.range.start, self.get_document()),
+                utils::lsp_position_to_parser_point(&params.range.end, self.get_document()),
+                &mut hints,
+            );
+        }
+        Ok(Some(hints))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        InlayHintLabel, InlayHintParams, Position, Range, TextDocumentIdentifier, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::InlayHintFeature};
+
+    #[tokio::test]
+    async fn test_positional_argument_hints() {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let text =
+            "<#macro row label value></#macro>\n<@row \"a\" value=1/>\n<@row \"x\" \"y\" \"z\"/>";
+        let reactor = Reactor::new(&uri, text, 0);
+        let hints = reactor
+            .on_inlay_hint(InlayHintParams {
+                work_done_progress_params: Default::default(),
+                text_document: TextDocumentIdentifier::new(uri),
+                range: Range::new(Position::new(0, 0), Position::new(3, 0)),
+            })
+            .await
+            .unwrap()
+            .unwrap();
+        let hints: Vec<(Position, String)> = hints
+            .into_iter()
+            .map(|hint| match hint.label {
+                InlayHintLabel::String(label) => (hint.position, label),
+                InlayHintLabel::LabelParts(_) => panic!("the labels are plain strings"),
+            })
+            .collect();
+        // named arguments and the ones beyond the parameters have no hints
+        assert_eq!(
+            hints,
+            vec![
+                (Position::new(1, 6), "label:".to_owned()),
+                (Position::new(2, 6), "label:".to_owned()),
+                (Position::new(2, 10), "value:".to_owned()),
+            ]
+        );
+    }
+}
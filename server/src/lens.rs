@@ -0,0 +1,187 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::{collections::HashMap, str::FromStr};
+
+use serde_json::json;
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{CodeLens, CodeLensOptions, CodeLensParams, Command, Location, Position, Uri},
+};
+use tree_sitter::Node;
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{
+    doc::TextDocument,
+    reactor::Reactor,
+    reference::{self, Binding},
+    server::CodeLensFeature,
+    utils,
+};
+
+/// Client side command revealing the locations, the arguments are converted by the client
+const SHOW_REFERENCES_COMMAND: &str = "freemarker.showReferences";
+
+pub fn code_lens_capability() -> CodeLensOptions {
+    CodeLensOptions {
+        resolve_provider: Some(true),
+    }
+}
+
+/// The declaration a lens counts the references of, carried by the data of the lens until it is
+/// resolved
+pub struct LensTarget {
+    pub(crate) uri: Uri,
+    pub(crate) name: String,
+    pub(crate) rule: Rule,
+}
+
+impl LensTarget {
+    pub fn from_lens(lens: &CodeLens) -> Option<Self> {
+        let data = lens.data.as_ref()?;
+        Some(LensTarget {
+            uri: Uri::from_str(data["uri"].as_str()?).ok()?,
+            name: data["name"].as_str()?.to_owned(),
+            rule: Rule::from_str(data["rule"].as_str()?).ok()?,
+        })
+    }
+}
+
+#[derive(Debug)]
+struct CachedReferences {
+    version: i32,
+    generation: u64,
+    // keyed by the name of the macro or function
+    locations: HashMap<String, Vec<Location>>,
+}
+
+/// Reference counts of the lenses, kept until the document or the workspace index changes, so
+/// scrolling through a template does not recount them on every render
+#[derive(Default, Debug)]
+pub struct ReferenceCache {
+    files: HashMap<Uri, CachedReferences>,
+}
+
+impl ReferenceCache {
+    pub fn get_or_insert_with<F>(
+        &mut self,
+        target: &LensTarget,
+        version: i32,
+        generation: u64,
+        find: F,
+    ) -> Vec<Location>
+    where
+        F: FnOnce() -> Vec<Location>,
+    {
+        let cached = self
+            .files
+            .entry(target.uri.clone())
+            .or_insert_with(|| CachedReferences {
+                version,
+                generation,
+                locations: HashMap::new(),
+            });
+        if cached.version != version || cached.generation != generation {
+            // outdated, all the counts of the document are dropped at once
+            *cached = CachedReferences {
+                version,
+                generation,
+                locations: HashMap::new(),
+            };
+        }
+        cached
+            .locations
+            .entry(target.name.clone())
+            .or_insert_with(find)
+            .clone()
+    }
+
+    pub fn remove(&mut self, uri: &Uri) {
+        self.files.remove(uri);
+    }
+}
+
+pub fn references_command(uri: &Uri, position: Position, locations: Vec<Location>) -> Command {
+    let title = match locations.len() {
+        1 => "1 reference".to_owned(),
+        count => format!("{} references", count),
+    };
+    Command {
+        title,
+        command: SHOW_REFERENCES_COMMAND.to_owned(),
+        arguments: Some(vec![json!(uri), json!(position), json!(locations)]),
+    }
+}
+
+fn collect_function_calls(node: &Node, name: &str, doc: &TextDocument, calls: &mut Vec<Location>) {
+    if node.kind() == Rule::FunctionName.to_string()
+        && node
+            .parent()
+            .is_some_and(|parent| parent.kind() == Rule::CallExpression.to_string())
+        && doc.get_ranged_text(node.start_byte()..node.end_byte()) == name
+    {
+        calls.push(Location {
+            uri: doc.uri(),
+            range: utils::parser_node_to_document_range(node, doc),
+        });
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        collect_function_calls(&child, name, doc, calls);
+    }
+}
+
+impl Reactor {
+    /// Finds the calls of a macro or function within this template
+    pub fn find_callable_references(&self, name: &str, rule: Rule) -> Vec<Location> {
+        let Some(ast) = self.get_parser().get_ast() else {
+            return vec![];
+        };
+        let doc = self.get_document();
+        match rule {
+            Rule::MacroName => reference::find_occurrences(&ast.root_node(), doc)
+                .into_iter()
+                .filter(|occurrence| {
+                    !occurrence.declaration && occurrence.binding == Binding::Macro(name.to_owned())
+                })
+                .map(|occurrence| Location {
+                    uri: doc.uri(),
+                    range: utils::parser_node_to_document_range(&occurrence.node, doc),
+                })
+                .collect(),
+            _ => {
+                let mut calls = vec![];
+                collect_function_calls(&ast.root_node(), name, doc, &mut calls);
+                calls
+            }
+        }
+    }
+}
+
+impl CodeLensFeature for Reactor {
+    async fn on_code_lens(&self, _: CodeLensParams) -> JsonRpcResult<Option<Vec<CodeLens>>> {
+        let uri = self.get_document().uri();
+        let mut lenses = vec![];
+        self.get_analysis().foreach_symbol(|name, symbols| {
+            for symbol in symbols {
+                if !matches!(symbol.rule, Rule::MacroName | Rule::FunctionName) {
+                    continue;
+                }
+                // counted lazily on resolving, only the visible lenses are resolved
+                lenses.push(CodeLens {
+                    range: symbol.range,
+                    command: None,
+                    data: Some(json!({
+                        "uri": uri.to_string(),
+                        "name": name,
+                        "rule": symbol.rule.to_string(),
+                    })),
+                });
+            }
+        });
+        lenses.sort_by_key(|lens| (lens.range.start.line, lens.range.start.character));
+        Ok(Some(lenses))
+    }
+}
@@ -0,0 +1,164 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::{
+    path::{Path, PathBuf},
+    str::FromStr,
+};
+
+use serde_json::json;
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{
+        DocumentLink, DocumentLinkOptions, DocumentLinkParams, Uri, WorkDoneProgressOptions,
+    },
+};
+use tree_sitter::Node;
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{config, doc::TextDocument, reactor::Reactor, server::LinkFeature, utils};
+
+pub fn document_link_capability() -> DocumentLinkOptions {
+    DocumentLinkOptions {
+        resolve_provider: Some(true),
+        work_done_progress_options: WorkDoneProgressOptions::default(),
+    }
+}
+
+/// Returns the existing templates which the path might refer to, a relative path is relative to
+/// the directory of the current template, while a root-relative one might be found in several
+/// template roots.
+fn template_candidates(dir: &Path, path: &str) -> Vec<PathBuf> {
+    let path_buf = PathBuf::from(path);
+    let mut candidates: Vec<PathBuf> = match path_buf.is_absolute() {
+        true => config::template_roots()
+            .iter()
+            .map(|root| root.join(path.trim_start_matches('/')))
+            .chain(std::iter::once(path_buf))
+            .filter_map(|candidate| candidate.canonicalize().ok())
+            .collect(),
+        false => dir.join(path_buf).canonicalize().into_iter().collect(),
+    };
+    candidates.retain(|candidate| candidate.is_file());
+    candidates.dedup();
+    candidates
+}
+
+fn collect_document_links(node: &Node, doc: &TextDocument, links: &mut Vec<DocumentLink>) {
+    if matches!(
+        Rule::from_str(node.kind()),
+        Ok(Rule::ImportPath | Rule::IncludePath)
+    ) {
+        // the path is always quoted, the link covers the path without quotes
+        let path = doc.get_ranged_text(node.start_byte() + 1..node.end_byte() - 1);
+        let mut range = utils::parser_node_to_document_range(node, doc);
+        range.start.character += 1;
+        range.end.character -= 1;
+        let candidates = template_candidates(&doc.dir(), &path);
+        links.push(DocumentLink {
+            range,
+            // it is resolved later if ambiguous or not found
+            target: match candidates.as_slice() {
+                [candidate] => Uri::from_file_path(candidate),
+                _ => None,
+            },
+            tooltip: None,
+            data: Some(json!({ "uri": doc.uri().to_string(), "path": path })),
+        });
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        collect_document_links(&child, doc, links);
+    }
+}
+
+/// Resolves the target of a link, the first template root containing the path takes precedence
+pub fn resolve_document_link(mut link: DocumentLink) -> DocumentLink {
+    if link.target.is_some() {
+        return link;
+    }
+    let data = link.data.as_ref();
+    let dir = data
+        .and_then(|data| data["uri"].as_str())
+        .and_then(|uri| Uri::from_str(uri).ok())
+        .and_then(|uri| uri.to_file_path().map(|path| path.to_path_buf()))
+        .and_then(|path| path.parent().map(Path::to_path_buf));
+    let path = data.and_then(|data| data["path"].as_str());
+    if let Some((dir, path)) = dir.zip(path) {
+        let candidates = template_candidates(&dir, path);
+        if let Some(candidate) = candidates.first() {
+            link.target = Uri::from_file_path(candidate);
+        }
+        if candidates.len() > 1 {
+            link.tooltip = Some(format!(
+                "{} templates match the path, following {}",
+                candidates.len(),
+                candidates[0].display()
+            ));
+        }
+    }
+    link
+}
+
+impl LinkFeature for Reactor {
+    async fn on_document_link(
+        &self,
+        _: DocumentLinkParams,
+    ) -> JsonRpcResult<Option<Vec<DocumentLink>>> {
+        let mut links = vec![];
+        if let Some(ast) = self.get_parser().get_ast() {
+            collect_document_links(&ast.root_node(), self.get_document(), &mut links);
+        }
+        Ok(Some(links))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::env;
+
+    use tower_lsp_server::ls_types::{
+        DocumentLinkParams, Position, Range, TextDocumentIdentifier, Uri,
+    };
+
+    use crate::{link::resolve_document_link, reactor::Reactor, server::LinkFeature};
+
+    #[tokio::test]
+    async fn test_links_of_template_paths() {
+        let dir = env::temp_dir().join(format!("freemarker-link-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let dir = dir.canonicalize().unwrap();
+        std::fs::write(dir.join("lib.ftl"), "").unwrap();
+        let uri = Uri::from_file_path(dir.join("page.ftl")).unwrap();
+        let reactor = Reactor::new(
+            &uri,
+            "<#import \"lib.ftl\" as l><#include \"missing.ftl\">",
+            0,
+        );
+        let links = reactor
+            .on_document_link(DocumentLinkParams {
+                text_document: TextDocumentIdentifier::new(uri),
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+            })
+            .await
+            .unwrap()
+            .unwrap();
+        let resolved: Vec<_> = links.into_iter().map(resolve_document_link).collect();
+        std::fs::remove_dir_all(&dir).unwrap();
+        // the links cover the paths without the quotes
+        assert_eq!(resolved.len(), 2);
+        assert_eq!(
+            resolved[0].range,
+            Range::new(Position::new(0, 10), Position::new(0, 17))
+        );
+        assert_eq!(resolved[0].target, Uri::from_file_path(dir.join("lib.ftl")));
+        assert_eq!(
+            resolved[1].range,
+            Range::new(Position::new(0, 35), Position::new(0, 46))
+        );
+        assert_eq!(resolved[1].target, None);
+    }
+}
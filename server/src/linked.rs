@@ -0,0 +1,111 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{
+        LinkedEditingRangeParams, LinkedEditingRangeServerCapabilities, LinkedEditingRanges, Range,
+    },
+};
+use tree_sitter::Node;
+
+use crate::{doc::TextDocument, reactor::Reactor, server::LinkedEditingFeature, utils};
+
+/// Matches the directive name only, so that typing other characters ends the linked editing
+const DIRECTIVE_NAME_PATTERN: &str = "[a-zA-Z_][a-zA-Z0-9_]*";
+
+pub fn linked_editing_range_capability() -> LinkedEditingRangeServerCapabilities {
+    LinkedEditingRangeServerCapabilities::Simple(true)
+}
+
+/// Returns the opener and the closer of a block directive, e.g. `<#list` and `</#list>`
+fn paired_tags<'tree>(tag: &Node<'tree>) -> Option<(Node<'tree>, Node<'tree>)> {
+    let stmt = tag
+        .parent()
+        .filter(|parent| parent.kind().ends_with("_stmt"))?;
+    let opener = stmt
+        .child(0)
+        .filter(|child| child.kind().ends_with("_begin"))?;
+    let closer = stmt
+        .child(stmt.child_count().checked_sub(1)?)
+        .filter(|child| child.kind().ends_with("_close") && !child.is_missing())?;
+    (*tag == opener || *tag == closer).then_some((opener, closer))
+}
+
+/// Returns the range of the directive name inside a tag, e.g. "list" of `</#list>`
+fn directive_name_range(tag: &Node, doc: &TextDocument, prefix_len: u32, suffix_len: u32) -> Range {
+    let mut range = utils::parser_node_to_document_range(tag, doc);
+    range.start.character += prefix_len;
+    range.end.character -= suffix_len;
+    range
+}
+
+impl LinkedEditingFeature for Reactor {
+    async fn on_linked_editing_range(
+        &self,
+        params: LinkedEditingRangeParams,
+    ) -> JsonRpcResult<Option<LinkedEditingRanges>> {
+        let point = utils::lsp_position_to_parser_point(
+            &params.text_document_position_params.position,
+            self.get_document(),
+        );
+        let Some((opener, closer)) = self
+            .get_parser()
+            .get_node_at_point(point)
+            .and_then(|node| paired_tags(&node))
+        else {
+            return Ok(None);
+        };
+        // tags of a block are always in the same syntax, "<#" and "</#", or "[#" and "[/#",
+        // and the opener takes attributes so it does not contain the close tag
+        Ok(Some(LinkedEditingRanges {
+            ranges: vec![
+                directive_name_range(&opener, self.get_document(), 2, 0),
+                directive_name_range(&closer, self.get_document(), 3, 1),
+            ],
+            word_pattern: Some(DIRECTIVE_NAME_PATTERN.to_owned()),
+        }))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        LinkedEditingRangeParams, Position, Range, TextDocumentIdentifier,
+        TextDocumentPositionParams, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::LinkedEditingFeature};
+
+    async fn linked_ranges(character: u32) -> Option<Vec<Range>> {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let reactor = Reactor::new(&uri, "<#list xs as x>${x}</#list>", 0);
+        reactor
+            .on_linked_editing_range(LinkedEditingRangeParams {
+                text_document_position_params: TextDocumentPositionParams::new(
+                    TextDocumentIdentifier::new(uri),
+                    Position::new(0, character),
+                ),
+                work_done_progress_params: Default::default(),
+            })
+            .await
+            .unwrap()
+            .map(|linked| linked.ranges)
+    }
+
+    #[tokio::test]
+    async fn test_linked_directive_names() {
+        let names = Some(vec![
+            Range::new(Position::new(0, 2), Position::new(0, 6)),
+            Range::new(Position::new(0, 22), Position::new(0, 26)),
+        ]);
+        // from either the opening or the closing tag
+        assert_eq!(linked_ranges(3).await, names);
+        assert_eq!(linked_ranges(23).await, names);
+        // not in a tag
+        assert_eq!(linked_ranges(7).await, None);
+    }
+}
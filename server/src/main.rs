@@ -5,32 +5,77 @@
 #![deny(clippy::print_stdout)]
 #![deny(clippy::print_stderr)]
 
-use std::env;
+use std::{
+    env,
+    io::{self, Write},
+};
+use tokio::{
+    io::{AsyncRead, AsyncWrite},
+    net::TcpListener,
+};
 use tower_lsp_server::LspService;
 use tracing::{level_filters::LevelFilter, subscriber};
 use tracing_subscriber::fmt::format::FmtSpan;
 
 mod action;
 mod analysis;
+mod builtin;
+mod cli;
 mod client;
 mod completion;
+mod config;
 mod diagnosis;
 mod doc;
 mod folding;
 mod format;
 mod goto;
+mod highlight;
 mod hover;
+mod indexer;
 mod init;
+mod inlay;
+mod lens;
+mod link;
+mod linked;
+mod outline;
 mod parser;
 mod reactor;
+mod reference;
+mod rename;
+mod selection;
 mod server;
+mod signature;
 mod symbol;
 mod tokenizer;
+mod trace;
 mod utils;
 mod workspace;
 
+async fn serve<I, O>(input: I, output: O)
+where
+    I: AsyncRead + Unpin,
+    O: AsyncWrite,
+{
+    let (service, socket) = LspService::build(server::Server::new)
+        .custom_method("$/setTrace", server::Server::set_trace)
+        .finish();
+    tower_lsp_server::Server::new(input, output, socket)
+        .serve(service)
+        .await;
+}
+
 #[tokio::main]
 async fn main() {
+    // subcommands for debugging, e.g. `lsp-for-freemarker parse foo.ftl`
+    let args: Vec<String> = env::args().skip(1).collect();
+    if args.first().is_some_and(|command| command == "parse") {
+        std::process::exit(cli::parse_command(&args[1..]));
+    }
+    let transport = match cli::transport_of(&args) {
+        Ok(transport) => transport,
+        Err(code) => std::process::exit(code),
+    };
+
     // tracing facility
     let cache_dir = env::temp_dir().join(server::Server::CODE_NAME);
     let file_appender = tracing_appender::rolling::hourly(cache_dir, "lsp-for-freemarker.log");
@@ -63,10 +108,32 @@ async fn main() {
     subscriber::set_global_default(subscriber).expect("Could not set global default subscriber");
 
     // TODO: support other commands (e.g. `--version`, `--log`)
-    let stdin = tokio::io::stdin();
-    let stdout = tokio::io::stdout();
-    let (service, socket) = LspService::new(server::Server::new);
-    tower_lsp_server::Server::new(stdin, stdout, socket)
-        .serve(service)
-        .await;
+    // the traces always go to the log file, stdout is the channel of the JSON-RPC messages
+    match transport {
+        cli::Transport::Stdio => serve(tokio::io::stdin(), tokio::io::stdout()).await,
+        cli::Transport::Listen(address) => {
+            let listener = match TcpListener::bind(&address).await {
+                Ok(listener) => listener,
+                Err(e) => {
+                    tracing::error!("cannot listen on {}: {}", address, e);
+                    let _ = writeln!(io::stderr(), "cannot listen on {}: {}", address, e);
+                    std::process::exit(cli::EXIT_USAGE);
+                }
+            };
+            tracing::info!("listening on {}", address);
+            // a single client is served, the server exits once it disconnects
+            let (stream, peer) = match listener.accept().await {
+                Ok(connection) => connection,
+                Err(e) => {
+                    tracing::error!("cannot accept a connection: {}", e);
+                    return;
+                }
+            };
+            drop(listener);
+            tracing::info!("serving {}", peer);
+            let (input, output) = stream.into_split();
+            serve(input, output).await;
+            tracing::info!("{} disconnected", peer);
+        }
+    }
 }
@@ -0,0 +1,267 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::str::FromStr;
+
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{
+        DocumentSymbol, DocumentSymbolOptions, DocumentSymbolParams, DocumentSymbolResponse, OneOf,
+        SymbolKind,
+    },
+};
+use tree_sitter::Node;
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{doc::TextDocument, reactor::Reactor, server::OutlineFeature, utils};
+
+pub fn document_symbol_capability() -> OneOf<bool, DocumentSymbolOptions> {
+    OneOf::Left(true)
+}
+
+pub fn find_named_child<'tree>(node: &Node<'tree>, rule: Rule) -> Option<Node<'tree>> {
+    let mut cursor = node.walk();
+    node.named_children(&mut cursor)
+        .find(|child| Rule::from_str(child.kind()).is_ok_and(|kind| kind == rule))
+}
+
+#[allow(deprecated)]
+fn new_document_symbol(
+    doc: &TextDocument,
+    detail: &str,
+    kind: SymbolKind,
+    node: &Node,
+    name_node: &Node,
+    children: Vec<DocumentSymbol>,
+) -> DocumentSymbol {
+    DocumentSymbol {
+        name: doc.get_ranged_text(name_node.start_byte()..name_node.end_byte()),
+        detail: Some(detail.to_owned()),
+        kind,
+        tags: None,
+        deprecated: None,
+        range: utils::parser_node_to_document_range(node, doc),
+        selection_range: utils::parser_node_to_document_range(name_node, doc),
+        children: (!children.is_empty()).then_some(children),
+    }
+}
+
+fn block_symbol(
+    doc: &TextDocument,
+    detail: &str,
+    kind: SymbolKind,
+    node: &Node,
+    name_node: Option<Node>,
+) -> Option<DocumentSymbol> {
+    let name_node = name_node?;
+    let mut children = vec![];
+    collect_document_symbols(node, doc, &mut children);
+    Some(new_document_symbol(
+        doc, detail, kind, node, &name_node, children,
+    ))
+}
+
+fn collect_variable_symbols(
+    stmt: &Node,
+    doc: &TextDocument,
+    detail: &str,
+    symbols: &mut Vec<DocumentSymbol>,
+) {
+    let mut cursor = stmt.walk();
+    for part in stmt.named_children(&mut cursor) {
+        match Rule::from_str(part.kind()) {
+            Ok(Rule::AssignInline | Rule::GlobalInline | Rule::LocalInline) => {
+                // e.g. <#assign x=1 y=2>
+                let mut inline_cursor = part.walk();
+                for expression in part.named_children(&mut inline_cursor) {
+                    if let Some(left) = expression.child_by_field_name("left") {
+                        symbols.push(new_document_symbol(
+                            doc,
+                            detail,
+                            SymbolKind::VARIABLE,
+                            &expression,
+                            &left,
+                            vec![],
+                        ));
+                    }
+                }
+            }
+            Ok(Rule::AssignClause | Rule::GlobalClause | Rule::LocalClause) => {
+                // e.g. <#assign x>captured</#assign>
+                if let Some(symbol) = block_symbol(
+                    doc,
+                    detail,
+                    SymbolKind::VARIABLE,
+                    stmt,
+                    part.child_by_field_name("into"),
+                ) {
+                    symbols.push(symbol);
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+fn collect_document_symbols(node: &Node, doc: &TextDocument, symbols: &mut Vec<DocumentSymbol>) {
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        let symbol = match Rule::from_str(child.kind()) {
+            Ok(Rule::MacroStmt) => block_symbol(
+                doc,
+                "macro",
+                SymbolKind::FUNCTION,
+                &child,
+                child.child_by_field_name(Rule::MacroName.to_string()),
+            ),
+            Ok(Rule::FunctionStmt) => block_symbol(
+                doc,
+                "function",
+                SymbolKind::FUNCTION,
+                &child,
+                find_named_child(&child, Rule::FunctionClause)
+                    .and_then(|clause| clause.child_by_field_name("name")),
+            ),
+            Ok(Rule::ListStmt) => block_symbol(
+                doc,
+                "list",
+                SymbolKind::ARRAY,
+                &child,
+                find_named_child(&child, Rule::ListClause)
+                    .and_then(|clause| clause.child_by_field_name("collection")),
+            ),
+            Ok(Rule::IfStmt) => block_symbol(
+                doc,
+                "if",
+                SymbolKind::BOOLEAN,
+                &child,
+                find_named_child(&child, Rule::IfClause)
+                    .and_then(|clause| clause.child_by_field_name("condition")),
+            ),
+            Ok(Rule::SwitchStmt) => block_symbol(
+                doc,
+                "switch",
+                SymbolKind::ENUM,
+                &child,
+                find_named_child(&child, Rule::SwitchClause)
+                    .and_then(|clause| clause.child_by_field_name("value")),
+            ),
+            // the opening tags are outside of the clauses, so `<#default>` has nothing to select
+            Ok(Rule::CaseClause) => block_symbol(
+                doc,
+                "case",
+                SymbolKind::ENUM_MEMBER,
+                &child,
+                child.child_by_field_name("condition"),
+            ),
+            Ok(Rule::OnClause) => block_symbol(
+                doc,
+                "on",
+                SymbolKind::ENUM_MEMBER,
+                &child,
+                child.child_by_field_name("condition"),
+            ),
+            Ok(Rule::AssignStmt) => {
+                collect_variable_symbols(&child, doc, "assign", symbols);
+                None
+            }
+            Ok(Rule::GlobalStmt) => {
+                collect_variable_symbols(&child, doc, "global", symbols);
+                None
+            }
+            Ok(Rule::LocalStmt) => {
+                collect_variable_symbols(&child, doc, "local", symbols);
+                None
+            }
+            _ => {
+                // symbols of transparent nodes belong to the current level
+                collect_document_symbols(&child, doc, symbols);
+                None
+            }
+        };
+        if let Some(symbol) = symbol {
+            symbols.push(symbol);
+        }
+    }
+}
+
+impl OutlineFeature for Reactor {
+    async fn on_document_symbol(
+        &self,
+        _: DocumentSymbolParams,
+    ) -> JsonRpcResult<Option<DocumentSymbolResponse>> {
+        let mut symbols = vec![];
+        if let Some(ast) = self.get_parser().get_ast() {
+            collect_document_symbols(&ast.root_node(), self.get_document(), &mut symbols);
+        }
+        Ok(Some(DocumentSymbolResponse::Nested(symbols)))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        DocumentSymbol, DocumentSymbolParams, DocumentSymbolResponse, SymbolKind,
+        TextDocumentIdentifier, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::OutlineFeature};
+
+    // the name, detail, kind and nesting depth of each symbol, in document order
+    fn flatten(
+        symbols: &[DocumentSymbol],
+        depth: usize,
+        flat: &mut Vec<(String, String, SymbolKind, usize)>,
+    ) {
+        for symbol in symbols {
+            flat.push((
+                symbol.name.clone(),
+                symbol.detail.clone().unwrap_or_default(),
+                symbol.kind,
+                depth,
+            ));
+            flatten(
+                symbol.children.as_deref().unwrap_or_default(),
+                depth + 1,
+                flat,
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn test_nested_outline() {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let text = "<#macro row label>\n  <#list items as item>\n    <#assign total = 1>\n  </#list>\n</#macro>\n<#global theme = \"dark\">";
+        let reactor = Reactor::new(&uri, text, 0);
+        let Ok(Some(DocumentSymbolResponse::Nested(symbols))) = reactor
+            .on_document_symbol(DocumentSymbolParams {
+                text_document: TextDocumentIdentifier::new(uri),
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+            })
+            .await
+        else {
+            panic!("the outline should be nested");
+        };
+        let mut flat = vec![];
+        flatten(&symbols, 0, &mut flat);
+        let symbol = |name: &str, detail: &str, kind, depth| {
+            (name.to_owned(), detail.to_owned(), kind, depth)
+        };
+        assert_eq!(
+            flat,
+            vec![
+                symbol("row", "macro", SymbolKind::FUNCTION, 0),
+                symbol("items", "list", SymbolKind::ARRAY, 1),
+                symbol("total", "assign", SymbolKind::VARIABLE, 2),
+                symbol("theme", "global", SymbolKind::VARIABLE, 0),
+            ]
+        );
+        // the selection is the name, within the range of the whole block
+        assert_eq!(symbols[0].selection_range.start.character, 8);
+        assert_eq!(symbols[0].range.end.line, 4);
+    }
+}
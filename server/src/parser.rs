@@ -2,11 +2,72 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
-use tree_sitter::{InputEdit, Node, Parser, Point, Tree};
+use std::{sync::Mutex, time::Instant};
 
+use once_cell::sync::Lazy;
+use tree_sitter::{InputEdit, Node, ParseOptions, ParseState, Parser, Point, Tree};
+
+use crate::config;
+
+// parsers kept for reuse, more parsers are created if the requests run concurrently
+const PARSER_POOL_SIZE: usize = 4;
+
+static PARSER_POOL: Lazy<Mutex<Vec<Parser>>> = Lazy::new(|| Mutex::new(vec![]));
+
+fn new_parser() -> Parser {
+    let mut parser = Parser::new();
+    let language = tree_sitter_freemarker::LANGUAGE;
+    parser
+        .set_language(&language.into())
+        .expect("set parser language should always succeed");
+    parser
+}
+
+/// Runs the function with a parser of the pool, the parser is returned to the pool afterwards
+/// so the language is bound only once per parser
+fn with_pooled_parser<F, R>(func: F) -> R
+where
+    F: FnOnce(&mut Parser) -> R,
+{
+    let pooled = PARSER_POOL.lock().ok().and_then(|mut pool| pool.pop());
+    let mut parser = pooled.unwrap_or_else(new_parser);
+    let result = func(&mut parser);
+    // a parser interrupted in the middle of parsing would resume the old text
+    parser.reset();
+    if let Ok(mut pool) = PARSER_POOL.lock()
+        && pool.len() < PARSER_POOL_SIZE
+    {
+        pool.push(parser);
+    }
+    result
+}
+
+/// Parses the text, `None` if the parsing is cancelled by the timeout. The deadline belongs to
+/// this call only, the pooled parser keeps no timeout for its next use.
+fn parse_with_timeout(parser: &mut Parser, text: &str, old_tree: Option<&Tree>) -> Option<Tree> {
+    let bytes = text.as_bytes();
+    let timeout = config::parse_timeout();
+    let deadline = Instant::now() + timeout;
+    let mut is_overdue = |_: &ParseState| Instant::now() > deadline;
+    let tree = parser.parse_with_options(
+        &mut |byte, _| bytes.get(byte..).unwrap_or_default(),
+        old_tree,
+        Some(ParseOptions::new().progress_callback(&mut is_overdue)),
+    );
+    if tree.is_none() {
+        tracing::warn!(
+            "parsing {} bytes is cancelled after {:?}",
+            bytes.len(),
+            timeout
+        );
+    }
+    tree
+}
+
+/// The syntax tree of a document, which is kept until the document changes. Trees are freed as
+/// soon as they are replaced, the clones returned by `get_ast` share the same tree.
 #[derive(Default, Debug)]
 pub struct TextParser {
-    //parser: Parser,
     ast: Option<Tree>,
 }
 
@@ -14,12 +75,7 @@ impl TextParser {
     /// Creates a new document from the given text and language id. It creates
     /// a rope, parser and syntax tree from the text.
     pub fn new(text: &str) -> Self {
-        let mut parser = Parser::new();
-        let language = tree_sitter_freemarker::LANGUAGE;
-        parser
-            .set_language(&language.into())
-            .expect("set parser language should always succeed");
-        let ast = parser.parse(text, None);
+        let ast = with_pooled_parser(|parser| parse_with_timeout(parser, text, None));
         TextParser { ast }
     }
 
@@ -38,21 +94,16 @@ impl TextParser {
 
     pub fn apply_edit(&mut self, text: &str, input_edit: Option<InputEdit>) {
         //TODO: what if the document's encoding is not UTF8?
-        let old_tree = self.ast.as_mut().unwrap();
-        let mut parser = Parser::new();
-        let language = tree_sitter_freemarker::LANGUAGE;
-        parser
-            .set_language(&language.into())
-            .expect("set parser language should always succeed");
-        self.ast = parser.parse(
-            text,
-            match input_edit {
-                Some(edit) => {
-                    old_tree.edit(&edit);
-                    Some(old_tree)
-                }
-                _ => None,
-            },
-        );
+        let old_tree = match input_edit {
+            // the unchanged parts of the old tree are reused
+            Some(edit) => self.ast.as_mut().map(|tree| {
+                tree.edit(&edit);
+                &*tree
+            }),
+            None => None,
+        };
+        let ast = with_pooled_parser(|parser| parse_with_timeout(parser, text, old_tree));
+        // the old tree is dropped here, unless a request still holds a clone of it
+        self.ast = ast;
     }
 }
@@ -6,10 +6,22 @@ use tower_lsp_server::ls_types::{TextDocumentContentChangeEvent, Uri};
 
 use crate::{
     analysis::Analysis,
+    config,
     doc::{PositionEncodingKind, TextDocument},
     parser::TextParser,
 };
 
+fn is_oversized(doc: &TextDocument) -> bool {
+    doc.len_bytes() as u64 > config::max_file_size()
+}
+
+fn analyze(doc: &TextDocument, parser: &TextParser) -> Analysis {
+    match is_oversized(doc) {
+        true => Analysis::highlight_only(doc, parser),
+        false => Analysis::new(doc, parser),
+    }
+}
+
 #[derive(Debug)]
 pub struct Reactor {
     pub(crate) version: i32,
@@ -22,7 +34,7 @@ impl Reactor {
     pub fn new(uri: &Uri, text: &str, version: i32) -> Self {
         let doc = TextDocument::new(uri, text);
         let parser = TextParser::new(text);
-        let analysis = Analysis::new(&doc, &parser);
+        let analysis = analyze(&doc, &parser);
         Reactor {
             version,
             doc,
@@ -31,6 +43,18 @@ impl Reactor {
         }
     }
 
+    /// Loads a template which is not opened in the editor, e.g. an imported one
+    pub fn from_file(uri: &Uri) -> Option<Self> {
+        let path = uri.to_file_path()?;
+        let text = std::fs::read_to_string(path).ok()?;
+        Some(Reactor::new(uri, &text, 0))
+    }
+
+    /// Whether the template is larger than `maxFileSizeBytes`, it is only highlighted then
+    pub fn is_oversized(&self) -> bool {
+        is_oversized(&self.doc)
+    }
+
     pub fn get_document(&self) -> &TextDocument {
         &self.doc
     }
@@ -43,16 +67,23 @@ impl Reactor {
         &self.analysis
     }
 
-    pub fn apply_content_change(&mut self, version: i32, change: &TextDocumentContentChangeEvent) {
+    /// Analyzes the template again, e.g. after the template roots changed, since the import and
+    /// include paths might resolve to other templates
+    pub fn reanalyze(&mut self) {
+        self.analysis = analyze(&self.doc, &self.parser);
+    }
+
+    pub fn apply_content_change(
+        &mut self,
+        version: i32,
+        change: &TextDocumentContentChangeEvent,
+        position_encoding: PositionEncodingKind,
+    ) {
         // always?
         self.version = version;
-        //TODO: what if the document's encoding is not UTF8?
-        if let Ok(edit) = self
-            .doc
-            .apply_content_change(change, PositionEncodingKind::UTF8)
-        {
+        if let Ok(edit) = self.doc.apply_content_change(change, position_encoding) {
             self.parser.apply_edit(&self.doc.to_string(), edit);
-            self.analysis = Analysis::new(&self.doc, &self.parser);
+            self.analysis = analyze(&self.doc, &self.parser);
         }
     }
 }
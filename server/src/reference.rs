@@ -0,0 +1,337 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::{collections::HashSet, str::FromStr};
+
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{Location, OneOf, ReferenceParams, ReferencesOptions},
+};
+use tree_sitter::{Node, Point};
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{doc::TextDocument, reactor::Reactor, server::ReferenceFeature, utils};
+
+pub fn references_capability() -> OneOf<bool, ReferencesOptions> {
+    OneOf::Left(true)
+}
+
+/// What a name in the template is bound to
+#[derive(Clone, Debug, PartialEq)]
+pub enum Binding {
+    Macro(String),
+    /// a variable and the id of the node scoping it, `None` for the template itself
+    Variable(String, Option<usize>),
+}
+
+/// A name token which is bound to a macro or a variable
+#[derive(Clone, Debug)]
+pub struct Occurrence<'tree> {
+    pub(crate) node: Node<'tree>,
+    pub(crate) binding: Binding,
+    pub(crate) declaration: bool,
+}
+
+enum Role {
+    MacroDeclaration,
+    MacroCall,
+    Declaration(Option<usize>),
+    Usage,
+}
+
+fn rule_of(node: &Node) -> Option<Rule> {
+    Rule::from_str(node.kind()).ok()
+}
+
+fn is_field_of(node: &Node, parent: &Node, field: &str) -> bool {
+    parent
+        .child_by_field_name(field)
+        .is_some_and(|child| child.id() == node.id())
+}
+
+fn enclosing_callable(node: &Node) -> Option<usize> {
+    let mut ancestor = node.parent();
+    while let Some(current) = ancestor {
+        if let Some(Rule::MacroStmt | Rule::FunctionStmt) = rule_of(&current) {
+            return Some(current.id());
+        }
+        ancestor = current.parent();
+    }
+    None
+}
+
+fn classify_variable(variable: &Node) -> Option<Role> {
+    let holder = variable.parent()?;
+    match rule_of(&holder)? {
+        Rule::AssignExpression if is_field_of(variable, &holder, "left") => {
+            match rule_of(&holder.parent()?)? {
+                Rule::AssignInline | Rule::GlobalInline => Some(Role::Declaration(None)),
+                Rule::LocalInline | Rule::MacroClause | Rule::FunctionClause => {
+                    Some(Role::Declaration(enclosing_callable(&holder)))
+                }
+                // named arguments of a macro call are not variables
+                _ => None,
+            }
+        }
+        Rule::AssignClause | Rule::GlobalClause if is_field_of(variable, &holder, "into") => {
+            Some(Role::Declaration(None))
+        }
+        Rule::LocalClause if is_field_of(variable, &holder, "into") => {
+            Some(Role::Declaration(enclosing_callable(&holder)))
+        }
+        Rule::FtlParameter => None,
+        _ => Some(Role::Usage),
+    }
+}
+
+fn classify(node: &Node) -> Option<Role> {
+    match rule_of(node)? {
+        Rule::MacroName => Some(Role::MacroDeclaration),
+        Rule::MacroNamespace => {
+            // "<@ns.foo/>" calls a macro of an imported namespace
+            let is_local_call = node.next_named_sibling().is_none_or(|sibling| {
+                rule_of(&sibling) != Some(Rule::MacroSpecs) || sibling.named_child_count() == 0
+            });
+            is_local_call.then_some(Role::MacroCall)
+        }
+        Rule::ParameterName => Some(Role::Declaration(enclosing_callable(node))),
+        Rule::Identifier => {
+            let parent = node.parent()?;
+            match rule_of(&parent)? {
+                Rule::MacroClause => Some(Role::Declaration(enclosing_callable(node))),
+                // the loop variable only lives in the list clause
+                Rule::ListClause => Some(Role::Declaration(Some(parent.id()))),
+                Rule::Variable => classify_variable(&parent),
+                _ => None,
+            }
+        }
+        _ => None,
+    }
+}
+
+fn resolve_scope(
+    node: &Node,
+    name: &str,
+    declarations: &HashSet<(String, usize)>,
+) -> Option<usize> {
+    let mut child = *node;
+    while let Some(ancestor) = child.parent() {
+        let is_scope = match rule_of(&ancestor) {
+            // the collection is evaluated before the loop variable exists
+            Some(Rule::ListClause) => !is_field_of(&child, &ancestor, "collection"),
+            Some(Rule::MacroStmt | Rule::FunctionStmt) => true,
+            _ => false,
+        };
+        if is_scope && declarations.contains(&(name.to_owned(), ancestor.id())) {
+            return Some(ancestor.id());
+        }
+        child = ancestor;
+    }
+    None
+}
+
+fn collect_roles<'tree>(node: Node<'tree>, roles: &mut Vec<(Node<'tree>, Role)>) {
+    if let Some(role) = classify(&node) {
+        roles.push((node, role));
+        return;
+    }
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        collect_roles(child, roles);
+    }
+}
+
+/// Finds all name tokens bound to a macro or a variable, in document order
+pub fn find_occurrences<'tree>(root: &Node<'tree>, doc: &TextDocument) -> Vec<Occurrence<'tree>> {
+    let mut roles = vec![];
+    collect_roles(*root, &mut roles);
+    let name_of = |node: &Node| doc.get_ranged_text(node.start_byte()..node.end_byte());
+    let declarations: HashSet<(String, usize)> = roles
+        .iter()
+        .filter_map(|(node, role)| match role {
+            Role::Declaration(Some(scope)) => Some((name_of(node), *scope)),
+            _ => None,
+        })
+        .collect();
+    roles
+        .into_iter()
+        .map(|(node, role)| {
+            let name = name_of(&node);
+            let (binding, declaration) = match role {
+                Role::MacroDeclaration => (Binding::Macro(name), true),
+                Role::MacroCall => (Binding::Macro(name), false),
+                Role::Declaration(scope) => (Binding::Variable(name, scope), true),
+                Role::Usage => {
+                    let scope = resolve_scope(&node, &name, &declarations);
+                    (Binding::Variable(name, scope), false)
+                }
+            };
+            Occurrence {
+                node,
+                binding,
+                declaration,
+            }
+        })
+        .collect()
+}
+
+// variables generated by FreeMarker for each loop variable, e.g. "user_index" for "user"
+pub const LOOP_VARIABLE_SUFFIXES: [&str; 5] =
+    ["_index", "_has_next", "_counter", "_is_first", "_is_last"];
+
+/// Splits a generated loop variable into the loop variable and the suffix, e.g. "user" and
+/// "_index" of "user_index"
+pub fn split_loop_builtin(name: &str) -> Option<(&str, &'static str)> {
+    LOOP_VARIABLE_SUFFIXES.iter().find_map(|suffix| {
+        name.strip_suffix(suffix)
+            .filter(|base| !base.is_empty())
+            .map(|base| (base, *suffix))
+    })
+}
+
+/// Returns the loop variables of the list clause, one or the key and the value of
+/// `<#list map as k, v>`
+pub fn loop_variables<'tree>(list_clause: &Node<'tree>) -> Vec<Node<'tree>> {
+    let mut cursor = list_clause.walk();
+    list_clause
+        .children_by_field_name("iterator", &mut cursor)
+        .filter(|iterator| rule_of(iterator) == Some(Rule::Identifier))
+        .collect()
+}
+
+/// Returns the loop variable named `base` of the innermost loop enclosing the node, the
+/// collection of a loop is not enclosed by it
+pub fn enclosing_loop_variable<'tree>(
+    node: &Node<'tree>,
+    base: &str,
+    doc: &TextDocument,
+) -> Option<Node<'tree>> {
+    let mut child = *node;
+    while let Some(ancestor) = child.parent() {
+        if rule_of(&ancestor) == Some(Rule::ListClause)
+            && !is_field_of(&child, &ancestor, "collection")
+            && let Some(iterator) = loop_variables(&ancestor).into_iter().find(|iterator| {
+                doc.get_ranged_text(iterator.start_byte()..iterator.end_byte()) == base
+            })
+        {
+            return Some(iterator);
+        }
+        child = ancestor;
+    }
+    None
+}
+
+/// Returns the occurrence under the given point, a point right after the name counts as well
+pub fn occurrence_at<'a, 'tree>(
+    occurrences: &'a [Occurrence<'tree>],
+    point: Point,
+) -> Option<&'a Occurrence<'tree>> {
+    occurrences.iter().find(|occurrence| {
+        occurrence.node.start_position() <= point && point <= occurrence.node.end_position()
+    })
+}
+
+/// Returns the declaration which the variable occurrence refers to, the nearest preceding one
+/// since a variable might be assigned several times, or the first one if the variable is only
+/// assigned after its use, e.g. in a macro called later
+pub fn nearest_declaration<'a, 'tree>(
+    occurrences: &'a [Occurrence<'tree>],
+    target: &Occurrence<'tree>,
+) -> Option<&'a Occurrence<'tree>> {
+    if !matches!(target.binding, Binding::Variable(..)) {
+        return None;
+    }
+    let declarations: Vec<_> = occurrences
+        .iter()
+        .filter(|occurrence| occurrence.declaration && occurrence.binding == target.binding)
+        .collect();
+    declarations
+        .iter()
+        .rev()
+        .find(|declaration| declaration.node.start_byte() <= target.node.start_byte())
+        .or(declarations.first())
+        .copied()
+}
+
+impl ReferenceFeature for Reactor {
+    async fn on_references(&self, params: ReferenceParams) -> JsonRpcResult<Option<Vec<Location>>> {
+        let Some(ast) = self.get_parser().get_ast() else {
+            return Ok(None);
+        };
+        let occurrences = find_occurrences(&ast.root_node(), self.get_document());
+        let point = utils::lsp_position_to_parser_point(
+            &params.text_document_position.position,
+            self.get_document(),
+        );
+        let Some(target) = occurrence_at(&occurrences, point) else {
+            return Ok(None);
+        };
+        let include_declaration = params.context.include_declaration;
+        let locations = occurrences
+            .iter()
+            .filter(|occurrence| occurrence.binding == target.binding)
+            .filter(|occurrence| include_declaration || !occurrence.declaration)
+            .map(|occurrence| Location {
+                uri: self.get_document().uri(),
+                range: utils::parser_node_to_document_range(&occurrence.node, self.get_document()),
+            })
+            .collect();
+        Ok(Some(locations))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        Position, Range, ReferenceContext, ReferenceParams, TextDocumentIdentifier,
+        TextDocumentPositionParams, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::ReferenceFeature};
+
+    // the second "item" is out of the loop, e.g. a variable of the data model
+    const TEXT: &str = "<#list items as item>${item}</#list>${item}";
+
+    async fn referenced_ranges(character: u32, include_declaration: bool) -> Vec<Range> {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let reactor = Reactor::new(&uri, TEXT, 0);
+        let locations = reactor
+            .on_references(ReferenceParams {
+                text_document_position: TextDocumentPositionParams::new(
+                    TextDocumentIdentifier::new(uri),
+                    Position::new(0, character),
+                ),
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+                context: ReferenceContext {
+                    include_declaration,
+                },
+            })
+            .await;
+        locations
+            .ok()
+            .flatten()
+            .unwrap_or_default()
+            .into_iter()
+            .map(|location| location.range)
+            .collect()
+    }
+
+    fn range(start: u32, end: u32) -> Range {
+        Range::new(Position::new(0, start), Position::new(0, end))
+    }
+
+    #[tokio::test]
+    async fn test_loop_variable_references() {
+        assert_eq!(
+            referenced_ranges(23, true).await,
+            vec![range(16, 20), range(23, 27)]
+        );
+        assert_eq!(referenced_ranges(23, false).await, vec![range(23, 27)]);
+        // the loop variable is scoped to the loop body
+        assert_eq!(referenced_ranges(38, true).await, vec![range(38, 42)]);
+    }
+}
@@ -0,0 +1,162 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::collections::HashMap;
+
+use tower_lsp_server::{
+    jsonrpc::{Error as JsonRpcError, Result as JsonRpcResult},
+    ls_types::{
+        OneOf, PrepareRenameResponse, RenameOptions, RenameParams, TextDocumentPositionParams,
+        TextEdit, WorkDoneProgressOptions, WorkspaceEdit,
+    },
+};
+
+use crate::{
+    reactor::Reactor,
+    reference::{self, Occurrence},
+    server::RenameFeature,
+    utils,
+};
+
+pub fn rename_capability() -> OneOf<bool, RenameOptions> {
+    OneOf::Right(RenameOptions {
+        prepare_provider: Some(true),
+        work_done_progress_options: WorkDoneProgressOptions::default(),
+    })
+}
+
+fn find_bound_occurrences<'a, 'tree>(
+    occurrences: &'a [Occurrence<'tree>],
+    target: &Occurrence,
+) -> Option<Vec<&'a Occurrence<'tree>>> {
+    let bound: Vec<&Occurrence> = occurrences
+        .iter()
+        .filter(|occurrence| occurrence.binding == target.binding)
+        .collect();
+    // names declared elsewhere (e.g. data-model variables) are not renameable
+    bound
+        .iter()
+        .any(|occurrence| occurrence.declaration)
+        .then_some(bound)
+}
+
+impl RenameFeature for Reactor {
+    async fn on_prepare_rename(
+        &self,
+        params: TextDocumentPositionParams,
+    ) -> JsonRpcResult<Option<PrepareRenameResponse>> {
+        let Some(ast) = self.get_parser().get_ast() else {
+            return Ok(None);
+        };
+        let occurrences = reference::find_occurrences(&ast.root_node(), self.get_document());
+        let point = utils::lsp_position_to_parser_point(&params.position, self.get_document());
+        if let Some(target) = reference::occurrence_at(&occurrences, point)
+            && find_bound_occurrences(&occurrences, target).is_some()
+        {
+            return Ok(Some(PrepareRenameResponse::RangeWithPlaceholder {
+                range: utils::parser_node_to_document_range(&target.node, self.get_document()),
+                placeholder: self
+                    .get_document()
+                    .get_ranged_text(target.node.start_byte()..target.node.end_byte()),
+            }));
+        }
+        Ok(None)
+    }
+
+    async fn on_rename(&self, params: RenameParams) -> JsonRpcResult<Option<WorkspaceEdit>> {
+        if !utils::is_valid_identifier(&params.new_name) {
+            return Err(JsonRpcError::invalid_params(format!(
+                "`{}` is not a valid identifier",
+                params.new_name
+            )));
+        }
+        let Some(ast) = self.get_parser().get_ast() else {
+            return Ok(None);
+        };
+        let occurrences = reference::find_occurrences(&ast.root_node(), self.get_document());
+        let point = utils::lsp_position_to_parser_point(
+            &params.text_document_position.position,
+            self.get_document(),
+        );
+        let Some(bound) = reference::occurrence_at(&occurrences, point)
+            .and_then(|target| find_bound_occurrences(&occurrences, target))
+        else {
+            return Ok(None);
+        };
+        let edits = bound
+            .iter()
+            .map(|occurrence| TextEdit {
+                range: utils::parser_node_to_document_range(&occurrence.node, self.get_document()),
+                new_text: params.new_name.clone(),
+            })
+            .collect();
+        Ok(Some(WorkspaceEdit {
+            changes: Some(HashMap::from([(self.get_document().uri(), edits)])),
+            ..Default::default()
+        }))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        Position, Range, RenameParams, TextDocumentIdentifier, TextDocumentPositionParams, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::RenameFeature};
+
+    const TEXT: &str = "<#assign x = 1>\n<#macro m><#local x = 2>${x}</#macro>\n${x}";
+
+    async fn renamed_ranges(line: u32, character: u32, new_name: &str) -> Option<Vec<Range>> {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let reactor = Reactor::new(&uri, TEXT, 0);
+        let edit = reactor
+            .on_rename(RenameParams {
+                text_document_position: TextDocumentPositionParams::new(
+                    TextDocumentIdentifier::new(uri.clone()),
+                    Position::new(line, character),
+                ),
+                new_name: new_name.to_owned(),
+                work_done_progress_params: Default::default(),
+            })
+            .await
+            .ok()??;
+        let mut ranges: Vec<Range> = edit
+            .changes?
+            .remove(&uri)?
+            .into_iter()
+            .map(|edit| edit.range)
+            .collect();
+        ranges.sort_by_key(|range| range.start);
+        Some(ranges)
+    }
+
+    fn range(line: u32, character: u32) -> Range {
+        Range::new(
+            Position::new(line, character),
+            Position::new(line, character + 1),
+        )
+    }
+
+    #[tokio::test]
+    async fn test_rename_respects_local_scope() {
+        // the top level assignment leaves the local of the macro alone
+        assert_eq!(
+            renamed_ranges(0, 9, "y").await,
+            Some(vec![range(0, 9), range(2, 2)])
+        );
+        // and the other way around
+        assert_eq!(
+            renamed_ranges(1, 26, "y").await,
+            Some(vec![range(1, 18), range(1, 26)])
+        );
+    }
+
+    #[tokio::test]
+    async fn test_rename_to_invalid_identifier() {
+        assert_eq!(renamed_ranges(0, 9, "1y").await, None);
+    }
+}
@@ -0,0 +1,122 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::str::FromStr;
+
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{Range, SelectionRange, SelectionRangeParams, SelectionRangeProviderCapability},
+};
+use tree_sitter::Node;
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{doc::TextDocument, reactor::Reactor, server::SelectionFeature, utils};
+
+pub fn selection_range_capability() -> SelectionRangeProviderCapability {
+    SelectionRangeProviderCapability::Simple(true)
+}
+
+/// Returns the body of a clause, i.e. the part after its close tag, e.g. the definitions in
+/// `<#if x>...</#if>`, which is not a node by itself
+fn clause_body_range(clause: &Node, child: &Node, doc: &TextDocument) -> Option<Range> {
+    if !clause.kind().ends_with("_clause") {
+        return None;
+    }
+    let mut cursor = clause.walk();
+    let close_tag = clause.children(&mut cursor).find(|node| {
+        matches!(
+            Rule::from_str(node.kind()),
+            Ok(Rule::CloseTag | Rule::MacroCloseTag)
+        )
+    })?;
+    (child.start_byte() >= close_tag.end_byte()).then(|| Range {
+        start: utils::parser_node_to_document_range(&close_tag, doc).end,
+        end: utils::parser_node_to_document_range(clause, doc).end,
+    })
+}
+
+/// Returns the ranges from the innermost node to the root, each range contains the previous one
+fn expanding_ranges(node: Node, doc: &TextDocument) -> Vec<Range> {
+    let mut ranges = vec![utils::parser_node_to_document_range(&node, doc)];
+    let mut child = node;
+    while let Some(parent) = child.parent() {
+        ranges.extend(clause_body_range(&parent, &child, doc));
+        ranges.push(utils::parser_node_to_document_range(&parent, doc));
+        child = parent;
+    }
+    // a node might be as large as its parent
+    ranges.dedup();
+    ranges
+}
+
+impl SelectionFeature for Reactor {
+    async fn on_selection_range(
+        &self,
+        params: SelectionRangeParams,
+    ) -> JsonRpcResult<Option<Vec<SelectionRange>>> {
+        let mut selection_ranges = vec![];
+        for position in &params.positions {
+            let point = utils::lsp_position_to_parser_point(position, self.get_document());
+            let Some(node) = self.get_parser().get_node_at_point(point) else {
+                return Ok(None);
+            };
+            let selection_range = expanding_ranges(node, self.get_document())
+                .into_iter()
+                .rev()
+                .fold(None, |parent: Option<SelectionRange>, range| {
+                    Some(SelectionRange {
+                        range,
+                        parent: parent.map(Box::new),
+                    })
+                });
+            match selection_range {
+                Some(selection_range) => selection_ranges.push(selection_range),
+                None => return Ok(None),
+            }
+        }
+        Ok(Some(selection_ranges))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        Position, Range, SelectionRange, SelectionRangeParams, TextDocumentIdentifier, Uri,
+    };
+
+    use crate::{reactor::Reactor, server::SelectionFeature};
+
+    fn range(start: u32, end: u32) -> Range {
+        Range::new(Position::new(0, start), Position::new(0, end))
+    }
+
+    #[tokio::test]
+    async fn test_selection_expands_to_the_clause_body() {
+        let uri = Uri::from_str("file:///test.ftl").unwrap();
+        let reactor = Reactor::new(&uri, "<#if x>${y}</#if>", 0);
+        let selection_ranges = reactor
+            .on_selection_range(SelectionRangeParams {
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+                text_document: TextDocumentIdentifier::new(uri),
+                positions: vec![Position::new(0, 9)],
+            })
+            .await
+            .unwrap()
+            .unwrap();
+        let mut ranges = vec![];
+        let mut selection_range: Option<&SelectionRange> = selection_ranges.first();
+        while let Some(current) = selection_range {
+            ranges.push(current.range);
+            selection_range = current.parent.as_deref();
+        }
+        // "y", "${y}" which is the body of the clause too, the clause and the whole directive
+        assert_eq!(
+            ranges,
+            vec![range(9, 10), range(7, 11), range(5, 11), range(0, 17)]
+        );
+    }
+}
@@ -2,24 +2,39 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
-use std::sync::Arc;
+use std::{collections::HashMap, sync::Arc};
 
 use tokio::sync::RwLock;
 use tower_lsp_server::{
     Client, LanguageServer, jsonrpc,
     ls_types::{
-        CodeActionOrCommand, CodeActionParams, CompletionItem, CompletionParams,
-        CompletionResponse, DeleteFilesParams, DidChangeTextDocumentParams,
-        DidChangeWatchedFilesParams, DidCloseTextDocumentParams, DidOpenTextDocumentParams,
-        DocumentDiagnosticParams, DocumentDiagnosticReportResult, DocumentFormattingParams,
-        FoldingRange, FoldingRangeParams, GotoDefinitionParams, GotoDefinitionResponse, Hover,
-        HoverParams, InitializeParams, InitializeResult, InitializedParams, SemanticTokensParams,
-        SemanticTokensResult, TextEdit,
+        CodeActionOrCommand, CodeActionParams, CodeLens, CodeLensParams, CompletionItem,
+        CompletionParams, CompletionResponse, DeleteFilesParams, DidChangeConfigurationParams,
+        DidChangeTextDocumentParams, DidChangeWatchedFilesParams, DidCloseTextDocumentParams,
+        DidOpenTextDocumentParams, DidSaveTextDocumentParams, DocumentDiagnosticParams,
+        DocumentDiagnosticReportResult, DocumentFormattingParams, DocumentHighlight,
+        DocumentHighlightParams, DocumentLink, DocumentLinkParams, DocumentOnTypeFormattingParams,
+        DocumentRangeFormattingParams, DocumentSymbolParams, DocumentSymbolResponse, FoldingRange,
+        FoldingRangeParams, GotoDefinitionParams, GotoDefinitionResponse, Hover, HoverParams,
+        InitializeParams, InitializeResult, InitializedParams, InlayHint, InlayHintParams,
+        LinkedEditingRangeParams, LinkedEditingRanges, Location, Position, PrepareRenameResponse,
+        ReferenceParams, RenameParams, SelectionRange, SelectionRangeParams, SemanticTokensParams,
+        SemanticTokensResult, SetTraceParams, SignatureHelp, SignatureHelpParams,
+        TextDocumentPositionParams, TextEdit, Uri, WorkspaceDiagnosticParams,
+        WorkspaceDiagnosticReportResult, WorkspaceEdit, WorkspaceSymbolParams,
+        WorkspaceSymbolResponse,
     },
 };
 use tracing::{self, instrument};
 
-use crate::{client::save_client, window_log_info, workspace::Workspace};
+use crate::{
+    client::{self, save_client},
+    indexer,
+    reactor::Reactor,
+    trace::{self, RequestTrace},
+    window_log_info, window_log_warn,
+    workspace::Workspace,
+};
 
 #[derive(Debug)]
 pub struct Server {
@@ -40,6 +55,13 @@ impl Server {
     }
 }
 
+impl Server {
+    /// Handles `$/setTrace`, which is not a method of the language server
+    pub async fn set_trace(&self, params: SetTraceParams) {
+        client::set_trace_value(&params.value);
+    }
+}
+
 pub trait Initializer {
     async fn on_initialize(&self, params: InitializeParams) -> InitializeResult;
 }
@@ -52,6 +74,14 @@ impl LanguageServer for Server {
 
     async fn initialized(&self, _: InitializedParams) {
         window_log_info!("[Server] initialized.");
+        if client::supports_watched_files_registration()
+            && let Some(client) = client::get_client()
+            && let Err(e) = client
+                .register_capability(vec![indexer::watched_files_registration()])
+                .await
+        {
+            window_log_warn!(format!("failed to watch the templates: {}", e));
+        }
     }
 
     async fn shutdown(&self) -> jsonrpc::Result<()> {
@@ -60,23 +90,38 @@ impl LanguageServer for Server {
     }
 
     async fn did_open(&self, params: DidOpenTextDocumentParams) {
+        trace::notification("textDocument/didOpen", &params).await;
         self.workspace.on_did_open(&params).await;
     }
 
     async fn did_change(&self, params: DidChangeTextDocumentParams) {
+        trace::notification("textDocument/didChange", &params).await;
         self.workspace.on_did_change(&params).await;
     }
 
+    async fn did_save(&self, params: DidSaveTextDocumentParams) {
+        trace::notification("textDocument/didSave", &params).await;
+        self.workspace.on_did_save(&params).await;
+    }
+
     async fn did_close(&self, params: DidCloseTextDocumentParams) {
+        trace::notification("textDocument/didClose", &params).await;
         let uri = &params.text_document.uri;
         window_log_info!(format!("did_close: {:?}", uri.to_string()));
     }
 
+    async fn did_change_configuration(&self, params: DidChangeConfigurationParams) {
+        trace::notification("workspace/didChangeConfiguration", &params).await;
+        self.workspace.on_did_change_configuration(params).await;
+    }
+
     async fn did_change_watched_files(&self, params: DidChangeWatchedFilesParams) {
+        trace::notification("workspace/didChangeWatchedFiles", &params).await;
         self.workspace.on_did_change_watched_files(params).await;
     }
 
     async fn did_delete_files(&self, params: DeleteFilesParams) {
+        trace::notification("workspace/didDeleteFiles", &params).await;
         self.workspace.on_did_delete_files(params).await;
     }
 
@@ -85,25 +130,72 @@ impl LanguageServer for Server {
         &self,
         params: DocumentDiagnosticParams,
     ) -> jsonrpc::Result<DocumentDiagnosticReportResult> {
-        self.workspace.on_diagnostic(params).await
+        let trace = RequestTrace::received("textDocument/diagnostic", &params).await;
+        trace
+            .responded(self.workspace.on_diagnostic(params).await)
+            .await
+    }
+
+    async fn workspace_diagnostic(
+        &self,
+        params: WorkspaceDiagnosticParams,
+    ) -> jsonrpc::Result<WorkspaceDiagnosticReportResult> {
+        let trace = RequestTrace::received("workspace/diagnostic", &params).await;
+        trace
+            .responded(self.workspace.on_workspace_diagnostic(params).await)
+            .await
     }
 
     async fn semantic_tokens_full(
         &self,
         params: SemanticTokensParams,
     ) -> jsonrpc::Result<Option<SemanticTokensResult>> {
-        self.workspace.on_semantic_tokens_full(params).await
+        let trace = RequestTrace::received("textDocument/semanticTokens/full", &params).await;
+        trace
+            .responded(self.workspace.on_semantic_tokens_full(params).await)
+            .await
+    }
+
+    async fn document_highlight(
+        &self,
+        params: DocumentHighlightParams,
+    ) -> jsonrpc::Result<Option<Vec<DocumentHighlight>>> {
+        let trace = RequestTrace::received("textDocument/documentHighlight", &params).await;
+        trace
+            .responded(self.workspace.on_document_highlight(params).await)
+            .await
+    }
+
+    async fn document_link(
+        &self,
+        params: DocumentLinkParams,
+    ) -> jsonrpc::Result<Option<Vec<DocumentLink>>> {
+        let trace = RequestTrace::received("textDocument/documentLink", &params).await;
+        trace
+            .responded(self.workspace.on_document_link(params).await)
+            .await
+    }
+
+    async fn document_link_resolve(&self, params: DocumentLink) -> jsonrpc::Result<DocumentLink> {
+        let trace = RequestTrace::received("documentLink/resolve", &params).await;
+        trace
+            .responded(self.workspace.on_document_link_resolve(params).await)
+            .await
     }
 
     async fn hover(&self, params: HoverParams) -> jsonrpc::Result<Option<Hover>> {
-        self.workspace.on_hover(params).await
+        let trace = RequestTrace::received("textDocument/hover", &params).await;
+        trace.responded(self.workspace.on_hover(params).await).await
     }
 
     async fn completion(
         &self,
         params: CompletionParams,
     ) -> jsonrpc::Result<Option<CompletionResponse>> {
-        self.workspace.on_completion(params).await
+        let trace = RequestTrace::received("textDocument/completion", &params).await;
+        trace
+            .responded(self.workspace.on_completion(params).await)
+            .await
     }
 
     #[instrument(skip_all)]
@@ -111,7 +203,10 @@ impl LanguageServer for Server {
         &self,
         params: GotoDefinitionParams,
     ) -> jsonrpc::Result<Option<GotoDefinitionResponse>> {
-        self.workspace.on_goto_definition(params).await
+        let trace = RequestTrace::received("textDocument/definition", &params).await;
+        trace
+            .responded(self.workspace.on_goto_definition(params).await)
+            .await
     }
 
     #[instrument(skip_all)]
@@ -119,21 +214,147 @@ impl LanguageServer for Server {
         &self,
         params: DocumentFormattingParams,
     ) -> jsonrpc::Result<Option<Vec<TextEdit>>> {
-        self.workspace.on_formatting(params).await
+        let trace = RequestTrace::received("textDocument/formatting", &params).await;
+        trace
+            .responded(self.workspace.on_formatting(params).await)
+            .await
+    }
+
+    #[instrument(skip_all)]
+    async fn range_formatting(
+        &self,
+        params: DocumentRangeFormattingParams,
+    ) -> jsonrpc::Result<Option<Vec<TextEdit>>> {
+        let trace = RequestTrace::received("textDocument/rangeFormatting", &params).await;
+        trace
+            .responded(self.workspace.on_range_formatting(params).await)
+            .await
+    }
+
+    #[instrument(skip_all)]
+    async fn on_type_formatting(
+        &self,
+        params: DocumentOnTypeFormattingParams,
+    ) -> jsonrpc::Result<Option<Vec<TextEdit>>> {
+        let trace = RequestTrace::received("textDocument/onTypeFormatting", &params).await;
+        trace
+            .responded(self.workspace.on_type_formatting(params).await)
+            .await
     }
 
     async fn folding_range(
         &self,
         params: FoldingRangeParams,
     ) -> jsonrpc::Result<Option<Vec<FoldingRange>>> {
-        self.workspace.on_folding_range(params).await
+        let trace = RequestTrace::received("textDocument/foldingRange", &params).await;
+        trace
+            .responded(self.workspace.on_folding_range(params).await)
+            .await
+    }
+
+    async fn document_symbol(
+        &self,
+        params: DocumentSymbolParams,
+    ) -> jsonrpc::Result<Option<DocumentSymbolResponse>> {
+        let trace = RequestTrace::received("textDocument/documentSymbol", &params).await;
+        trace
+            .responded(self.workspace.on_document_symbol(params).await)
+            .await
+    }
+
+    async fn inlay_hint(&self, params: InlayHintParams) -> jsonrpc::Result<Option<Vec<InlayHint>>> {
+        let trace = RequestTrace::received("textDocument/inlayHint", &params).await;
+        trace
+            .responded(self.workspace.on_inlay_hint(params).await)
+            .await
+    }
+
+    async fn linked_editing_range(
+        &self,
+        params: LinkedEditingRangeParams,
+    ) -> jsonrpc::Result<Option<LinkedEditingRanges>> {
+        let trace = RequestTrace::received("textDocument/linkedEditingRange", &params).await;
+        trace
+            .responded(self.workspace.on_linked_editing_range(params).await)
+            .await
+    }
+
+    async fn references(&self, params: ReferenceParams) -> jsonrpc::Result<Option<Vec<Location>>> {
+        let trace = RequestTrace::received("textDocument/references", &params).await;
+        trace
+            .responded(self.workspace.on_references(params).await)
+            .await
+    }
+
+    async fn prepare_rename(
+        &self,
+        params: TextDocumentPositionParams,
+    ) -> jsonrpc::Result<Option<PrepareRenameResponse>> {
+        let trace = RequestTrace::received("textDocument/prepareRename", &params).await;
+        trace
+            .responded(self.workspace.on_prepare_rename(params).await)
+            .await
+    }
+
+    async fn rename(&self, params: RenameParams) -> jsonrpc::Result<Option<WorkspaceEdit>> {
+        let trace = RequestTrace::received("textDocument/rename", &params).await;
+        trace
+            .responded(self.workspace.on_rename(params).await)
+            .await
+    }
+
+    async fn selection_range(
+        &self,
+        params: SelectionRangeParams,
+    ) -> jsonrpc::Result<Option<Vec<SelectionRange>>> {
+        let trace = RequestTrace::received("textDocument/selectionRange", &params).await;
+        trace
+            .responded(self.workspace.on_selection_range(params).await)
+            .await
+    }
+
+    async fn signature_help(
+        &self,
+        params: SignatureHelpParams,
+    ) -> jsonrpc::Result<Option<SignatureHelp>> {
+        let trace = RequestTrace::received("textDocument/signatureHelp", &params).await;
+        trace
+            .responded(self.workspace.on_signature_help(params).await)
+            .await
+    }
+
+    async fn symbol(
+        &self,
+        params: WorkspaceSymbolParams,
+    ) -> jsonrpc::Result<Option<WorkspaceSymbolResponse>> {
+        let trace = RequestTrace::received("workspace/symbol", &params).await;
+        trace
+            .responded(self.workspace.on_workspace_symbol(params).await)
+            .await
     }
 
     async fn code_action(
         &self,
         params: CodeActionParams,
     ) -> jsonrpc::Result<Option<Vec<CodeActionOrCommand>>> {
-        self.workspace.on_code_action(params).await
+        let trace = RequestTrace::received("textDocument/codeAction", &params).await;
+        trace
+            .responded(self.workspace.on_code_action(params).await)
+            .await
+    }
+
+    async fn code_lens(&self, params: CodeLensParams) -> jsonrpc::Result<Option<Vec<CodeLens>>> {
+        let trace = RequestTrace::received("textDocument/codeLens", &params).await;
+        trace
+            .responded(self.workspace.on_code_lens(params).await)
+            .await
+    }
+
+    async fn code_lens_resolve(&self, params: CodeLens) -> jsonrpc::Result<CodeLens> {
+        let trace = RequestTrace::received("codeLens/resolve", &params).await;
+        trace
+            .responded(self.workspace.on_code_lens_resolve(params).await)
+            .await
     }
 }
 
@@ -145,22 +366,45 @@ pub trait ActionFeature {
     ) -> jsonrpc::Result<Option<Vec<CodeActionOrCommand>>>;
 }
 
+pub trait CodeLensFeature {
+    async fn on_code_lens(&self, params: CodeLensParams) -> jsonrpc::Result<Option<Vec<CodeLens>>>;
+}
+
 pub trait CompletionFeature {
     async fn on_completion(
         &self,
         params: CompletionParams,
     ) -> jsonrpc::Result<Option<CompletionResponse>>;
 
-    fn list_macro_definitions(&self) -> Vec<CompletionItem>;
+    fn list_macro_definitions(&self, position: &Position) -> Option<Vec<CompletionItem>>;
+
+    fn list_macro_parameters(&self, position: &Position) -> Option<Vec<CompletionItem>>;
+
+    fn list_builtin_completions(&self, position: &Position) -> Option<Vec<CompletionItem>>;
+
+    fn list_hash_keys(&self, position: &Position) -> Option<Vec<CompletionItem>>;
+
+    fn list_scope_variables(&self, position: &Position) -> Option<Vec<CompletionItem>>;
+
+    fn list_directive_completions(&self, position: &Position) -> Option<Vec<CompletionItem>>;
 }
 
 pub trait DiagnosticFeature {
     async fn on_diagnostic(
         &self,
         params: DocumentDiagnosticParams,
+        index_generation: u64,
+        reactors: &HashMap<Uri, Reactor>,
     ) -> jsonrpc::Result<DocumentDiagnosticReportResult>;
 }
 
+pub trait DocumentHighlightFeature {
+    async fn on_document_highlight(
+        &self,
+        params: DocumentHighlightParams,
+    ) -> jsonrpc::Result<Option<Vec<DocumentHighlight>>>;
+}
+
 pub trait FoldingFeature {
     async fn on_folding_range(
         &self,
@@ -173,12 +417,23 @@ pub trait FormatFeature {
         &self,
         params: DocumentFormattingParams,
     ) -> jsonrpc::Result<Option<Vec<TextEdit>>>;
+
+    async fn on_range_formatting(
+        &self,
+        params: DocumentRangeFormattingParams,
+    ) -> jsonrpc::Result<Option<Vec<TextEdit>>>;
+
+    async fn on_type_formatting(
+        &self,
+        params: DocumentOnTypeFormattingParams,
+    ) -> jsonrpc::Result<Option<Vec<TextEdit>>>;
 }
 
 pub trait GotoFeature {
     async fn on_goto_definition(
         &self,
         params: GotoDefinitionParams,
+        reactors: &HashMap<Uri, Reactor>,
     ) -> jsonrpc::Result<Option<GotoDefinitionResponse>>;
 }
 
@@ -187,6 +442,64 @@ pub trait HoverFeature {
     async fn on_hover(&self, params: HoverParams) -> jsonrpc::Result<Option<Hover>>;
 }
 
+pub trait InlayHintFeature {
+    async fn on_inlay_hint(
+        &self,
+        params: InlayHintParams,
+    ) -> jsonrpc::Result<Option<Vec<InlayHint>>>;
+}
+
+pub trait LinkFeature {
+    async fn on_document_link(
+        &self,
+        params: DocumentLinkParams,
+    ) -> jsonrpc::Result<Option<Vec<DocumentLink>>>;
+}
+
+pub trait LinkedEditingFeature {
+    async fn on_linked_editing_range(
+        &self,
+        params: LinkedEditingRangeParams,
+    ) -> jsonrpc::Result<Option<LinkedEditingRanges>>;
+}
+
+pub trait OutlineFeature {
+    async fn on_document_symbol(
+        &self,
+        params: DocumentSymbolParams,
+    ) -> jsonrpc::Result<Option<DocumentSymbolResponse>>;
+}
+
+pub trait ReferenceFeature {
+    async fn on_references(
+        &self,
+        params: ReferenceParams,
+    ) -> jsonrpc::Result<Option<Vec<Location>>>;
+}
+
+pub trait RenameFeature {
+    async fn on_prepare_rename(
+        &self,
+        params: TextDocumentPositionParams,
+    ) -> jsonrpc::Result<Option<PrepareRenameResponse>>;
+
+    async fn on_rename(&self, params: RenameParams) -> jsonrpc::Result<Option<WorkspaceEdit>>;
+}
+
+pub trait SelectionFeature {
+    async fn on_selection_range(
+        &self,
+        params: SelectionRangeParams,
+    ) -> jsonrpc::Result<Option<Vec<SelectionRange>>>;
+}
+
+pub trait SignatureFeature {
+    async fn on_signature_help(
+        &self,
+        params: SignatureHelpParams,
+    ) -> jsonrpc::Result<Option<SignatureHelp>>;
+}
+
 pub trait SemanticTokenFeature {
     async fn on_semantic_tokens_full(
         &self,
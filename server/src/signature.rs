@@ -0,0 +1,234 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use tower_lsp_server::{
+    jsonrpc::Result as JsonRpcResult,
+    ls_types::{
+        ParameterInformation, ParameterLabel, SignatureHelp, SignatureHelpOptions,
+        SignatureHelpParams, SignatureInformation, WorkDoneProgressOptions,
+    },
+};
+use tree_sitter_freemarker::grammar::Rule;
+
+use crate::{analysis::MacroParameter, reactor::Reactor, server::SignatureFeature};
+
+pub fn signature_help_capability() -> SignatureHelpOptions {
+    SignatureHelpOptions {
+        trigger_characters: Some(vec![" ".to_owned()]),
+        retrigger_characters: Some(vec![",".to_owned(), "=".to_owned()]),
+        work_done_progress_options: WorkDoneProgressOptions::default(),
+    }
+}
+
+/// An unclosed macro call ending at the cursor, e.g. `<@foo a b=1 c`
+#[derive(Debug, PartialEq)]
+pub struct MacroCallPrefix {
+    pub(crate) name: String,
+    /// arguments before the one under the cursor
+    pub(crate) arguments: Vec<String>,
+    /// the argument under the cursor, empty if it is not started yet
+    pub(crate) current: String,
+}
+
+/// Returns the parameter name of a named argument, e.g. "b" of "b=1"
+pub fn argument_name(argument: &str) -> Option<&str> {
+    let (name, value) = argument.split_once('=')?;
+    let name = name.trim_end();
+    let is_name = !name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_alphanumeric() || matches!(c, '_' | '$'));
+    // "==" is a comparison of a positional argument
+    (is_name && !value.starts_with('=')).then_some(name)
+}
+
+/// Parses the macro call which is still open at the end of the text
+pub fn macro_call_prefix(text: &str) -> Option<MacroCallPrefix> {
+    let begin = text.rfind("<@").max(text.rfind("[@"))?;
+    let call = &text[begin + 2..];
+    let name_end = call.find(|c: char| c.is_whitespace())?;
+    let name = &call[..name_end];
+    if name.is_empty() {
+        return None;
+    }
+    let mut arguments = vec![];
+    let mut current = String::new();
+    let mut separated = false;
+    let mut depth = 0usize;
+    let mut quote: Option<char> = None;
+    let mut chars = call[name_end..].chars().peekable();
+    while let Some(c) = chars.next() {
+        if let Some(q) = quote {
+            current.push(c);
+            match c {
+                '\\' => current.extend(chars.next()),
+                _ if c == q => quote = None,
+                _ => {}
+            }
+            continue;
+        }
+        match c {
+            // the call has been closed before the cursor
+            '>' | ']' | '/'
+                if depth == 0 && (c != '/' || matches!(chars.peek(), Some('>' | ']'))) =>
+            {
+                return None;
+            }
+            ',' if depth == 0 => separated = true,
+            _ if c.is_whitespace() && depth == 0 => separated = !current.is_empty(),
+            _ => {
+                if separated && c != '=' && !current.trim_end().ends_with('=') {
+                    // "a = 1" is still one argument
+                    arguments.push(std::mem::take(&mut current));
+                } else if separated && !current.is_empty() {
+                    current.push(' ');
+                }
+                separated = false;
+                match c {
+                    '"' | '\'' => quote = Some(c),
+                    '(' | '[' | '{' => depth += 1,
+                    ')' | ']' | '}' => depth = depth.saturating_sub(1),
+                    _ => {}
+                }
+                current.push(c);
+            }
+        }
+    }
+    if separated && !current.trim_end().ends_with('=') {
+        arguments.push(std::mem::take(&mut current));
+    }
+    Some(MacroCallPrefix {
+        name: name.to_owned(),
+        arguments,
+        current,
+    })
+}
+
+/// Returns the index of the parameter which the argument under the cursor is passed to
+pub fn active_parameter(call: &MacroCallPrefix, parameters: &[MacroParameter]) -> Option<usize> {
+    let position_of = |name: &str| parameters.iter().position(|p| p.name == name);
+    if let Some(name) = argument_name(&call.current) {
+        return position_of(name);
+    }
+    let named: Vec<&str> = call
+        .arguments
+        .iter()
+        .filter_map(|argument| argument_name(argument))
+        .collect();
+    if named.is_empty() {
+        // positional arguments
+        return (call.arguments.len() < parameters.len()).then_some(call.arguments.len());
+    }
+    // named arguments are in any order, guess by the typed name or take the first one left
+    let unused = |p: &&MacroParameter| !named.contains(&p.name.as_str());
+    parameters
+        .iter()
+        .filter(unused)
+        .find(|p| !call.current.is_empty() && p.name.starts_with(call.current.as_str()))
+        .or_else(|| parameters.iter().find(unused))
+        .and_then(|p| position_of(&p.name))
+}
+
+fn utf16_len(text: &str) -> u32 {
+    text.encode_utf16().count() as u32
+}
+
+fn macro_signature(name: &str, parameters: &[MacroParameter]) -> SignatureInformation {
+    let mut label = name.to_owned();
+    let mut parameter_informations = vec![];
+    for parameter in parameters {
+        label.push(' ');
+        let start = utf16_len(&label);
+        label += &parameter.name;
+        if let Some(default) = &parameter.default {
+            label += &format!("={}", default);
+        }
+        parameter_informations.push(ParameterInformation {
+            label: ParameterLabel::LabelOffsets([start, utf16_len(&label)]),
+            documentation: None,
+        });
+    }
+    SignatureInformation {
+        label,
+        documentation: None,
+        parameters: Some(parameter_informations),
+        active_parameter: None,
+    }
+}
+
+impl SignatureFeature for Reactor {
+    async fn on_signature_help(
+        &self,
+        params: SignatureHelpParams,
+    ) -> JsonRpcResult<Option<SignatureHelp>> {
+        let position = params.text_document_position_params.position;
+        let Some(offset) = self.get_document().position_to_byte(&position) else {
+            return Ok(None);
+        };
+        let Some(call) = macro_call_prefix(&self.get_document().get_ranged_text(0..offset)) else {
+            return Ok(None);
+        };
+        let Ok(definition) = self
+            .get_analysis()
+            .find_nearest_definition(&call.name, offset)
+        else {
+            return Ok(None);
+        };
+        if definition.rule != Rule::MacroName {
+            return Ok(None);
+        }
+        let parameters = self
+            .get_analysis()
+            .get_macro_parameters(&definition)
+            .cloned()
+            .unwrap_or_default();
+        Ok(Some(SignatureHelp {
+            signatures: vec![macro_signature(&call.name, &parameters)],
+            active_signature: Some(0),
+            active_parameter: active_parameter(&call, &parameters).map(|index| index as u32),
+        }))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn parameters(names: &[&str]) -> Vec<MacroParameter> {
+        names
+            .iter()
+            .map(|name| MacroParameter {
+                name: name.to_string(),
+                default: None,
+            })
+            .collect()
+    }
+
+    #[test]
+    fn test_macro_call_prefix() {
+        let call = macro_call_prefix("<p><@row a \"x y\" c = 1 d").unwrap();
+        assert_eq!(call.name, "row");
+        assert_eq!(call.arguments, vec!["a", "\"x y\"", "c = 1"]);
+        assert_eq!(call.current, "d");
+        let call = macro_call_prefix("<@row a=(1 + 2) ").unwrap();
+        assert_eq!(call.arguments, vec!["a=(1 + 2)"]);
+        assert_eq!(call.current, "");
+        assert!(macro_call_prefix("<@row a=1/> ").is_none());
+        assert!(macro_call_prefix("<@row").is_none());
+        assert!(macro_call_prefix("${a}").is_none());
+    }
+
+    #[test]
+    fn test_active_parameter() {
+        let declared = parameters(&["a", "b", "c"]);
+        let call = macro_call_prefix("<@row 1 ").unwrap();
+        assert_eq!(active_parameter(&call, &declared), Some(1));
+        let call = macro_call_prefix("<@row c=1 a=").unwrap();
+        assert_eq!(active_parameter(&call, &declared), Some(0));
+        let call = macro_call_prefix("<@row a=1 ").unwrap();
+        assert_eq!(active_parameter(&call, &declared), Some(1));
+        let call = macro_call_prefix("<@row b=1 c").unwrap();
+        assert_eq!(active_parameter(&call, &declared), Some(2));
+    }
+}
@@ -2,7 +2,8 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
-use std::{path::PathBuf, str::FromStr};
+use std::io::ErrorKind;
+use std::str::FromStr;
 
 use tower_lsp_server::ls_types::{
     CodeDescription, Diagnostic, DiagnosticRelatedInformation, DiagnosticSeverity, Location,
@@ -14,7 +15,10 @@ use tree_sitter_freemarker::{SEMANTICS, grammar::Rule};
 
 use crate::diagnosis::Scenario;
 use crate::{
-    analysis::{Analysis, AnalysisContext, Symbol, SymbolAnalysis},
+    analysis::{
+        Analysis, AnalysisContext, FtlHeader, HashAssignment, MacroParameter, Symbol,
+        SymbolAnalysis,
+    },
     doc::TextDocument,
     utils,
 };
@@ -82,7 +86,7 @@ fn analyze_import_statement(
     let alias_node = import_node
         .child_by_field_name(Rule::ImportAlias.to_string())
         .unwrap();
-    let alias_range = utils::parser_node_to_document_range(&alias_node);
+    let alias_range = utils::parser_node_to_document_range(&alias_node, doc);
     let import_alias = doc.get_ranged_text(alias_node.start_byte()..alias_node.end_byte());
     analysis.add_symbol(
         &import_alias,
@@ -98,14 +102,13 @@ fn analyze_import_statement(
     let path_node = import_node
         .child_by_field_name(Rule::ImportPath.to_string())
         .unwrap();
-    let path_range = utils::parser_node_to_document_range(&path_node);
+    let path_range = utils::parser_node_to_document_range(&path_node, doc);
     // the tree-sitter parser had ensured the import_path is '"' quoted, so it is safe to slice like this [1..len()-1]
     let import_path_str = doc.get_ranged_text(path_node.start_byte() + 1..path_node.end_byte() - 1);
-    let import_path_buf = PathBuf::from(&import_path_str);
-    let canonicalize_import = match import_path_buf.is_absolute() {
-        true => import_path_buf.canonicalize(),
-        false => doc.dir().join(import_path_buf).canonicalize(),
-    };
+    if utils::is_dynamic_template_path(&import_path_str) {
+        return;
+    }
+    let canonicalize_import = utils::resolve_template_path(&doc.dir(), &import_path_str);
 
     match canonicalize_import {
         Ok(canonicalize_import_path) => {
@@ -118,6 +121,8 @@ fn analyze_import_statement(
             } else if doc.canonical_uri() == canonicalize_import_path {
                 // don't import yourself
                 analysis.add_diagnostic(ImportError::PATH_REF_SELF.build(path_range, None));
+            } else if let Some(uri) = Uri::from_file_path(&canonicalize_import_path) {
+                analysis.record_namespace(&import_alias, uri);
             }
             //
             let canonicalize_import_str = canonicalize_import_path.to_str().unwrap();
@@ -150,12 +155,55 @@ fn analyze_import_statement(
                     }]
                 });
         }
+        Err(err) if err.kind() == ErrorKind::NotFound => {
+            analysis.add_diagnostic(Diagnostic {
+                range: path_range,
+                message: format!("Cannot resolve template `{import_path_str}`"),
+                ..Scenario::UNRESOLVED_IMPORT.into()
+            });
+        }
         Err(_) => {
             analysis.add_diagnostic(ImportError::PATH_UNCANONICAL.build(path_range, None));
         }
     }
 }
 
+fn analyze_include_statement(
+    include_node: &Node,
+    doc: &TextDocument,
+    _: &mut AnalysisContext,
+    analysis: &mut Analysis,
+) {
+    let Some(path_node) = include_node.child_by_field_name(Rule::IncludePath.to_string()) else {
+        return;
+    };
+    // the include_path is '"' quoted as the import_path
+    let include_path_str =
+        doc.get_ranged_text(path_node.start_byte() + 1..path_node.end_byte() - 1);
+    if utils::is_dynamic_template_path(&include_path_str) {
+        return;
+    }
+    match utils::resolve_template_path(&doc.dir(), &include_path_str) {
+        Ok(include_path) => {
+            if include_path.is_file()
+                && doc.canonical_uri() != include_path
+                && let Some(uri) = Uri::from_file_path(&include_path)
+            {
+                analysis.record_valid_import(&include_path_str, uri.clone());
+                analysis.record_include(uri);
+            }
+        }
+        Err(err) if err.kind() == ErrorKind::NotFound => {
+            analysis.add_diagnostic(Diagnostic {
+                range: utils::parser_node_to_document_range(&path_node, doc),
+                message: format!("Cannot resolve template `{include_path_str}`"),
+                ..Scenario::UNRESOLVED_INCLUDE.into()
+            });
+        }
+        Err(_) => {}
+    }
+}
+
 fn analyze_macro_statement(
     macro_node: &Node,
     doc: &TextDocument,
@@ -166,19 +214,208 @@ fn analyze_macro_statement(
     let name_node = macro_node
         .child_by_field_name(Rule::MacroName.to_string())
         .unwrap();
-    let name_range = utils::parser_node_to_document_range(&name_node);
+    let name_range = utils::parser_node_to_document_range(&name_node, doc);
     let name_text = doc.get_ranged_text(name_node.start_byte()..name_node.end_byte());
-    analysis.add_symbol(
-        &name_text,
-        Symbol {
-            rule: Rule::MacroName,
-            start_byte: name_node.start_byte(),
-            end_byte: name_node.end_byte(),
-            range: name_range,
+    let symbol = Symbol {
+        rule: Rule::MacroName,
+        start_byte: name_node.start_byte(),
+        end_byte: name_node.end_byte(),
+        range: name_range,
+    };
+    analysis.add_symbol(&name_text, symbol);
+    analysis.add_macro_parameters(&symbol, macro_parameters(macro_node, doc));
+    let mut variables = vec![];
+    collect_nested_variables(macro_node, doc, &mut variables);
+    analysis.add_nested_variables(&symbol, variables);
+}
+
+fn collect_nested_variables(node: &Node, doc: &TextDocument, variables: &mut Vec<String>) {
+    let mut cursor = node.walk();
+    for child in node.named_children(&mut cursor) {
+        match Rule::from_str(child.kind()) {
+            Ok(Rule::NestedStmt) => {
+                let mut nested_cursor = child.walk();
+                for left in child
+                    .named_children(&mut nested_cursor)
+                    .filter(|parameter| parameter.kind() == Rule::AssignExpression.to_string())
+                    .filter_map(|parameter| parameter.child_by_field_name("left"))
+                {
+                    let name = doc.get_ranged_text(left.start_byte()..left.end_byte());
+                    if !variables.contains(&name) {
+                        variables.push(name);
+                    }
+                }
+            }
+            // the `<#nested>` of an inner macro belongs to the inner one
+            Ok(Rule::MacroStmt) => {}
+            _ => collect_nested_variables(&child, doc, variables),
+        }
+    }
+}
+
+fn macro_parameters(macro_node: &Node, doc: &TextDocument) -> Vec<MacroParameter> {
+    let mut parameters = vec![];
+    let mut cursor = macro_node.walk();
+    let Some(clause) = macro_node
+        .named_children(&mut cursor)
+        .find(|child| matches!(Rule::from_str(child.kind()), Ok(Rule::MacroClause)))
+    else {
+        return parameters;
+    };
+    let text_of = |node: &Node| doc.get_ranged_text(node.start_byte()..node.end_byte());
+    let mut clause_cursor = clause.walk();
+    for child in clause.named_children(&mut clause_cursor) {
+        match Rule::from_str(child.kind()) {
+            Ok(Rule::Identifier) => parameters.push(MacroParameter {
+                name: text_of(&child),
+                default: None,
+            }),
+            Ok(Rule::AssignExpression) => {
+                if let Some(left) = child.child_by_field_name("left") {
+                    parameters.push(MacroParameter {
+                        name: text_of(&left),
+                        default: child
+                            .child_by_field_name("right")
+                            .map(|right| text_of(&right)),
+                    });
+                }
+            }
+            // the macro body begins
+            Ok(Rule::MacroCloseTag) => break,
+            _ => {}
+        }
+    }
+    parameters
+}
+
+fn analyze_function_statement(
+    function_node: &Node,
+    doc: &TextDocument,
+    _: &mut AnalysisContext,
+    analysis: &mut Analysis,
+) {
+    let mut cursor = function_node.walk();
+    let name_node = function_node
+        .named_children(&mut cursor)
+        .find(|child| matches!(Rule::from_str(child.kind()), Ok(Rule::FunctionClause)))
+        .and_then(|clause| clause.child_by_field_name("name"));
+    if let Some(name_node) = name_node {
+        let name_text = doc.get_ranged_text(name_node.start_byte()..name_node.end_byte());
+        analysis.add_symbol(
+            &name_text,
+            Symbol {
+                rule: Rule::FunctionName,
+                start_byte: name_node.start_byte(),
+                end_byte: name_node.end_byte(),
+                range: utils::parser_node_to_document_range(&name_node, doc),
+            },
+        );
+    }
+}
+
+fn hash_literal_keys(object_node: &Node, doc: &TextDocument) -> Vec<String> {
+    let mut cursor = object_node.walk();
+    object_node
+        .named_children(&mut cursor)
+        .filter_map(|pair| pair.child_by_field_name("key"))
+        .map(|key| {
+            let key_text = doc.get_ranged_text(key.start_byte()..key.end_byte());
+            match Rule::from_str(key.kind()) {
+                Ok(Rule::StringLiteral) => key_text.trim_matches(['"', '\'']).to_owned(),
+                _ => key_text,
+            }
+        })
+        .collect()
+}
+
+fn analyze_assignment(
+    node: &Node,
+    doc: &TextDocument,
+    _: &mut AnalysisContext,
+    analysis: &mut Analysis,
+) {
+    let name_node = match Rule::from_str(node.kind()) {
+        Ok(Rule::AssignExpression)
+            if node.parent().is_some_and(|parent| {
+                matches!(
+                    Rule::from_str(parent.kind()),
+                    Ok(Rule::AssignInline | Rule::GlobalInline | Rule::LocalInline)
+                )
+            }) =>
+        {
+            node.child_by_field_name("left")
+        }
+        // the capture forms always assign a string
+        Ok(Rule::AssignClause | Rule::GlobalClause | Rule::LocalClause) => {
+            node.child_by_field_name("into")
+        }
+        _ => None,
+    };
+    let Some(name_node) = name_node else {
+        return;
+    };
+    let keys = node
+        .child_by_field_name("right")
+        .filter(|right| matches!(Rule::from_str(right.kind()), Ok(Rule::Object)))
+        .map(|object_node| hash_literal_keys(&object_node, doc));
+    analysis.add_hash_assignment(
+        &doc.get_ranged_text(name_node.start_byte()..name_node.end_byte()),
+        HashAssignment {
+            start_byte: node.start_byte(),
+            keys,
         },
     );
 }
 
+fn analyze_ftl_statement(
+    ftl_node: &Node,
+    doc: &TextDocument,
+    _: &mut AnalysisContext,
+    analysis: &mut Analysis,
+) {
+    // only the first header takes effect, the misplaced ones are reported as errors
+    if analysis.get_ftl_header().is_some() {
+        return;
+    }
+    let mut header = FtlHeader {
+        range: utils::parser_node_to_document_range(ftl_node, doc),
+        ..Default::default()
+    };
+    let mut cursor = ftl_node.walk();
+    for parameter in ftl_node.named_children(&mut cursor) {
+        let (Some(name_node), Some(value_node)) = (
+            parameter.child_by_field_name("name"),
+            parameter.child_by_field_name("value"),
+        ) else {
+            continue;
+        };
+        let name = doc.get_ranged_text(name_node.start_byte()..name_node.end_byte());
+        let value = doc.get_ranged_text(value_node.start_byte()..value_node.end_byte());
+        // both snake_case and camelCase are accepted
+        match name.as_str() {
+            "output_format" | "outputFormat" => {
+                header.output_format = Some(value.trim_matches(['"', '\'']).to_owned());
+            }
+            "strip_whitespace" | "stripWhitespace" => {
+                header.strip_whitespace = match Rule::from_str(value_node.kind()) {
+                    Ok(Rule::BooleanTrue) => Some(true),
+                    Ok(Rule::BooleanFalse) => Some(false),
+                    _ => None,
+                };
+            }
+            "auto_esc" | "autoEsc" => {
+                header.auto_esc = match Rule::from_str(value_node.kind()) {
+                    Ok(Rule::BooleanTrue) => Some(true),
+                    Ok(Rule::BooleanFalse) => Some(false),
+                    _ => None,
+                };
+            }
+            _ => {}
+        }
+    }
+    analysis.set_ftl_header(header);
+}
+
 impl SymbolAnalysis for Analysis {
     fn analyze_syntatic_symbols(
         &mut self,
@@ -191,12 +428,27 @@ impl SymbolAnalysis for Analysis {
             return;
         }
         match rule.unwrap() {
+            Rule::FtlStmt => {
+                analyze_ftl_statement(node, doc, ctx, self);
+            }
             Rule::ImportStmt => {
                 analyze_import_statement(node, doc, ctx, self);
             }
+            Rule::IncludeStmt => {
+                analyze_include_statement(node, doc, ctx, self);
+            }
             Rule::MacroStmt => {
                 analyze_macro_statement(node, doc, ctx, self);
             }
+            Rule::FunctionStmt => {
+                analyze_function_statement(node, doc, ctx, self);
+            }
+            Rule::AssignExpression
+            | Rule::AssignClause
+            | Rule::GlobalClause
+            | Rule::LocalClause => {
+                analyze_assignment(node, doc, ctx, self);
+            }
             _ => {}
         }
     }
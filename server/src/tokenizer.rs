@@ -32,7 +32,6 @@ use crate::{
 #[derive(Debug, EnumIter, PartialEq, Clone, Copy)]
 enum TokenType {
     Boolean,
-    Call,
     Comment,
     Decorator, // normally, it will set "fontStyle" to "italic"
     Function,
@@ -51,7 +50,6 @@ impl From<TokenType> for SemanticTokenType {
         // (see also https://code.visualstudio.com/api/language-extensions/semantic-highlight-guide#standard-token-types-and-modifiers)
         match val {
             TokenType::Boolean => SemanticTokenType::VARIABLE,
-            TokenType::Call => SemanticTokenType::INTERFACE,
             TokenType::Comment => SemanticTokenType::COMMENT,
             TokenType::Decorator => SemanticTokenType::DECORATOR,
             TokenType::Function => SemanticTokenType::FUNCTION,
@@ -105,23 +103,37 @@ fn tokenize_from(node: &Node<'_>) -> Option<Token> {
     match Rule::from_str(kind) {
         Ok(rule) => match rule {
             Rule::Comment => Some(Token(TokenType::Comment, range, None)),
-            Rule::FunctionBegin | Rule::FunctionClose => {
-                Some(Token(TokenType::Keyword, range, None))
-            }
-            Rule::FunctionName | Rule::BuiltinName | Rule::MacroName => {
-                Some(Token(TokenType::Call, range, None))
+            Rule::BuiltinName | Rule::MacroName => Some(Token(TokenType::Function, range, None)),
+            // the function name of a call expression is highlighted by its identifier
+            Rule::FunctionName if node.child_count() == 0 => {
+                Some(Token(TokenType::Function, range, None))
             }
+            Rule::Identifier => match node.parent().map(|parent| Rule::from_str(parent.kind())) {
+                // e.g. "foo" of "<@ns.foo/>" or "${foo()}"
+                Some(Ok(Rule::MacroSpecs | Rule::FunctionName)) => {
+                    Some(Token(TokenType::Function, range, None))
+                }
+                _ => Some(Token(TokenType::Variable, range, None)),
+            },
             Rule::KeywordAs
+            | Rule::FunctionBegin
+            | Rule::FunctionClose
+            | Rule::MacroBegin
+            | Rule::MacroCloseTag
+            | Rule::MacroClose
             | Rule::AssignBegin
             | Rule::AssignClose
             | Rule::LocalBegin
             | Rule::LocalClose
+            | Rule::GlobalBegin
+            | Rule::GlobalClose
             | Rule::FtlBegin
             | Rule::IfBegin
             | Rule::ElseBegin
             | Rule::ElseifBegin
             | Rule::IfClose
             | Rule::ImportBegin
+            | Rule::IncludeBegin
             | Rule::CloseTag
             | Rule::ListBegin
             | Rule::ListClose
@@ -133,18 +145,50 @@ fn tokenize_from(node: &Node<'_>) -> Option<Token> {
             | Rule::OnBegin
             | Rule::CaseBegin
             | Rule::DefaultBegin
+            | Rule::OutputformatBegin
+            | Rule::OutputformatClose
+            | Rule::AutoescBegin
+            | Rule::AutoescClose
+            | Rule::NoautoescBegin
+            | Rule::NoautoescClose
+            | Rule::NestedBegin
             | Rule::ReturnBegin => Some(Token(TokenType::Keyword, range, None)),
             Rule::UndocumentedCloseTag => Some(Token(TokenType::Keyword, range, Some(DEPRECATED))),
-            Rule::MacroBegin
-            | Rule::MacroCloseTag
-            | Rule::MacroClose
-            | Rule::MacroCallBegin
-            | Rule::MacroCallEnd
-            | Rule::InterpolationPrepend => Some(Token(TokenType::Macro, range, None)),
-            Rule::ImportAlias | Rule::MacroNamespace => {
-                Some(Token(TokenType::Namespace, range, None))
+            Rule::MacroCallBegin | Rule::MacroCallEnd | Rule::MacroCallClose => {
+                Some(Token(TokenType::Macro, range, None))
             }
+            Rule::InterpolationPrepend => {
+                // "${" as a whole, so that it is distinct from the text around
+                if node
+                    .parent()
+                    .is_some_and(|parent| parent.kind() == Rule::NumericInterpolation.to_string())
+                {
+                    // "#{" is a single token already
+                    return Some(Token(TokenType::Macro, range, Some(DEPRECATED)));
+                }
+                let mut range = range;
+                if let Some(brace) = node.next_sibling()
+                    && brace.kind() == "{"
+                {
+                    range.end_byte = brace.end_byte();
+                    range.end_point = brace.end_position();
+                }
+                Some(Token(TokenType::Macro, range, None))
+            }
+            Rule::MacroNamespace => {
+                // "<@foo/>" calls a local macro, while "<@ns.foo/>" calls one of namespace "ns"
+                let is_local_call = node.next_named_sibling().is_none_or(|sibling| {
+                    sibling.kind() != Rule::MacroSpecs.to_string()
+                        || sibling.named_child_count() == 0
+                });
+                match is_local_call {
+                    true => Some(Token(TokenType::Function, range, None)),
+                    false => Some(Token(TokenType::Namespace, range, None)),
+                }
+            }
+            Rule::ImportAlias => Some(Token(TokenType::Namespace, range, None)),
             Rule::Number => Some(Token(TokenType::Number, range, None)),
+            Rule::NumericFormat => Some(Token(TokenType::String, range, None)),
             Rule::EqualOperator
             | Rule::AssignOperator
             | Rule::BinaryOperator
@@ -155,13 +199,13 @@ fn tokenize_from(node: &Node<'_>) -> Option<Token> {
             Rule::DeprecatedEqualOperator => {
                 Some(Token(TokenType::Operator, range, Some(DEPRECATED)))
             }
-            Rule::ParameterName => Some(Token(TokenType::Parameter, range, None)),
-            Rule::Variable | Rule::Identifier | Rule::MacroSpecs => {
-                Some(Token(TokenType::Variable, range, None))
-            }
-            Rule::StringLiteral | Rule::ImportPath | Rule::AmbiguousStringLiteral => {
-                Some(Token(TokenType::String, range, None))
+            Rule::FtlSetting | Rule::ParameterName => {
+                Some(Token(TokenType::Parameter, range, None))
             }
+            Rule::StringLiteral
+            | Rule::ImportPath
+            | Rule::IncludePath
+            | Rule::AmbiguousStringLiteral => Some(Token(TokenType::String, range, None)),
             Rule::BooleanTrue | Rule::BooleanFalse => {
                 Some(Token(TokenType::Boolean, range, Some(READONLY)))
             }
@@ -174,7 +218,25 @@ fn tokenize_from(node: &Node<'_>) -> Option<Token> {
                 None
             }
         },
-        Err(_unknown) => None,
+        Err(_unknown) => match node.parent() {
+            // the closing "}" of "${...}"
+            Some(parent)
+                if !node.is_named()
+                    && parent.kind() == Rule::Interpolation.to_string()
+                    && node.next_sibling().is_none() =>
+            {
+                Some(Token(TokenType::Macro, range, None))
+            }
+            // the closing "}" of "#{...}"
+            Some(parent)
+                if !node.is_named()
+                    && parent.kind() == Rule::NumericInterpolation.to_string()
+                    && node.next_sibling().is_none() =>
+            {
+                Some(Token(TokenType::Macro, range, Some(DEPRECATED)))
+            }
+            _ => None,
+        },
     }
 }
 
@@ -235,22 +297,28 @@ impl HighlightAnalysis for Analysis {
             return;
         }
         let mut semantic_tokens = vec![];
+        // the columns of the tokens are counted in the negotiated position encoding
+        let to_character = |point: Point| Point {
+            row: point.row,
+            column: doc.byte_column_to_character(point.row, point.column) as usize,
+        };
         if let Some(token) = tokenize_from(node) {
             let Token(token_type, range, modifiers) = token;
             if range.end_point.row == range.start_point.row {
                 // single-line token
+                let start = to_character(range.start_point);
                 semantic_tokens.push(encode_semantic_token(
                     &ctx.prev_start,
                     token_type,
-                    &range.start_point,
-                    range.end_byte - range.start_byte,
+                    &start,
+                    to_character(range.end_point).column - start.column,
                     modifiers,
                 ));
-                ctx.prev_start = range.start_point;
+                ctx.prev_start = start;
             } else {
                 // multi-line token is not allowed, so split which into multiple inline tokens
                 // token of 1st line
-                let first_start = range.start_point;
+                let first_start = to_character(range.start_point);
                 let first_line_len = doc.line_len(first_start.row).unwrap();
                 semantic_tokens.push(encode_semantic_token(
                     &ctx.prev_start,
@@ -287,7 +355,7 @@ impl HighlightAnalysis for Analysis {
                     &ctx.prev_start,
                     token_type,
                     &last_start,
-                    range.end_point.column,
+                    to_character(range.end_point).column,
                     modifiers,
                 ));
                 ctx.prev_start = last_start;
@@ -309,3 +377,59 @@ impl SemanticTokenFeature for Reactor {
         })))
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::Uri;
+
+    use crate::{reactor::Reactor, tokenizer::TokenType};
+
+    fn tokens(text: &str) -> Vec<(u32, u32, u32, u32)> {
+        let reactor = Reactor::new(&Uri::from_str("file:///test.ftl").unwrap(), text, 0);
+        reactor
+            .get_analysis()
+            .get_analyzed_semantic_tokens()
+            .into_iter()
+            .map(|token| {
+                (
+                    token.delta_line,
+                    token.delta_start,
+                    token.length,
+                    token.token_type,
+                )
+            })
+            .collect()
+    }
+
+    #[test]
+    fn test_interpolation_tokens() {
+        assert_eq!(
+            tokens("${x}"),
+            vec![
+                (0, 0, 2, TokenType::Macro as u32),
+                (0, 2, 1, TokenType::Variable as u32),
+                (0, 1, 1, TokenType::Macro as u32),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_tokens_after_multi_byte_characters() {
+        // the columns are counted in UTF-16 code units, "😀" takes two of them
+        assert_eq!(
+            tokens("日本😀 ${x}\n<#if ok>é</#if>"),
+            vec![
+                (0, 5, 2, TokenType::Macro as u32),
+                (0, 2, 1, TokenType::Variable as u32),
+                (0, 1, 1, TokenType::Macro as u32),
+                (1, 0, 4, TokenType::Keyword as u32),
+                (0, 5, 2, TokenType::Variable as u32),
+                (0, 2, 1, TokenType::Keyword as u32),
+                // "é" takes a single code unit but two bytes
+                (0, 2, 6, TokenType::Keyword as u32),
+            ]
+        );
+    }
+}
@@ -0,0 +1,87 @@
+// Copyright 2025-2026 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+use std::time::Instant;
+
+use serde::Serialize;
+use tower_lsp_server::{
+    jsonrpc,
+    ls_types::{LogTraceParams, TraceValue, notification::LogTrace},
+};
+
+use crate::client;
+
+/// Sends `$/logTrace` per the trace value, the verbose part (e.g. the parameters, which might
+/// hold the whole document) is only made for "verbose"
+async fn log_trace<F>(message: String, verbose: F)
+where
+    F: FnOnce() -> String,
+{
+    let verbose = match client::trace_value() {
+        TraceValue::Off => return,
+        TraceValue::Messages => None,
+        TraceValue::Verbose => Some(verbose()),
+    };
+    if let Some(c) = client::get_client() {
+        c.send_notification::<LogTrace>(LogTraceParams { message, verbose })
+            .await;
+    }
+}
+
+fn to_json<T: Serialize>(value: &T) -> String {
+    serde_json::to_string_pretty(value).unwrap_or_default()
+}
+
+pub async fn notification<P: Serialize>(method: &str, params: &P) {
+    log_trace(format!("Received notification '{}'.", method), || {
+        format!("Params: {}", to_json(params))
+    })
+    .await;
+}
+
+/// A request being processed, which is traced when received and when responded
+pub struct RequestTrace {
+    method: &'static str,
+    start: Instant,
+}
+
+impl RequestTrace {
+    pub async fn received<P: Serialize>(method: &'static str, params: &P) -> Self {
+        log_trace(format!("Received request '{}'.", method), || {
+            format!("Params: {}", to_json(params))
+        })
+        .await;
+        RequestTrace {
+            method,
+            start: Instant::now(),
+        }
+    }
+
+    pub async fn responded<R: Serialize>(self, result: jsonrpc::Result<R>) -> jsonrpc::Result<R> {
+        let elapsed = self.start.elapsed().as_millis();
+        match &result {
+            Ok(value) => {
+                log_trace(
+                    format!(
+                        "Sending response '{}'. Processing request took {}ms",
+                        self.method, elapsed
+                    ),
+                    || format!("Result: {}", to_json(value)),
+                )
+                .await
+            }
+            Err(e) => {
+                log_trace(
+                    format!(
+                        "Sending error response '{}'. Processing request took {}ms",
+                        self.method, elapsed
+                    ),
+                    || format!("Error: {}", e),
+                )
+                .await
+            }
+        }
+        result
+    }
+}
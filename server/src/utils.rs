@@ -2,31 +2,53 @@
 // Licensed under the BSD 3-Clause License.
 // SPDX-License-Identifier: BSD-3-Clause
 
+use std::path::{Path, PathBuf};
+
 use tower_lsp_server::ls_types::{LanguageString, Position, Range};
 use tree_sitter::{Node, Point};
 
-pub fn parser_node_to_document_range(node: &Node) -> Range {
+use crate::{config, doc::TextDocument};
+
+/// Returns the range of the node, the byte columns of tree-sitter are converted to the
+/// characters of the negotiated position encoding
+pub fn parser_node_to_document_range(node: &Node, doc: &TextDocument) -> Range {
     let start = node.start_position();
     let end = node.end_position();
     Range {
         start: Position {
             line: start.row as u32,
-            character: start.column as u32,
+            character: doc.byte_column_to_character(start.row, start.column),
         },
         end: Position {
             line: end.row as u32,
-            character: end.column as u32,
+            character: doc.byte_column_to_character(end.row, end.column),
         },
     }
 }
 
-pub fn lsp_position_to_parser_point(position: &Position) -> Point {
+/// Returns the tree-sitter point of the position, whose column is a byte offset in the line
+pub fn lsp_position_to_parser_point(position: &Position, doc: &TextDocument) -> Point {
     Point {
         row: position.line as usize,
-        column: position.character as usize,
+        column: doc
+            .character_to_byte_column(position.line as usize, position.character)
+            .unwrap_or(position.character as usize),
     }
 }
 
+/// Returns the directive name of a begin/close tag, e.g. "list" for "<#list",
+/// "</#list>", "[#list" or "[/#list]".
+pub fn directive_name(tag_text: &str) -> &str {
+    tag_text
+        .trim_start_matches(['<', '[', '/', '#'])
+        .trim_end_matches(['>', ']'])
+}
+
+/// Returns the indentation of the line
+pub fn leading_whitespace(line: &str) -> &str {
+    &line[..line.len() - line.trim_start_matches([' ', '\t']).len()]
+}
+
 pub fn ftl_to_rust(ftl_text: &str) -> LanguageString {
     // for highlighting in hover
     let line_trimmed = ftl_text.trim();
@@ -42,3 +64,42 @@ pub fn ftl_to_rust(ftl_text: &str) -> LanguageString {
         value: result,
     }
 }
+
+// characters that can never be a part of an unescaped identifier, see `identifier` in grammar.js
+const NON_IDENTIFIER_CHARS: &str =
+    ":;`\"'@#.,|^&<=>+-*/\\%?!~()[]{}\u{FEFF}\u{2060}\u{200B}\u{2028}\u{2029}";
+// names that are parsed as keywords or literals instead of identifiers
+const RESERVED_NAMES: [&str; 4] = ["as", "false", "true", "using"];
+
+/// Returns true if the name can be written as a FreeMarker identifier without escaping
+pub fn is_valid_identifier(name: &str) -> bool {
+    let mut chars = name.chars();
+    let Some(first) = chars.next() else {
+        return false;
+    };
+    let is_identifier_char =
+        |c: char| !c.is_control() && !c.is_whitespace() && !NON_IDENTIFIER_CHARS.contains(c);
+    is_identifier_char(first)
+        && !first.is_ascii_digit()
+        && chars.all(is_identifier_char)
+        && !RESERVED_NAMES.contains(&name)
+}
+
+/// Resolves the path of `<#import>` or `<#include>`, a relative path is relative to the directory
+/// of the current template, while an absolute one is looked up in the template roots first.
+pub fn resolve_template_path(dir: &Path, path: &str) -> std::io::Result<PathBuf> {
+    let path_buf = PathBuf::from(path);
+    if !path_buf.is_absolute() {
+        return dir.join(path_buf).canonicalize();
+    }
+    config::template_roots()
+        .iter()
+        .find_map(|root| root.join(path.trim_start_matches('/')).canonicalize().ok())
+        .map_or_else(|| path_buf.canonicalize(), Ok)
+}
+
+/// Whether the path of `<#import>` or `<#include>` is only known at runtime, e.g.
+/// `<#include "${theme}/footer.ftl">`
+pub fn is_dynamic_template_path(path: &str) -> bool {
+    path.contains("${") || path.contains("#{")
+}
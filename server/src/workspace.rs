@@ -3,42 +3,105 @@
 // SPDX-License-Identifier: BSD-3-Clause
 
 use crate::{
+    client, completion, config, diagnosis,
+    indexer::{self, SymbolIndex},
+    lens::{self, LensTarget, ReferenceCache},
+    link,
     reactor::Reactor,
     server::{
-        ActionFeature, CompletionFeature, DiagnosticFeature, FoldingFeature, FormatFeature,
-        GotoFeature, HoverFeature, SemanticTokenFeature,
+        ActionFeature, CodeLensFeature, CompletionFeature, DiagnosticFeature,
+        DocumentHighlightFeature, FoldingFeature, FormatFeature, GotoFeature, HoverFeature,
+        InlayHintFeature, LinkFeature, LinkedEditingFeature, OutlineFeature, ReferenceFeature,
+        RenameFeature, SelectionFeature, SemanticTokenFeature, SignatureFeature,
     },
-    window_log_info,
+    window_log_info, window_log_warn,
 };
 
-use std::{collections::HashMap, str::FromStr, sync::Arc};
+use std::{
+    collections::HashMap,
+    path::PathBuf,
+    str::FromStr,
+    sync::{
+        Arc, Mutex,
+        atomic::{AtomicU64, Ordering},
+    },
+    time::Duration,
+};
 use tokio::sync::RwLock;
 use tower_lsp_server::{
     jsonrpc,
     ls_types::{
-        CodeActionOrCommand, CodeActionParams, CompletionParams, CompletionResponse,
-        DeleteFilesParams, DidChangeTextDocumentParams, DidChangeWatchedFilesParams,
-        DidOpenTextDocumentParams, DocumentDiagnosticParams, DocumentDiagnosticReportResult,
-        DocumentFormattingParams, FileChangeType, FoldingRange, FoldingRangeParams,
-        GotoDefinitionParams, GotoDefinitionResponse, Hover, HoverParams, SemanticTokensParams,
-        SemanticTokensResult, TextDocumentContentChangeEvent, TextEdit, Uri,
+        CodeActionOrCommand, CodeActionParams, CodeLens, CodeLensParams, CompletionItem,
+        CompletionParams, CompletionResponse, DeleteFilesParams, DidChangeConfigurationParams,
+        DidChangeTextDocumentParams, DidChangeWatchedFilesParams, DidOpenTextDocumentParams,
+        DidSaveTextDocumentParams, DocumentDiagnosticParams, DocumentDiagnosticReportResult,
+        DocumentFormattingParams, DocumentHighlight, DocumentHighlightParams, DocumentLink,
+        DocumentLinkParams, DocumentOnTypeFormattingParams, DocumentRangeFormattingParams,
+        DocumentSymbolParams, DocumentSymbolResponse, FileChangeType, FoldingRange,
+        FoldingRangeParams, GotoDefinitionParams, GotoDefinitionResponse, Hover, HoverParams,
+        InlayHint, InlayHintParams, LinkedEditingRangeParams, LinkedEditingRanges, Location,
+        MessageType, PrepareRenameResponse, ReferenceParams, RenameParams, SelectionRange,
+        SelectionRangeParams, SemanticTokensParams, SemanticTokensResult, SignatureHelp,
+        SignatureHelpParams, TextDocumentContentChangeEvent, TextDocumentPositionParams, TextEdit,
+        Uri, WorkspaceDiagnosticParams, WorkspaceDiagnosticReportResult, WorkspaceEdit,
+        WorkspaceSymbolParams, WorkspaceSymbolResponse,
     },
 };
 
-#[derive(Debug)]
+// the handles are shared, so that the background tasks work on the same workspace
+#[derive(Clone, Debug)]
 pub struct Workspace {
     reactors: Arc<RwLock<HashMap<Uri, Reactor>>>,
+    symbol_index: Arc<RwLock<SymbolIndex>>,
+    reference_cache: Arc<RwLock<ReferenceCache>>,
+    // the latest change of each watched file not applied yet
+    pending_file_changes: Arc<Mutex<HashMap<Uri, FileChangeType>>>,
+    file_changes_generation: Arc<AtomicU64>,
 }
 
 const GET_REACTOR_EXPECT: &str = "get reactor via uri should always succeed";
+// the quiet period before applying the changes of watched files, e.g. after a checkout
+const FILE_CHANGES_DEBOUNCE: Duration = Duration::from_millis(300);
+
+fn oversized_message(uri: &Uri) -> String {
+    format!(
+        "{} is larger than {} bytes, only syntax highlighting is provided",
+        uri.to_string(),
+        config::max_file_size()
+    )
+}
+
+/// Tells the user that the template is only highlighted, since it exceeds `maxFileSizeBytes`
+async fn show_oversized_message(uri: &Uri) {
+    if let Some(client) = client::get_client() {
+        client
+            .show_message(MessageType::WARNING, oversized_message(uri))
+            .await;
+    }
+}
 
 impl Workspace {
     pub fn new() -> Self {
         Self {
             reactors: Arc::new(RwLock::new(HashMap::new())),
+            symbol_index: Arc::new(RwLock::new(SymbolIndex::default())),
+            reference_cache: Arc::new(RwLock::new(ReferenceCache::default())),
+            pending_file_changes: Arc::new(Mutex::new(HashMap::new())),
+            file_changes_generation: Arc::new(AtomicU64::new(0)),
         }
     }
 
+    /// Indexes the templates of the workspace folders in background
+    pub fn index_workspace(&self, roots: Vec<PathBuf>) {
+        let symbol_index = self.symbol_index.clone();
+        tokio::spawn(async move {
+            match tokio::task::spawn_blocking(move || indexer::index_workspace(&roots)).await {
+                Ok(index) => symbol_index.write().await.merge(index),
+                Err(e) => tracing::error!("workspace indexing failed: {}", e),
+            }
+        });
+    }
+
     pub async fn on_did_open(&self, params: &DidOpenTextDocumentParams) {
         let uri: &Uri = &params.text_document.uri;
         window_log_info!(format!("on_did_open: {:?}", uri.to_string()));
@@ -51,7 +114,16 @@ impl Workspace {
         } {
             let source_code = params.text_document.text.as_str();
             let reactor = Reactor::new(uri, source_code, version);
+            let is_oversized = reactor.is_oversized();
+            self.symbol_index
+                .write()
+                .await
+                .update(reactor.get_document(), reactor.get_parser());
             write_guard.insert(uri.clone(), reactor);
+            if is_oversized {
+                drop(write_guard);
+                show_oversized_message(uri).await;
+            }
         }
     }
 
@@ -60,38 +132,139 @@ impl Workspace {
         let version = params.text_document.version;
         tracing::debug!("on_did_change: {}", uri.to_string());
         for change_event in &params.content_changes {
-            // assume only changes
-            if let Some(range) = change_event.range {
-                tracing::debug!("range: {:?}", range);
-                self.update_file(uri, version, change_event).await;
-            } else {
-                tracing::debug!("full text change");
+            match change_event.range {
+                Some(range) => tracing::debug!("range: {:?}", range),
+                None => tracing::debug!("full text change"),
             }
+            self.update_file(uri, version, change_event).await;
         }
     }
 
     async fn update_file(&self, uri: &Uri, version: i32, change: &TextDocumentContentChangeEvent) {
+        let position_encoding = config::position_encoding();
         let mut write_guard = self.reactors.write().await;
+        let mut became_oversized = false;
         if let Some(reactor) = write_guard.get_mut(uri) {
             tracing::debug!("previous file version: {}", reactor.version);
-            reactor.apply_content_change(version, change);
+            let was_oversized = reactor.is_oversized();
+            reactor.apply_content_change(version, change, position_encoding);
+            self.symbol_index
+                .write()
+                .await
+                .update(reactor.get_document(), reactor.get_parser());
+            became_oversized = !was_oversized && reactor.is_oversized();
+        }
+        drop(write_guard);
+        if became_oversized {
+            show_oversized_message(uri).await;
+        }
+    }
+
+    pub async fn on_did_save(&self, params: &DidSaveTextDocumentParams) {
+        let uri = &params.text_document.uri;
+        tracing::debug!("on_did_save: {}", uri.to_string());
+        let read_guard = self.reactors.read().await;
+        match read_guard.get(uri) {
+            Some(reactor) => self
+                .symbol_index
+                .write()
+                .await
+                .update(reactor.get_document(), reactor.get_parser()),
+            None => {
+                if let Some(path) = uri.to_file_path() {
+                    self.symbol_index.write().await.update_file(&path);
+                }
+            }
         }
     }
 
     pub async fn on_did_change_watched_files(&self, params: DidChangeWatchedFilesParams) {
         let DidChangeWatchedFilesParams { changes } = params;
-        // filter delete events
-        let uris: Vec<_> = changes
-            .into_iter()
-            .filter_map(|ev| match ev.typ == FileChangeType::DELETED {
-                true => Some(ev.uri),
-                false => None,
-            })
-            .collect();
-        // remove those files from the registry
-        for uri in uris {
-            window_log_info!(format!("did change(delete) file: {}", uri.to_string()));
-            self.reactors.write().await.remove(&uri);
+        if let Ok(mut pending) = self.pending_file_changes.lock() {
+            for event in changes {
+                pending.insert(event.uri, event.typ);
+            }
+        }
+        // a burst of changes is applied at once, when no more changes come
+        let generation = self.file_changes_generation.fetch_add(1, Ordering::SeqCst) + 1;
+        let workspace = self.clone();
+        tokio::spawn(async move {
+            tokio::time::sleep(FILE_CHANGES_DEBOUNCE).await;
+            if workspace.file_changes_generation.load(Ordering::SeqCst) == generation {
+                workspace.apply_file_changes().await;
+            }
+        });
+    }
+
+    async fn apply_file_changes(&self) {
+        let changes: Vec<_> = match self.pending_file_changes.lock() {
+            Ok(mut pending) => pending.drain().collect(),
+            Err(_) => return,
+        };
+        let mut templates_changed = false;
+        for (uri, change_type) in changes {
+            templates_changed |= change_type != FileChangeType::CHANGED;
+            if change_type == FileChangeType::DELETED {
+                window_log_info!(format!("did change(delete) file: {}", uri.to_string()));
+                self.reactors.write().await.remove(&uri);
+                self.symbol_index.write().await.remove(&uri);
+                self.reference_cache.write().await.remove(&uri);
+                continue;
+            }
+            // the open documents are indexed with the content of the editor
+            if self.reactors.read().await.contains_key(&uri) {
+                continue;
+            }
+            if let Some(path) = uri.to_file_path() {
+                tracing::debug!("did change(create or change) file: {}", uri.to_string());
+                self.symbol_index.write().await.update_file(&path);
+            }
+        }
+        // the imports and includes of the open templates might resolve differently now
+        if templates_changed {
+            for reactor in self.reactors.write().await.values_mut() {
+                reactor.reanalyze();
+            }
+        }
+        // the imports and includes of the other templates might resolve differently now
+        if let Some(client) = client::get_client() {
+            let _ = client.workspace_diagnostic_refresh().await;
+        }
+    }
+
+    pub async fn on_did_change_configuration(&self, params: DidChangeConfigurationParams) {
+        if let Some(level) = config::log_level_of(&params.settings)
+            && !client::set_log_level(level)
+        {
+            window_log_warn!(format!("unknown log level: {}", level));
+        }
+        if let Some(size) = config::max_file_size_of(&params.settings) {
+            config::set_max_file_size(size);
+        }
+        if let Some(millis) = config::parse_timeout_of(&params.settings) {
+            config::set_parse_timeout(millis);
+        }
+        if !config::apply_settings(&params.settings) {
+            return;
+        }
+        window_log_info!(format!(
+            "template roots changed: {:?}",
+            config::template_roots()
+        ));
+        // the links, namespaces and includes of every template might resolve differently now
+        let mut write_guard = self.reactors.write().await;
+        let mut symbol_index = self.symbol_index.write().await;
+        for reactor in write_guard.values_mut() {
+            reactor.reanalyze();
+            symbol_index.update(reactor.get_document(), reactor.get_parser());
+        }
+        // the closed templates are indexed again, the open ones are kept as they are merged
+        symbol_index.retain(|uri| write_guard.contains_key(uri));
+        drop(symbol_index);
+        drop(write_guard);
+        self.index_workspace(config::workspace_folders());
+        if let Some(client) = client::get_client() {
+            let _ = client.workspace_diagnostic_refresh().await;
         }
     }
 
@@ -100,6 +273,8 @@ impl Workspace {
             let uri = Uri::from_str(&file_deletion.uri).unwrap();
             window_log_info!(format!("did delete file: {}", uri.to_string()));
             self.reactors.write().await.remove(&uri);
+            self.symbol_index.write().await.remove(&uri);
+            self.reference_cache.write().await.remove(&uri);
         }
     }
 
@@ -111,7 +286,21 @@ impl Workspace {
         let uri = &params.text_document.uri;
         let read_guard = self.reactors.read().await;
         let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
-        reactor.on_diagnostic(params).await
+        let index_generation = self.symbol_index.read().await.generation();
+        reactor
+            .on_diagnostic(params, index_generation, &read_guard)
+            .await
+    }
+
+    pub async fn on_workspace_diagnostic(
+        &self,
+        params: WorkspaceDiagnosticParams,
+    ) -> jsonrpc::Result<WorkspaceDiagnosticReportResult> {
+        let read_guard = self.reactors.read().await;
+        let index_generation = self.symbol_index.read().await.generation();
+        Ok(WorkspaceDiagnosticReportResult::Report(
+            diagnosis::workspace_diagnostic_report(&read_guard, &params, index_generation),
+        ))
     }
 
     pub async fn on_semantic_tokens_full(
@@ -125,10 +314,40 @@ impl Workspace {
         reactor.on_semantic_tokens_full(params).await
     }
 
+    pub async fn on_document_highlight(
+        &self,
+        params: DocumentHighlightParams,
+    ) -> jsonrpc::Result<Option<Vec<DocumentHighlight>>> {
+        let uri = &params.text_document_position_params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        reactor.on_document_highlight(params).await
+    }
+
+    pub async fn on_document_link(
+        &self,
+        params: DocumentLinkParams,
+    ) -> jsonrpc::Result<Option<Vec<DocumentLink>>> {
+        let uri = &params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        reactor.on_document_link(params).await
+    }
+
+    pub async fn on_document_link_resolve(
+        &self,
+        params: DocumentLink,
+    ) -> jsonrpc::Result<DocumentLink> {
+        Ok(link::resolve_document_link(params))
+    }
+
     pub async fn on_hover(&self, params: HoverParams) -> jsonrpc::Result<Option<Hover>> {
         let uri = &params.text_document_position_params.text_document.uri;
         let read_guard = self.reactors.read().await;
         let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
         reactor.on_hover(params).await
     }
 
@@ -139,9 +358,37 @@ impl Workspace {
         let uri = &params.text_document_position.text_document.uri;
         let read_guard = self.reactors.read().await;
         let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        if let Some((alias, imported, is_macro_call)) =
+            reactor.namespace_completion_target(&params.text_document_position.position)
+        {
+            return Ok(Some(CompletionResponse::Array(
+                self.complete_namespace_members(&alias, &imported, is_macro_call)
+                    .await,
+            )));
+        }
         reactor.on_completion(params).await
     }
 
+    /// Lists the members of an imported template, which is read on demand if the workspace
+    /// indexer has not reached it
+    async fn complete_namespace_members(
+        &self,
+        alias: &str,
+        imported: &Uri,
+        is_macro_call: bool,
+    ) -> Vec<CompletionItem> {
+        if let Some(symbols) = self.symbol_index.read().await.symbols_of(imported) {
+            return completion::namespace_member_completions(alias, symbols, is_macro_call);
+        }
+        // the imported template is not indexed yet, or it is outside of the workspace folders
+        indexer::read_symbols(imported)
+            .map(|symbols| completion::namespace_member_completions(alias, &symbols, is_macro_call))
+            .unwrap_or_default()
+    }
+
     pub async fn on_goto_definition(
         &self,
         params: GotoDefinitionParams,
@@ -149,7 +396,7 @@ impl Workspace {
         let uri = &params.text_document_position_params.text_document.uri;
         let read_guard = self.reactors.read().await;
         let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
-        reactor.on_goto_definition(params).await
+        reactor.on_goto_definition(params, &read_guard).await
     }
 
     pub async fn on_formatting(
@@ -159,9 +406,38 @@ impl Workspace {
         let uri = &params.text_document.uri;
         let read_guard = self.reactors.read().await;
         let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
         reactor.on_formatting(params).await
     }
 
+    pub async fn on_range_formatting(
+        &self,
+        params: DocumentRangeFormattingParams,
+    ) -> jsonrpc::Result<Option<Vec<TextEdit>>> {
+        let uri = &params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        reactor.on_range_formatting(params).await
+    }
+
+    pub async fn on_type_formatting(
+        &self,
+        params: DocumentOnTypeFormattingParams,
+    ) -> jsonrpc::Result<Option<Vec<TextEdit>>> {
+        let uri = &params.text_document_position.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        reactor.on_type_formatting(params).await
+    }
+
     pub async fn on_folding_range(
         &self,
         params: FoldingRangeParams,
@@ -172,6 +448,103 @@ impl Workspace {
         reactor.on_folding_range(params).await
     }
 
+    pub async fn on_document_symbol(
+        &self,
+        params: DocumentSymbolParams,
+    ) -> jsonrpc::Result<Option<DocumentSymbolResponse>> {
+        let uri = &params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        reactor.on_document_symbol(params).await
+    }
+
+    pub async fn on_inlay_hint(
+        &self,
+        params: InlayHintParams,
+    ) -> jsonrpc::Result<Option<Vec<InlayHint>>> {
+        let uri = &params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        reactor.on_inlay_hint(params).await
+    }
+
+    pub async fn on_linked_editing_range(
+        &self,
+        params: LinkedEditingRangeParams,
+    ) -> jsonrpc::Result<Option<LinkedEditingRanges>> {
+        let uri = &params.text_document_position_params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        reactor.on_linked_editing_range(params).await
+    }
+
+    pub async fn on_references(
+        &self,
+        params: ReferenceParams,
+    ) -> jsonrpc::Result<Option<Vec<Location>>> {
+        let uri = &params.text_document_position.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        reactor.on_references(params).await
+    }
+
+    pub async fn on_prepare_rename(
+        &self,
+        params: TextDocumentPositionParams,
+    ) -> jsonrpc::Result<Option<PrepareRenameResponse>> {
+        let uri = &params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        reactor.on_prepare_rename(params).await
+    }
+
+    pub async fn on_rename(&self, params: RenameParams) -> jsonrpc::Result<Option<WorkspaceEdit>> {
+        let uri = &params.text_document_position.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        reactor.on_rename(params).await
+    }
+
+    pub async fn on_selection_range(
+        &self,
+        params: SelectionRangeParams,
+    ) -> jsonrpc::Result<Option<Vec<SelectionRange>>> {
+        let uri = &params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        reactor.on_selection_range(params).await
+    }
+
+    pub async fn on_signature_help(
+        &self,
+        params: SignatureHelpParams,
+    ) -> jsonrpc::Result<Option<SignatureHelp>> {
+        let uri = &params.text_document_position_params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        reactor.on_signature_help(params).await
+    }
+
+    pub async fn on_workspace_symbol(
+        &self,
+        params: WorkspaceSymbolParams,
+    ) -> jsonrpc::Result<Option<WorkspaceSymbolResponse>> {
+        let symbols = self.symbol_index.read().await.search(&params.query);
+        Ok(Some(WorkspaceSymbolResponse::Flat(symbols)))
+    }
+
     pub async fn on_code_action(
         &self,
         params: CodeActionParams,
@@ -181,4 +554,133 @@ impl Workspace {
         let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
         reactor.on_code_action(params).await
     }
+
+    pub async fn on_code_lens(
+        &self,
+        params: CodeLensParams,
+    ) -> jsonrpc::Result<Option<Vec<CodeLens>>> {
+        let uri = &params.text_document.uri;
+        let read_guard = self.reactors.read().await;
+        let reactor = read_guard.get(uri).expect(GET_REACTOR_EXPECT);
+        if reactor.is_oversized() {
+            return Ok(None);
+        }
+        reactor.on_code_lens(params).await
+    }
+
+    /// Counts the references of the declaration, including the calls from other templates
+    pub async fn on_code_lens_resolve(&self, mut lens: CodeLens) -> jsonrpc::Result<CodeLens> {
+        let Some(target) = LensTarget::from_lens(&lens) else {
+            return Ok(lens);
+        };
+        let read_guard = self.reactors.read().await;
+        // the document might be closed meanwhile
+        let Some(reactor) = read_guard.get(&target.uri) else {
+            return Ok(lens);
+        };
+        if reactor.is_oversized() {
+            return Ok(lens);
+        }
+        let symbol_index = self.symbol_index.read().await;
+        let locations = self.reference_cache.write().await.get_or_insert_with(
+            &target,
+            reactor.version,
+            symbol_index.generation(),
+            || {
+                let mut locations = reactor.find_callable_references(&target.name, target.rule);
+                locations.extend(symbol_index.find_calls(&target.uri, &target.name));
+                locations
+            },
+        );
+        lens.command = Some(lens::references_command(
+            &target.uri,
+            lens.range.start,
+            locations,
+        ));
+        Ok(lens)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::str::FromStr;
+
+    use tower_lsp_server::ls_types::{
+        CodeLensParams, DidOpenTextDocumentParams, DocumentFormattingParams, FormattingOptions,
+        HoverParams, InlayHintParams, Position, Range, ReferenceContext, ReferenceParams,
+        TextDocumentIdentifier, TextDocumentItem, TextDocumentPositionParams, Uri,
+    };
+
+    use crate::{
+        config,
+        workspace::{Workspace, oversized_message},
+    };
+
+    #[tokio::test]
+    async fn test_oversized_document() {
+        let uri = Uri::from_str("file:///generated.ftl").unwrap();
+        let text = format!(
+            "<#macro row>${{x}}</#macro><@row/>{}",
+            " ".repeat(config::max_file_size() as usize)
+        );
+        let workspace = Workspace::new();
+        workspace
+            .on_did_open(&DidOpenTextDocumentParams {
+                text_document: TextDocumentItem::new(uri.clone(), "freemarker".into(), 1, text),
+            })
+            .await;
+        let document = TextDocumentIdentifier::new(uri.clone());
+        let position = TextDocumentPositionParams::new(document.clone(), Position::new(0, 8));
+        let hover = workspace
+            .on_hover(HoverParams {
+                text_document_position_params: position.clone(),
+                work_done_progress_params: Default::default(),
+            })
+            .await;
+        assert_eq!(hover, Ok(None));
+        let references = workspace
+            .on_references(ReferenceParams {
+                text_document_position: position.clone(),
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+                context: ReferenceContext {
+                    include_declaration: true,
+                },
+            })
+            .await;
+        assert_eq!(references, Ok(None));
+        assert_eq!(workspace.on_prepare_rename(position).await, Ok(None));
+        let edits = workspace
+            .on_formatting(DocumentFormattingParams {
+                text_document: document.clone(),
+                options: FormattingOptions {
+                    tab_size: 2,
+                    insert_spaces: true,
+                    ..Default::default()
+                },
+                work_done_progress_params: Default::default(),
+            })
+            .await;
+        assert_eq!(edits, Ok(None));
+        let lenses = workspace
+            .on_code_lens(CodeLensParams {
+                text_document: document.clone(),
+                work_done_progress_params: Default::default(),
+                partial_result_params: Default::default(),
+            })
+            .await;
+        assert_eq!(lenses, Ok(None));
+        let hints = workspace
+            .on_inlay_hint(InlayHintParams {
+                text_document: document,
+                range: Range::new(Position::new(0, 0), Position::new(1, 0)),
+                work_done_progress_params: Default::default(),
+            })
+            .await;
+        assert_eq!(hints, Ok(None));
+        assert_eq!(
+            oversized_message(&uri),
+            "file:///generated.ftl is larger than 2097152 bytes, only syntax highlighting is provided"
+        );
+    }
 }